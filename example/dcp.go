@@ -11,7 +11,9 @@
 package main
 
 import (
+	"bytes"
 	"crypto/aes"
+	"crypto/sha256"
 	"fmt"
 	"strings"
 
@@ -52,10 +54,48 @@ func testKeyDerivation() (err error) {
 	return
 }
 
+func testHash() (err error) {
+	data := bytes.Repeat([]byte(banner), 100)
+	expected := sha256.Sum256(data)
+
+	h, err := imx6.DCP.NewSHA256()
+
+	if err != nil {
+		return
+	}
+
+	// write arbitrary sized chunks
+	for i := 0; i < len(data); i += 33 {
+		end := i + 33
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if _, err = h.Write(data[i:end]); err != nil {
+			return
+		}
+	}
+
+	sum := h.Sum(nil)
+
+	if !bytes.Equal(sum, expected[:]) {
+		return fmt.Errorf("sha256:%x != expected:%x\n", sum, expected)
+	}
+
+	fmt.Printf("imx6_dcp: sha256 %x\n", sum)
+
+	return
+}
+
 func TestDCP() {
 	imx6.DCP.Init()
 
 	if err := testKeyDerivation(); err != nil {
 		fmt.Printf("imx6_dcp: error, %v\n", err)
 	}
+
+	if err := testHash(); err != nil {
+		fmt.Printf("imx6_dcp: error, %v\n", err)
+	}
 }
@@ -177,7 +177,7 @@ func main() {
 	fmt.Printf("-- memory allocation (%d runs) ----------------------------------------\n", runs)
 	testAlloc(runs, chunks, chunkSize)
 
-	if imx6.Native && (imx6.Family == imx6.IMX6UL || imx6.Family == imx6.IMX6ULL) {
+	if imx6.Native && (imx6.Family == imx6.IMX6UL || imx6.Family == imx6.IMX6ULL || imx6.Family == imx6.IMX6ULZ) {
 		fmt.Println("-- i.mx6 usb ---------------------------------------------------------")
 		StartUSBEthernet()
 	}
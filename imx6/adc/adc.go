@@ -0,0 +1,191 @@
+// NXP Analog-to-Digital Converter (ADC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package adc implements a driver for the NXP i.MX6 12-bit Analog-to-Digital
+// Converter (ADC), performing single software triggered conversions.
+//
+// The ADC input pads must be configured (see package iomuxc) before use, for
+// example to read channel 1 against a 3.3V reference:
+//
+//	adc.ADC1.Init()
+//	raw, err := adc.ADC1.Read(1)
+//	mV := adc.Millivolts(raw, 3300)
+package adc
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// ADC Memory Map/Register Definition, IMX6ULLRM
+const (
+	ADC1_BASE uint32 = 0x02198000
+
+	ADC_HC0      = 0x00
+	ADC_HC0_AIEN = 7
+	ADC_HC0_ADCH = 0
+
+	ADC_HS       = 0x08
+	ADC_HS_COCO0 = 0
+
+	ADC_R0 = 0x0c
+
+	ADC_CFG        = 0x14
+	ADC_CFG_AVGS   = 14
+	ADC_CFG_ADTRG  = 13
+	ADC_CFG_ADHSC  = 10
+	ADC_CFG_ADSTS  = 8
+	ADC_CFG_ADLPC  = 7
+	ADC_CFG_ADIV   = 5
+	ADC_CFG_ADLSMP = 4
+	ADC_CFG_MODE   = 2
+	ADC_CFG_ADICLK = 0
+
+	ADC_GC      = 0x18
+	ADC_GC_CAL  = 7
+	ADC_GC_ADCO = 6
+	ADC_GC_AVGE = 5
+
+	ADC_GS      = 0x1c
+	ADC_GS_CALF = 1
+
+	// 12-bit conversion (CFG MODE field)
+	MODE_12BIT = 0b10
+	// input clock divided by 2 (CFG ADIV field)
+	ADIV_2 = 0b01
+	// 32 samples averaged (CFG AVGS field)
+	AVGS_32 = 0b11
+
+	// number of external input channels
+	CHANNELS = 16
+
+	// conversion resolution in bits
+	RESOLUTION = 12
+	// maximum conversion result
+	MAX_VALUE = 1<<RESOLUTION - 1
+)
+
+// Timeout is the maximum duration for a conversion or calibration.
+var Timeout = 10 * time.Millisecond
+
+type adc struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	hc0 *uint32
+	hs  *uint32
+	r0  *uint32
+	cfg *uint32
+	gc  *uint32
+	gs  *uint32
+}
+
+func newADC(base uint32, cg int) *adc {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &adc{
+		cg:  cg,
+		hc0: r(ADC_HC0),
+		hs:  r(ADC_HS),
+		r0:  r(ADC_R0),
+		cfg: r(ADC_CFG),
+		gc:  r(ADC_GC),
+		gs:  r(ADC_GS),
+	}
+}
+
+var ADC1 = newADC(ADC1_BASE, imx6.CCM_CCGR1_ADC1)
+
+// Init initializes the ADC for 12-bit software triggered conversions, clocked
+// from the IPG clock divided by 2, and runs the self-calibration sequence.
+func (hw *adc) Init() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if err = imx6.EnableClock(1, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	// software trigger, long sample time, 12-bit, IPG clock / 2
+	reg.Write(hw.cfg, ADIV_2<<ADC_CFG_ADIV|
+		1<<ADC_CFG_ADLSMP|
+		MODE_12BIT<<ADC_CFG_MODE)
+
+	reg.Write(hw.gc, 0)
+
+	return hw.calibrate()
+}
+
+// calibrate runs the ADC self-calibration sequence, which requires averaging
+// of 32 samples (Calibration function, IMX6ULLRM).
+func (hw *adc) calibrate() (err error) {
+	cfg := reg.Read(hw.cfg)
+
+	reg.SetN(hw.cfg, ADC_CFG_AVGS, 0b11, AVGS_32)
+	reg.Set(hw.gc, ADC_GC_AVGE)
+
+	// clear any previous failure
+	reg.Set(hw.gs, ADC_GS_CALF)
+	reg.Set(hw.gc, ADC_GC_CAL)
+
+	if !reg.WaitFor(Timeout, hw.gc, ADC_GC_CAL, 0b1, 0) {
+		err = errors.New("calibration timeout")
+	} else if reg.Get(hw.gs, ADC_GS_CALF, 0b1) == 1 {
+		err = errors.New("calibration failure")
+	}
+
+	// the calibration sets the conversion complete flag, clear it by
+	// reading the result
+	reg.Read(hw.r0)
+
+	reg.Clear(hw.gc, ADC_GC_AVGE)
+	reg.Write(hw.cfg, cfg)
+
+	return
+}
+
+// Read performs a single conversion on the argument external input channel
+// (0-15), returning its raw result.
+func (hw *adc) Read(channel int) (raw uint16, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if channel < 0 || channel >= CHANNELS {
+		return 0, errors.New("invalid channel")
+	}
+
+	// writing the channel starts a conversion
+	reg.Write(hw.hc0, uint32(channel)<<ADC_HC0_ADCH)
+
+	if !reg.WaitFor(Timeout, hw.hs, ADC_HS_COCO0, 0b1, 1) {
+		return 0, errors.New("conversion timeout")
+	}
+
+	// reading the result clears the conversion complete flag
+	raw = uint16(reg.Read(hw.r0) & MAX_VALUE)
+
+	return
+}
+
+// Millivolts converts a raw conversion result to millivolts, given the ADC
+// reference voltage (in millivolts).
+func Millivolts(raw uint16, vref int) int {
+	return int(raw) * vref / MAX_VALUE
+}
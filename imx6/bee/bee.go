@@ -0,0 +1,200 @@
+// NXP Bus Encryption Engine (BEE) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package bee implements a driver for the NXP i.MX6UL Bus Encryption Engine
+// (BEE), which transparently decrypts AXI accesses to its two aliased address
+// regions, each mapped to a configurable physical memory area (e.g. the
+// QuadSPI flash AHB window for execute-in-place of encrypted code):
+//
+//	bee.Init()
+//	bee.SetRegion(0, 0x60000000, bee.AES_CTR, nonce)
+//	bee.Enable()
+//	bee.Lock()
+//
+// By default the AES-128 key is taken from the SNVS (OTPMK derived), which is
+// the only key source which must be used in a locked down configuration as
+// the key never becomes accessible to software. A software key can be set
+// with SetKey() for development purposes only.
+package bee
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// BEE Memory Map/Register Definition, IMX6ULRM
+const (
+	BEE_BASE uint32 = 0x02044000
+
+	BEE_CTRL                   = 0x00
+	BEE_CTRL_LOCK              = 16
+	BEE_CTRL_SECURITY_LEVEL_R1 = 12
+	BEE_CTRL_AES_MODE_R1       = 10
+	BEE_CTRL_SECURITY_LEVEL_R0 = 8
+	BEE_CTRL_AES_MODE_R0       = 7
+	BEE_CTRL_AES_KEY_SEL       = 5
+	BEE_CTRL_KEY_VALID         = 4
+	BEE_CTRL_SFTRST_N          = 2
+	BEE_CTRL_CLK_EN            = 1
+	BEE_CTRL_BEE_ENABLE        = 0
+
+	BEE_ADDR_OFFSET0  = 0x04
+	BEE_ADDR_OFFSET1  = 0x08
+	BEE_AES_KEY0_W0   = 0x0c
+	BEE_STATUS        = 0x1c
+	BEE_CTR_NONCE0_W0 = 0x20
+	BEE_CTR_NONCE1_W0 = 0x30
+
+	// aliased address regions
+	ALIAS_REGION0 = 0x10000000
+	ALIAS_REGION1 = 0x30000000
+	// region address offset granularity
+	OFFSET_ALIGN = 1 << 16
+
+	// AES modes (CTRL AES_MODE_Rn field)
+	AES_ECB = 0
+	AES_CTR = 1
+
+	// AES-128 key and CTR nonce size
+	KEY_SIZE   = 16
+	NONCE_SIZE = 16
+)
+
+var mutex sync.Mutex
+
+var ctrl = register(BEE_CTRL)
+
+func register(off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(BEE_BASE + off)))
+}
+
+func write(off uint32, buf []byte) {
+	for i := 0; i < len(buf); i += 4 {
+		reg.Write(register(off+uint32(i)), binary.LittleEndian.Uint32(buf[i:]))
+	}
+}
+
+// Init resets the BEE and enables its clock, selecting the SNVS AES key.
+func Init() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// toggle the active low soft reset
+	reg.Clear(ctrl, BEE_CTRL_SFTRST_N)
+	reg.Set(ctrl, BEE_CTRL_SFTRST_N)
+
+	reg.Set(ctrl, BEE_CTRL_CLK_EN)
+	reg.Clear(ctrl, BEE_CTRL_AES_KEY_SEL)
+}
+
+// SetKey selects a software provided AES-128 key, replacing the SNVS key.
+//
+// Software keys are accessible to any code running before Lock(), therefore
+// they should only be used for development and never in a locked down
+// (secure boot) configuration.
+func SetKey(key []byte) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(key) != KEY_SIZE {
+		return errors.New("invalid key size")
+	}
+
+	write(BEE_AES_KEY0_W0, key)
+	reg.Set(ctrl, BEE_CTRL_AES_KEY_SEL)
+
+	return
+}
+
+// SetRegion maps an aliased region (0 or 1) to the argument physical address,
+// which must be 64KB aligned, with the argument AES mode (AES_ECB or AES_CTR).
+// In AES_CTR mode the 16 bytes nonce, used to form the counter along with the
+// access address, must be passed.
+//
+// Accesses to ALIAS_REGION0 (or ALIAS_REGION1) plus any offset are decrypted
+// from the corresponding offset of the physical address.
+func SetRegion(n int, addr uint32, mode int, nonce []byte) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var alias uint32
+	var offset uint32
+	var nonceReg uint32
+	var modePos int
+
+	switch n {
+	case 0:
+		alias = ALIAS_REGION0
+		offset = BEE_ADDR_OFFSET0
+		nonceReg = BEE_CTR_NONCE0_W0
+		modePos = BEE_CTRL_AES_MODE_R0
+	case 1:
+		alias = ALIAS_REGION1
+		offset = BEE_ADDR_OFFSET1
+		nonceReg = BEE_CTR_NONCE1_W0
+		modePos = BEE_CTRL_AES_MODE_R1
+	default:
+		return errors.New("invalid region")
+	}
+
+	if addr%OFFSET_ALIGN != 0 {
+		return errors.New("invalid region address alignment")
+	}
+
+	switch mode {
+	case AES_ECB:
+	case AES_CTR:
+		if len(nonce) != NONCE_SIZE {
+			return errors.New("invalid nonce size")
+		}
+
+		write(nonceReg, nonce)
+	default:
+		return errors.New("invalid AES mode")
+	}
+
+	// the offset (in 64KB units) is added to aliased addresses
+	reg.Write(register(offset), (addr-alias)>>16)
+	reg.SetN(ctrl, modePos, 0b1, uint32(mode))
+
+	return
+}
+
+// Enable validates the selected key and enables decryption of the aliased
+// regions configured with SetRegion().
+func Enable() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reg.Set(ctrl, BEE_CTRL_KEY_VALID)
+	reg.Set(ctrl, BEE_CTRL_BEE_ENABLE)
+}
+
+// Disable disables decryption of the aliased regions.
+func Disable() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reg.Clear(ctrl, BEE_CTRL_BEE_ENABLE)
+}
+
+// Lock sets all BEE_CTRL lock bits, preventing any further change to the BEE
+// configuration until the next reset.
+func Lock() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reg.SetN(ctrl, BEE_CTRL_LOCK, 0xffff, 0xffff)
+}
@@ -0,0 +1,225 @@
+// NXP Cryptographic Acceleration and Assurance Module (CAAM) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package caam implements a driver for the NXP i.MX6 Cryptographic
+// Acceleration and Assurance Module (CAAM), available on i.MX6UL parts,
+// supporting RNG4 random number generation and SHA-256 hashing through job
+// descriptors submitted on job ring 0:
+//
+//	caam.CAAM.Init()
+//	buf := make([]byte, 32)
+//	caam.CAAM.Read(buf)
+//
+// Descriptors and their buffers are allocated on the Go heap, cache line
+// aligned, with the data cache flushed before and after each job.
+package caam
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// CAAM Memory Map/Register Definition, IMX6ULSRM
+const (
+	CAAM_BASE uint32 = 0x02140000
+
+	// job ring 0 registers
+	CAAM_JR0_BASE = CAAM_BASE + 0x1000
+
+	CAAM_IRBAR  = 0x00
+	CAAM_IRSR   = 0x0c
+	CAAM_IRSAR  = 0x14
+	CAAM_IRJAR  = 0x1c
+	CAAM_ORBAR  = 0x20
+	CAAM_ORSR   = 0x2c
+	CAAM_ORJRR  = 0x34
+	CAAM_ORSFR  = 0x3c
+	CAAM_JRSTAR = 0x44
+	CAAM_JRCFGR = 0x54
+	CAAM_JRCR   = 0x6c
+
+	CAAM_JRCFGR_IMSK = 0
+
+	CAAM_JRCR_RESET = 0
+
+	// job ring size (entries)
+	RING_SIZE = 4
+
+	// DMA buffer alignment
+	cacheLineSize = 64
+)
+
+// Timeout is the maximum duration for job completion.
+var Timeout = 1 * time.Second
+
+type caam struct {
+	sync.Mutex
+
+	// 64-bit ring base addresses, most significant word first
+	irbarHi *uint32
+	irbarLo *uint32
+	orbarHi *uint32
+	orbarLo *uint32
+
+	irsr   *uint32
+	irjar  *uint32
+	orsr   *uint32
+	orjrr  *uint32
+	orsfr  *uint32
+	jrcfgr *uint32
+
+	// input and output rings
+	in  *mem.AlignmentBuffer
+	out *mem.AlignmentBuffer
+
+	// ring indexes
+	inIdx  int
+	outIdx int
+
+	init bool
+}
+
+func newCAAM(base uint32) *caam {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &caam{
+		irbarHi: r(CAAM_IRBAR),
+		irbarLo: r(CAAM_IRBAR + 4),
+		orbarHi: r(CAAM_ORBAR),
+		orbarLo: r(CAAM_ORBAR + 4),
+		irsr:    r(CAAM_IRSR),
+		irjar:   r(CAAM_IRJAR),
+		orsr:    r(CAAM_ORSR),
+		orjrr:   r(CAAM_ORJRR),
+		orsfr:   r(CAAM_ORSFR),
+		jrcfgr:  r(CAAM_JRCFGR),
+	}
+}
+
+var CAAM = newCAAM(CAAM_JR0_BASE)
+
+// Init initializes job ring 0 and, unless already performed by the boot ROM,
+// instantiates the RNG4 state handle 0.
+func (hw *caam) Init() (err error) {
+	hw.Lock()
+
+	imx6.EnableClock(0, imx6.CCM_CCGR0_CAAM_SECURE_MEM, imx6.CCGR_ON)
+	imx6.EnableClock(0, imx6.CCM_CCGR0_CAAM_ACLK, imx6.CCGR_ON)
+	imx6.EnableClock(0, imx6.CCM_CCGR0_CAAM_IPG, imx6.CCGR_ON)
+
+	// input ring entries hold a descriptor pointer, output ring entries
+	// a descriptor pointer followed by its completion status
+	hw.in = mem.NewAlignmentBuffer(RING_SIZE*4, cacheLineSize)
+	hw.out = mem.NewAlignmentBuffer(RING_SIZE*8, cacheLineSize)
+	hw.inIdx = 0
+	hw.outIdx = 0
+
+	cache.FlushData()
+
+	reg.Write(hw.irbarHi, 0)
+	reg.Write(hw.irbarLo, uint32(hw.in.Addr))
+	reg.Write(hw.orbarHi, 0)
+	reg.Write(hw.orbarLo, uint32(hw.out.Addr))
+
+	reg.Write(hw.irsr, RING_SIZE)
+	reg.Write(hw.orsr, RING_SIZE)
+
+	// completion is polled, mask job ring interrupts
+	reg.Set(hw.jrcfgr, CAAM_JRCFGR_IMSK)
+
+	hw.init = true
+	hw.Unlock()
+
+	return hw.instantiateRNG()
+}
+
+// wordBuffer returns a cache line aligned buffer holding the argument words.
+func wordBuffer(words []uint32) *mem.AlignmentBuffer {
+	buf := mem.NewAlignmentBuffer(uintptr(len(words)*4), cacheLineSize)
+
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(buf.Data()[i*4:], w)
+	}
+
+	return buf
+}
+
+// run submits a job descriptor on job ring 0 and waits for its completion,
+// a non zero completion status is returned as error.
+func (hw *caam) run(desc []uint32) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.init {
+		return errors.New("CAAM is not initialized")
+	}
+
+	// descriptor header length
+	desc[0] |= uint32(len(desc))
+
+	jd := wordBuffer(desc)
+	addr := uint32(jd.Addr)
+
+	binary.LittleEndian.PutUint32(hw.in.Data()[hw.inIdx*4:], addr)
+	hw.inIdx = (hw.inIdx + 1) % RING_SIZE
+
+	// flush D cache just before adding the job to the ring
+	cache.FlushData()
+	reg.Write(hw.irjar, 1)
+
+	start := time.Now()
+
+	for reg.Read(hw.orsfr) == 0 {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+
+		if time.Since(start) >= Timeout {
+			return errors.New("job timeout")
+		}
+	}
+
+	// invalidate D cache to observe CAAM results
+	cache.FlushData()
+
+	entry := hw.out.Data()[hw.outIdx*8:]
+	hw.outIdx = (hw.outIdx + 1) % RING_SIZE
+
+	ptr := binary.LittleEndian.Uint32(entry[0:4])
+	status := binary.LittleEndian.Uint32(entry[4:8])
+
+	reg.Write(hw.orjrr, 1)
+
+	// keep the descriptor referenced until completion
+	runtime.KeepAlive(jd)
+
+	if ptr != addr {
+		return fmt.Errorf("unexpected job descriptor %#x", ptr)
+	}
+
+	if status != 0 {
+		return fmt.Errorf("job status %#x", status)
+	}
+
+	return
+}
@@ -0,0 +1,57 @@
+// NXP Cryptographic Acceleration and Assurance Module (CAAM) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package caam
+
+// CAAM descriptor commands, IMX6ULSRM
+const (
+	CMD_STORE      = 0x0a << 27
+	CMD_FIFO_LOAD  = 0x04 << 27
+	CMD_FIFO_STORE = 0x0c << 27
+	CMD_OPERATION  = 0x10 << 27
+	CMD_JUMP       = 0x14 << 27
+	CMD_DESC_HDR   = 0x16 << 27
+
+	// job descriptor header
+	HDR_ONE = 1 << 23
+
+	// OPERATION command
+	OP_TYPE_CLASS1_ALG   = 0x02 << 24
+	OP_TYPE_CLASS2_ALG   = 0x04 << 24
+	OP_ALG_ALGSEL_SHA256 = 0x43 << 16
+	OP_ALG_ALGSEL_RNG    = 0x50 << 16
+	OP_ALG_AS_INIT       = 0x01 << 2
+	OP_ALG_AS_INITFINAL  = 0x03 << 2
+	OP_ALG_PR_ON         = 1 << 1
+
+	// JUMP command
+	JUMP_CLASS_CLASS1 = 1 << 25
+	JUMP_TYPE_HALT    = 0x02 << 22
+
+	// FIFO LOAD/STORE commands
+	FIFOLD_CLASS_CLASS2 = 0x02 << 25
+	FIFOLDST_EXT        = 1 << 22
+	FIFOLD_TYPE_MSG     = 0x10 << 16
+	FIFOLD_TYPE_LAST2   = 0x04 << 16
+	FIFOST_TYPE_RNG     = 0x34 << 16
+
+	// STORE command
+	LDST_CLASS_2_CCB         = 0x02 << 25
+	LDST_SRCDST_BYTE_CONTEXT = 0x20 << 16
+
+	// maximum FIFO STORE length without extended length
+	MAX_FIFO_LENGTH = 0xffff
+)
+
+// jobHeader returns a job descriptor header, its length is set on submission.
+func jobHeader() uint32 {
+	return CMD_DESC_HDR | HDR_ONE
+}
@@ -0,0 +1,54 @@
+// NXP Cryptographic Acceleration and Assurance Module (CAAM) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package caam
+
+import (
+	"crypto/sha256"
+	"runtime"
+
+	"github.com/inversepath/tamago/imx6/internal/mem"
+)
+
+// Sum256 returns the SHA-256 checksum of the argument data, computed with
+// the CAAM Message Digest Hardware Accelerator (MDHA).
+func (hw *caam) Sum256(data []byte) (sum [sha256.Size]byte, err error) {
+	if len(data) == 0 {
+		// zero length messages are computed in software
+		return sha256.Sum256(nil), nil
+	}
+
+	src := mem.NewAlignmentBuffer(uintptr(len(data)), cacheLineSize)
+	mem.Copy(src, data)
+
+	digest := mem.NewAlignmentBuffer(sha256.Size, cacheLineSize)
+
+	desc := []uint32{
+		jobHeader(),
+		CMD_OPERATION | OP_TYPE_CLASS2_ALG | OP_ALG_ALGSEL_SHA256 | OP_ALG_AS_INITFINAL,
+		// message, with extended length
+		CMD_FIFO_LOAD | FIFOLD_CLASS_CLASS2 | FIFOLDST_EXT | FIFOLD_TYPE_MSG | FIFOLD_TYPE_LAST2,
+		uint32(src.Addr),
+		uint32(len(data)),
+		// resulting digest from the class 2 context
+		CMD_STORE | LDST_CLASS_2_CCB | LDST_SRCDST_BYTE_CONTEXT | sha256.Size,
+		uint32(digest.Addr),
+	}
+
+	if err = hw.run(desc); err != nil {
+		return
+	}
+
+	copy(sum[:], digest.Data()[0:sha256.Size])
+	runtime.KeepAlive(src)
+
+	return
+}
@@ -0,0 +1,109 @@
+// NXP Cryptographic Acceleration and Assurance Module (CAAM) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package caam
+
+import (
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// RNG4 TRNG registers, IMX6ULSRM
+const (
+	CAAM_RTMCTL           = CAAM_BASE + 0x600
+	CAAM_RTMCTL_PRGM      = 16
+	CAAM_RTMCTL_ACC       = 5
+	CAAM_RTMCTL_SAMP_MODE = 0
+
+	CAAM_RTSDCTL         = CAAM_BASE + 0x610
+	CAAM_RTSDCTL_ENT_DLY = 16
+
+	CAAM_RTFRQMIN = CAAM_BASE + 0x618
+	CAAM_RTFRQMAX = CAAM_BASE + 0x61c
+
+	CAAM_RDSTA     = CAAM_BASE + 0x6c0
+	CAAM_RDSTA_IF0 = 0
+
+	// von Neumann data and raw samples (RTMCTL SAMP_MODE field)
+	SAMP_MODE_RAW_ES_SC = 0b01
+
+	// entropy delay, in system clock cycles
+	RNG_ENT_DLY = 3200
+	// frequency counter maximum limit disable
+	RNG_FRQMAX_DISABLE = 1 << 20
+)
+
+// instantiateRNG instantiates the RNG4 state handle 0 with prediction
+// resistance, unless already instantiated.
+func (hw *caam) instantiateRNG() (err error) {
+	rdsta := (*uint32)(unsafe.Pointer(uintptr(CAAM_RDSTA)))
+
+	if reg.Get(rdsta, CAAM_RDSTA_IF0, 0b1) == 1 {
+		return
+	}
+
+	rtmctl := (*uint32)(unsafe.Pointer(uintptr(CAAM_RTMCTL)))
+	rtsdctl := (*uint32)(unsafe.Pointer(uintptr(CAAM_RTSDCTL)))
+	rtfrqmin := (*uint32)(unsafe.Pointer(uintptr(CAAM_RTFRQMIN)))
+	rtfrqmax := (*uint32)(unsafe.Pointer(uintptr(CAAM_RTFRQMAX)))
+
+	// program the TRNG entropy delay and frequency limits
+	reg.Set(rtmctl, CAAM_RTMCTL_PRGM)
+	reg.SetN(rtsdctl, CAAM_RTSDCTL_ENT_DLY, 0xffff, RNG_ENT_DLY)
+	reg.Write(rtfrqmin, RNG_ENT_DLY>>2)
+	reg.Write(rtfrqmax, RNG_FRQMAX_DISABLE)
+
+	// return to run mode
+	reg.SetN(rtmctl, CAAM_RTMCTL_SAMP_MODE, 0b11, SAMP_MODE_RAW_ES_SC)
+	reg.Clear(rtmctl, CAAM_RTMCTL_ACC)
+	reg.Clear(rtmctl, CAAM_RTMCTL_PRGM)
+
+	desc := []uint32{
+		jobHeader(),
+		CMD_OPERATION | OP_TYPE_CLASS1_ALG | OP_ALG_ALGSEL_RNG | OP_ALG_AS_INIT | OP_ALG_PR_ON,
+		// wait for class 1 completion
+		CMD_JUMP | JUMP_CLASS_CLASS1 | JUMP_TYPE_HALT,
+	}
+
+	return hw.run(desc)
+}
+
+// Read fills the argument buffer with random data from the RNG4 DRBG, it
+// implements io.Reader and it is therefore suitable for use as a crypto/rand
+// Reader (e.g. `rand.Reader = caam.CAAM`).
+func (hw *caam) Read(b []byte) (n int, err error) {
+	for n < len(b) {
+		size := len(b) - n
+
+		if size > MAX_FIFO_LENGTH {
+			size = MAX_FIFO_LENGTH
+		}
+
+		buf := mem.NewAlignmentBuffer(uintptr(size), cacheLineSize)
+
+		desc := []uint32{
+			jobHeader(),
+			CMD_OPERATION | OP_TYPE_CLASS1_ALG | OP_ALG_ALGSEL_RNG | OP_ALG_PR_ON,
+			CMD_FIFO_STORE | FIFOST_TYPE_RNG | uint32(size),
+			uint32(buf.Addr),
+		}
+
+		if err = hw.run(desc); err != nil {
+			return
+		}
+
+		n += copy(b[n:], buf.Data()[0:size])
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+// NXP i.MX6 cache control
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+
+	"github.com/inversepath/tamago/imx6/internal/cache"
+)
+
+// PL310 L2 cache controller base address (i.MX6Q only), IMX6DQRM
+const L2X0_BASE uint32 = 0x00a02000
+
+// EnableL1Cache invalidates, and then enables, the L1 instruction and data
+// caches.
+//
+// With the MMU disabled all data accesses are treated as Strongly-ordered,
+// therefore the data cache has effect only after the MMU is enabled (see
+// package mmu) with peripheral space mapped as Device memory.
+func EnableL1Cache() {
+	cache.EnableL1()
+}
+
+// DisableL1Cache disables, and then cleans and invalidates, the L1
+// instruction and data caches.
+func DisableL1Cache() {
+	cache.DisableL1()
+}
+
+// EnableL2Cache invalidates, and then enables, the L2 cache.
+//
+// On the i.MX6UL, i.MX6ULL and i.MX6ULZ the Cortex-A7 integrated L2 cache is
+// controlled, together with the L1 data cache, by EnableL1Cache(), while on
+// the i.MX6Q the PL310 L2 cache controller is enabled.
+func EnableL2Cache() (err error) {
+	switch Family {
+	case IMX6Q:
+		cache.EnableL2(L2X0_BASE)
+	case IMX6UL, IMX6ULL, IMX6ULZ:
+		return errors.New("integrated L2 cache, use EnableL1Cache()")
+	default:
+		return ErrUnsupportedFamily
+	}
+
+	return
+}
+
+// DisableL2Cache cleans and invalidates, and then disables, the L2 cache,
+// see EnableL2Cache() for family specific behaviour.
+func DisableL2Cache() (err error) {
+	switch Family {
+	case IMX6Q:
+		cache.FlushData()
+		cache.DisableL2(L2X0_BASE)
+	case IMX6UL, IMX6ULL, IMX6ULZ:
+		return errors.New("integrated L2 cache, use DisableL1Cache()")
+	default:
+		return ErrUnsupportedFamily
+	}
+
+	return
+}
@@ -0,0 +1,149 @@
+// NXP i.MX6UL peripheral clock gating control
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// CCM Clock Gating Registers (CCM_CCGR0-6), IMX6ULLRM
+const (
+	CCM_CCGR0 uint32 = 0x020c4068
+	CCM_CCGR1 uint32 = 0x020c406c
+	CCM_CCGR2 uint32 = 0x020c4070
+	CCM_CCGR3 uint32 = 0x020c4074
+	CCM_CCGR4 uint32 = 0x020c4078
+	CCM_CCGR5 uint32 = 0x020c407c
+	CCM_CCGR6 uint32 = 0x020c4080
+
+	// clock gate modes
+	CCGR_OFF = 0b00
+	CCGR_RUN = 0b01
+	CCGR_ON  = 0b11
+)
+
+// Common peripheral clock gates, expressed as bit position within their
+// CCGR register (CCGR Mapping table, IMX6ULLRM).
+const (
+	CCM_CCGR0_CAAM_SECURE_MEM = 8
+	CCM_CCGR0_CAAM_ACLK       = 10
+	CCM_CCGR0_CAAM_IPG        = 12
+	CCM_CCGR0_CAN1            = 14
+	CCM_CCGR0_CAN1_SERIAL     = 16
+	CCM_CCGR0_CAN2            = 18
+	CCM_CCGR0_CAN2_SERIAL     = 20
+	CCM_CCGR0_UART2           = 28
+	CCM_CCGR0_GPIO2           = 30
+
+	CCM_CCGR1_ECSPI1      = 0
+	CCM_CCGR1_ECSPI2      = 2
+	CCM_CCGR1_ECSPI3      = 4
+	CCM_CCGR1_ECSPI4      = 6
+	CCM_CCGR1_UART3       = 10
+	CCM_CCGR1_EPIT1       = 12
+	CCM_CCGR1_EPIT2       = 14
+	CCM_CCGR1_ADC1        = 16
+	CCM_CCGR1_GPT1_BUS    = 20
+	CCM_CCGR1_GPT1_SERIAL = 22
+	CCM_CCGR1_UART4       = 24
+	CCM_CCGR1_GPIO1       = 26
+	CCM_CCGR1_GPIO5       = 30
+
+	CCM_CCGR2_I2C1  = 6
+	CCM_CCGR2_I2C2  = 8
+	CCM_CCGR2_I2C3  = 10
+	CCM_CCGR2_OCOTP = 12
+	CCM_CCGR2_GPIO3 = 26
+	CCM_CCGR2_LCD   = 28
+
+	CCM_CCGR3_UART5      = 2
+	CCM_CCGR3_ENET       = 4
+	CCM_CCGR3_UART6      = 6
+	CCM_CCGR3_LCDIF1_PIX = 10
+	CCM_CCGR3_GPIO4      = 12
+	CCM_CCGR3_QSPI       = 14
+	CCM_CCGR3_WDOG1      = 16
+
+	CCM_CCGR4_PWM1 = 16
+	CCM_CCGR4_PWM2 = 18
+	CCM_CCGR4_PWM3 = 20
+	CCM_CCGR4_PWM4 = 22
+
+	CCM_CCGR5_SDMA    = 6
+	CCM_CCGR5_KPP     = 8
+	CCM_CCGR5_SNVS_HP = 18
+	CCM_CCGR5_SNVS_LP = 20
+	CCM_CCGR5_SAI3    = 22
+	CCM_CCGR5_UART1   = 24
+	CCM_CCGR5_UART7   = 26
+	CCM_CCGR5_SAI1    = 28
+	CCM_CCGR5_SAI2    = 30
+
+	CCM_CCGR6_USBOH3 = 0
+	CCM_CCGR6_USDHC1 = 2
+	CCM_CCGR6_USDHC2 = 4
+	CCM_CCGR6_UART8  = 14
+)
+
+// ClockGate identifies a peripheral clock gate by its CCGR register index
+// (0-6) and gate bit position.
+type ClockGate struct {
+	CCGR int
+	Pos  int
+}
+
+func ccgrRegister(ccgr int, pos int) (r *uint32, err error) {
+	if ccgr < 0 || ccgr > 6 {
+		return nil, errors.New("invalid CCGR register index")
+	}
+
+	if pos < 0 || pos > 30 || pos%2 != 0 {
+		return nil, errors.New("invalid clock gate position")
+	}
+
+	return (*uint32)(unsafe.Pointer(uintptr(CCM_CCGR0 + uint32(4*ccgr)))), nil
+}
+
+// EnableClock sets the gate mode (CCGR_RUN or CCGR_ON) for a peripheral
+// clock, identified by its CCGR register index (0-6) and gate bit position.
+func EnableClock(ccgr int, pos int, mode uint32) (err error) {
+	if mode != CCGR_RUN && mode != CCGR_ON {
+		return errors.New("invalid clock gate mode")
+	}
+
+	r, err := ccgrRegister(ccgr, pos)
+
+	if err != nil {
+		return
+	}
+
+	reg.SetN(r, pos, 0b11, mode)
+
+	return
+}
+
+// DisableClock gates a peripheral clock, identified by its CCGR register
+// index (0-6) and gate bit position.
+func DisableClock(ccgr int, pos int) (err error) {
+	r, err := ccgrRegister(ccgr, pos)
+
+	if err != nil {
+		return
+	}
+
+	reg.ClearN(r, pos, 0b11)
+
+	return
+}
@@ -0,0 +1,301 @@
+// NXP i.MX6UL clock root frequencies
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// CCM Bus Clock Divider Register, IMX6ULLRM
+	CCM_CBCDR                  uint32 = 0x020c4014
+	CCM_CBCDR_PERIPH_CLK2_PODF        = 27
+	CCM_CBCDR_PERIPH_CLK_SEL          = 25
+	CCM_CBCDR_AHB_PODF                = 10
+	CCM_CBCDR_IPG_PODF                = 8
+
+	// CCM Bus Clock Multiplexer Register, IMX6ULLRM
+	CCM_CBCMR                    uint32 = 0x020c4018
+	CCM_CBCMR_LCDIF1_PODF               = 23
+	CCM_CBCMR_PRE_PERIPH_CLK_SEL        = 18
+	CCM_CBCMR_PERIPH_CLK2_SEL           = 12
+
+	// CCM Serial Clock Multiplexer Register 1, IMX6ULLRM
+	CCM_CSCMR1                uint32 = 0x020c401c
+	CCM_CSCMR1_USDHC2_CLK_SEL        = 17
+	CCM_CSCMR1_SAI3_CLK_SEL          = 14
+	CCM_CSCMR1_SAI2_CLK_SEL          = 12
+	CCM_CSCMR1_SAI1_CLK_SEL          = 10
+	CCM_CSCMR1_USDHC1_CLK_SEL        = 16
+	CCM_CSCMR1_PERCLK_SEL            = 6
+	CCM_CSCMR1_PERCLK_PODF           = 0
+
+	// SAIn_CLK_SEL: PLL4 main clock
+	SAI_CLK_PLL4 = 0b10
+
+	// CCM Clock Divider Registers (CS1CDR, CS2CDR), IMX6ULLRM
+	CCM_CS1CDR               uint32 = 0x020c4028
+	CCM_CS1CDR_SAI1_CLK_PRED        = 22
+	CCM_CS1CDR_SAI1_CLK_PODF        = 16
+	CCM_CS1CDR_SAI3_CLK_PRED        = 6
+	CCM_CS1CDR_SAI3_CLK_PODF        = 0
+	CCM_CS2CDR               uint32 = 0x020c402c
+	CCM_CS2CDR_SAI2_CLK_PRED        = 6
+	CCM_CS2CDR_SAI2_CLK_PODF        = 0
+
+	// CCM Serial Clock Divider Register 1, IMX6ULLRM
+	CCM_CSCDR1_USDHC2_PODF = 16
+	CCM_CSCDR1_USDHC1_PODF = 11
+
+	// CCM Serial Clock Divider Register 2, IMX6ULLRM
+	CCM_CSCDR2                    uint32 = 0x020c4038
+	CCM_CSCDR2_ECSPI_CLK_PODF            = 19
+	CCM_CSCDR2_ECSPI_CLK_SEL             = 18
+	CCM_CSCDR2_LCDIF1_PRE_CLK_SEL        = 15
+	CCM_CSCDR2_LCDIF1_PRED               = 12
+	CCM_CSCDR2_LCDIF1_CLK_SEL            = 9
+
+	// LCDIF1_PRE_CLK_SEL: PLL5 main clock
+	LCDIF1_PRE_CLK_PLL5 = 0b010
+
+	// Analog System PLL (PLL2) Control Register, IMX6ULLRM
+	CCM_ANALOG_PLL_SYS            uint32 = 0x020c8030
+	CCM_ANALOG_PLL_SYS_DIV_SELECT        = 0
+
+	// Analog USB1 480MHz PLL (PLL3) Control Register, IMX6ULLRM
+	CCM_ANALOG_PLL_USB1            uint32 = 0x020c8010
+	CCM_ANALOG_PLL_USB1_DIV_SELECT        = 0
+
+	// 528MHz Clock (PLL2) Phase Fractional Divider Control Register, IMX6ULLRM
+	CCM_ANALOG_PFD_528           uint32 = 0x020c8100
+	CCM_ANALOG_PFD_528_PFD2_FRAC        = 16
+	CCM_ANALOG_PFD_528_PFD0_FRAC        = 0
+)
+
+var (
+	cbcdr  = reg.New(CCM_CBCDR)
+	cbcmr  = reg.New(CCM_CBCMR)
+	cscmr1 = reg.New(CCM_CSCMR1)
+	cscdr1 = reg.New(CCM_CSCDR1)
+	cscdr2 = reg.New(CCM_CSCDR2)
+	cs1cdr = reg.New(CCM_CS1CDR)
+	cs2cdr = reg.New(CCM_CS2CDR)
+	pllSys = reg.New(CCM_ANALOG_PLL_SYS)
+	pllUSB = reg.New(CCM_ANALOG_PLL_USB1)
+	pfd528 = reg.New(CCM_ANALOG_PFD_528)
+)
+
+// SysPLLFreq returns the System PLL (PLL2) frequency.
+func SysPLLFreq() (hz uint32) {
+	// DIV_SELECT: 0 = 20 * OSC_FREQ, 1 = 22 * OSC_FREQ
+	return OscFreq() * (20 + 2*pllSys.Get(CCM_ANALOG_PLL_SYS_DIV_SELECT, 0b1))
+}
+
+// USBPLLFreq returns the USB1 PLL (PLL3) frequency.
+func USBPLLFreq() (hz uint32) {
+	// DIV_SELECT: 0 = 20 * OSC_FREQ, 1 = 22 * OSC_FREQ
+	return OscFreq() * (20 + 2*pllUSB.Get(CCM_ANALOG_PLL_USB1_DIV_SELECT, 0b1))
+}
+
+// pfdFreq returns the frequency of a PLL phase fractional divider output.
+func pfdFreq(pll uint32, frac uint32) uint32 {
+	if frac == 0 {
+		return 0
+	}
+
+	// PFD = PLL * 18 / FRAC
+	return uint32(uint64(pll) * 18 / uint64(frac))
+}
+
+// AHBFreq returns the AHB clock root frequency.
+func AHBFreq() (hz uint32) {
+	if cbcdr.Get(CCM_CBCDR_PERIPH_CLK_SEL, 0b1) == 1 {
+		// periph_clk2
+		switch cbcmr.Get(CCM_CBCMR_PERIPH_CLK2_SEL, 0b11) {
+		case 0b00:
+			hz = USBPLLFreq()
+		default:
+			hz = OscFreq()
+		}
+
+		hz /= cbcdr.Get(CCM_CBCDR_PERIPH_CLK2_PODF, 0b111) + 1
+	} else {
+		// pre_periph_clk
+		switch cbcmr.Get(CCM_CBCMR_PRE_PERIPH_CLK_SEL, 0b11) {
+		case 0b00:
+			hz = SysPLLFreq()
+		case 0b01:
+			hz = pfdFreq(SysPLLFreq(), pfd528.Get(CCM_ANALOG_PFD_528_PFD2_FRAC, 0b111111))
+		case 0b10:
+			hz = pfdFreq(SysPLLFreq(), pfd528.Get(CCM_ANALOG_PFD_528_PFD0_FRAC, 0b111111))
+		case 0b11:
+			// 198 MHz, PLL2 PFD2 divided by 2
+			hz = pfdFreq(SysPLLFreq(), pfd528.Get(CCM_ANALOG_PFD_528_PFD2_FRAC, 0b111111)) / 2
+		}
+	}
+
+	return hz / (cbcdr.Get(CCM_CBCDR_AHB_PODF, 0b111) + 1)
+}
+
+// IPGFreq returns the IPG clock root frequency.
+func IPGFreq() (hz uint32) {
+	return AHBFreq() / (cbcdr.Get(CCM_CBCDR_IPG_PODF, 0b11) + 1)
+}
+
+// PerclkFreq returns the PERCLK clock root frequency, which clocks
+// peripherals such as I2C, GPT and EPIT.
+func PerclkFreq() (hz uint32) {
+	if cscmr1.Get(CCM_CSCMR1_PERCLK_SEL, 0b1) == 1 {
+		hz = OscFreq()
+	} else {
+		hz = IPGFreq()
+	}
+
+	return hz / (cscmr1.Get(CCM_CSCMR1_PERCLK_PODF, 0b111111) + 1)
+}
+
+// ECSPIFreq returns the ECSPI clock root frequency.
+func ECSPIFreq() (hz uint32) {
+	if cscdr2.Get(CCM_CSCDR2_ECSPI_CLK_SEL, 0b1) == 1 {
+		hz = OscFreq()
+	} else {
+		// pll3_60m
+		hz = USBPLLFreq() / 8
+	}
+
+	return hz / (cscdr2.Get(CCM_CSCDR2_ECSPI_CLK_PODF, 0b111111) + 1)
+}
+
+// USDHCFreq returns the clock root frequency for the argument uSDHC instance
+// (1 or 2).
+func USDHCFreq(n int) (hz uint32) {
+	sel := CCM_CSCMR1_USDHC1_CLK_SEL
+	podf := CCM_CSCDR1_USDHC1_PODF
+
+	if n == 2 {
+		sel = CCM_CSCMR1_USDHC2_CLK_SEL
+		podf = CCM_CSCDR1_USDHC2_PODF
+	}
+
+	if cscmr1.Get(sel, 0b1) == 1 {
+		hz = pfdFreq(SysPLLFreq(), pfd528.Get(CCM_ANALOG_PFD_528_PFD0_FRAC, 0b111111))
+	} else {
+		hz = pfdFreq(SysPLLFreq(), pfd528.Get(CCM_ANALOG_PFD_528_PFD2_FRAC, 0b111111))
+	}
+
+	return hz / (cscdr1.Get(podf, 0b111) + 1)
+}
+
+// SetLCDIFClock configures the Video PLL (PLL5) and the LCDIF1 clock root
+// dividers for the argument pixel clock frequency, returning the frequency
+// actually programmed.
+func SetLCDIFClock(hz uint32) (actual uint32, err error) {
+	if hz == 0 {
+		return 0, errors.New("invalid frequency")
+	}
+
+	osc := OscFreq()
+
+	// PLL5 output range (DIV_SELECT 27-54)
+	min := uint64(osc) * 27
+	max := uint64(osc) * 54
+
+	for _, post := range []uint32{1, 2, 4} {
+		for pred := uint32(1); pred <= 8; pred++ {
+			for podf := uint32(1); podf <= 8; podf++ {
+				vco := uint64(hz) * uint64(post*pred*podf)
+
+				if vco < min || vco > max {
+					continue
+				}
+
+				div := uint32(vco / uint64(osc))
+				num := uint32(vco % uint64(osc))
+
+				if err = SetAudioVideoPLL(PLL5_VIDEO, div, num, osc, post); err != nil {
+					return
+				}
+
+				cscdr2.SetN(CCM_CSCDR2_LCDIF1_PRE_CLK_SEL, 0b111, LCDIF1_PRE_CLK_PLL5)
+				cscdr2.SetN(CCM_CSCDR2_LCDIF1_PRED, 0b111, pred-1)
+				cscdr2.SetN(CCM_CSCDR2_LCDIF1_CLK_SEL, 0b111, 0)
+				cbcmr.SetN(CCM_CBCMR_LCDIF1_PODF, 0b111, podf-1)
+
+				actual = uint32((uint64(osc)*uint64(div) + uint64(num)) / uint64(post*pred*podf))
+
+				return
+			}
+		}
+	}
+
+	return 0, errors.New("unsupported frequency")
+}
+
+// SetSAIClock configures the Audio PLL (PLL4) and the clock root dividers of
+// the argument SAI instance (1-3) for the argument master clock frequency,
+// returning the frequency actually programmed. The Audio PLL is shared among
+// all SAI instances.
+func SetSAIClock(n int, hz uint32) (actual uint32, err error) {
+	var sel int
+	var cdr *reg.Register
+	var predPos, podfPos int
+
+	switch n {
+	case 1:
+		sel, cdr, predPos, podfPos = CCM_CSCMR1_SAI1_CLK_SEL, cs1cdr, CCM_CS1CDR_SAI1_CLK_PRED, CCM_CS1CDR_SAI1_CLK_PODF
+	case 2:
+		sel, cdr, predPos, podfPos = CCM_CSCMR1_SAI2_CLK_SEL, cs2cdr, CCM_CS2CDR_SAI2_CLK_PRED, CCM_CS2CDR_SAI2_CLK_PODF
+	case 3:
+		sel, cdr, predPos, podfPos = CCM_CSCMR1_SAI3_CLK_SEL, cs1cdr, CCM_CS1CDR_SAI3_CLK_PRED, CCM_CS1CDR_SAI3_CLK_PODF
+	default:
+		return 0, errors.New("invalid SAI instance")
+	}
+
+	if hz == 0 {
+		return 0, errors.New("invalid frequency")
+	}
+
+	osc := OscFreq()
+
+	// PLL4 output range (DIV_SELECT 27-54)
+	min := uint64(osc) * 27
+	max := uint64(osc) * 54
+
+	for pred := uint32(1); pred <= 8; pred++ {
+		for podf := uint32(1); podf <= 64; podf++ {
+			vco := uint64(hz) * uint64(pred*podf)
+
+			if vco < min || vco > max {
+				continue
+			}
+
+			div := uint32(vco / uint64(osc))
+			num := uint32(vco % uint64(osc))
+
+			if err = SetAudioVideoPLL(PLL4_AUDIO, div, num, osc, 1); err != nil {
+				return
+			}
+
+			cscmr1.SetN(sel, 0b11, SAI_CLK_PLL4)
+			cdr.SetN(predPos, 0b111, pred-1)
+			cdr.SetN(podfPos, 0b111111, podf-1)
+
+			actual = uint32((uint64(osc)*uint64(div) + uint64(num)) / uint64(pred*podf))
+
+			return
+		}
+	}
+
+	return 0, errors.New("unsupported frequency")
+}
@@ -13,55 +13,323 @@ package imx6
 
 import (
 	"errors"
-	"log"
-	"unsafe"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/inversepath/tamago/imx6/internal/reg"
+	"github.com/inversepath/tamago/imx6/ocotp"
 )
 
 const (
+	// default main crystal oscillator frequency (see SetOscFreq())
 	OSC_FREQ = 24000000
+	// accepted main crystal oscillator frequency range
+	OSC_MIN_FREQ = 12000000
+	OSC_MAX_FREQ = 27000000
 
-	CCM_CACRR          int32 = 0x020c4010
-	CCM_CACRR_ARM_PODF       = 0
+	// p24, Table 10. Operating Ranges, IMX6ULLCEC
+	IMX6ULL_MAX_ARM_FREQ = 900000000
+	// Table 10. Operating Ranges, IMX6ULCEC
+	IMX6UL_MAX_ARM_FREQ = 696000000
+	// Operating Ranges, IMX6ULZCEC (800 MHz, the closest PLL_ARM
+	// frequency below it is used)
+	IMX6ULZ_MAX_ARM_FREQ = 792000000
+
+	// Value of OTP Bank0 Word4 (Configuration and Manufacturing Info.),
+	// IMX6ULLRM
+	OCOTP_CFG3_BANK          = 0
+	OCOTP_CFG3_WORD          = 4
+	OCOTP_CFG3_SPEED_GRADING = 16
+
+	// lowest speed grade, assumed for unprogrammed or reserved fuses
+	SPEED_GRADE_MIN_FREQ = 528000000
+
+	CCM_CACRR          uint32 = 0x020c4010
+	CCM_CACRR_ARM_PODF        = 0
+
+	CCM_CDHIPR               uint32 = 0x020c4048
+	CCM_CDHIPR_ARM_PODF_BUSY        = 16
 
 	CCM_ANALOG_PLL_ARM                uint32 = 0x020c8000
 	CCM_ANALOG_PLL_ARM_LOCK                  = 31
+	CCM_ANALOG_PLL_ARM_PLL_SEL               = 19
 	CCM_ANALOG_PLL_ARM_BYPASS                = 16
 	CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC        = 14
+	CCM_ANALOG_PLL_ARM_ENABLE                = 13
+	CCM_ANALOG_PLL_ARM_POWERDOWN             = 12
 	CCM_ANALOG_PLL_ARM_DIV_SELECT            = 0
 
-	PMU_REG_CORE           uint32 = 0x020c8140
-	PMU_REG_CORE_REG2_TARG        = 18
-	PMU_REG_CORE_REG0_TARG        = 0
+	PMU_REG_CORE            uint32 = 0x020c8140
+	PMU_REG_CORE_FET_ODRIVE        = 29
+	PMU_REG_CORE_RAMP_RATE         = 27
+	PMU_REG_CORE_REG2_ADJ          = 23
+	PMU_REG_CORE_REG2_TARG         = 18
+	PMU_REG_CORE_REG1_ADJ          = 14
+	PMU_REG_CORE_REG1_TARG         = 9
+	PMU_REG_CORE_REG0_ADJ          = 5
+	PMU_REG_CORE_REG0_TARG         = 0
+
+	// p2456, 39.6.4 Digital Regulator Core Register, IMX6ULLRM
+	//
+	// With the default REG0_STEP_TIME each 25 mV regulator step takes 64
+	// main oscillator cycles (~2.7 us), the whole target range therefore
+	// settles within 100 us.
+	PMU_REG_CORE_SETTLE_US = 100
+)
+
+// Clock register field tables, for ClockStatus() register decoding.
+var (
+	cacrrFields = []reg.Field{
+		{Name: "ARM_PODF", Pos: CCM_CACRR_ARM_PODF, Mask: 0b111},
+	}
+
+	pllARMFields = []reg.Field{
+		{Name: "LOCK", Pos: CCM_ANALOG_PLL_ARM_LOCK, Mask: 0b1},
+		{Name: "PLL_SEL", Pos: CCM_ANALOG_PLL_ARM_PLL_SEL, Mask: 0b1},
+		{Name: "BYPASS", Pos: CCM_ANALOG_PLL_ARM_BYPASS, Mask: 0b1},
+		{Name: "BYPASS_CLK_SRC", Pos: CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC, Mask: 0b11},
+		{Name: "ENABLE", Pos: CCM_ANALOG_PLL_ARM_ENABLE, Mask: 0b1},
+		{Name: "POWERDOWN", Pos: CCM_ANALOG_PLL_ARM_POWERDOWN, Mask: 0b1},
+		{Name: "DIV_SELECT", Pos: CCM_ANALOG_PLL_ARM_DIV_SELECT, Mask: 0b1111111},
+	}
+
+	regCoreFields = []reg.Field{
+		{Name: "FET_ODRIVE", Pos: PMU_REG_CORE_FET_ODRIVE, Mask: 0b1},
+		{Name: "RAMP_RATE", Pos: PMU_REG_CORE_RAMP_RATE, Mask: 0b11},
+		{Name: "REG2_ADJ", Pos: PMU_REG_CORE_REG2_ADJ, Mask: 0b1111},
+		{Name: "REG2_TARG", Pos: PMU_REG_CORE_REG2_TARG, Mask: 0b11111},
+		{Name: "REG1_ADJ", Pos: PMU_REG_CORE_REG1_ADJ, Mask: 0b1111},
+		{Name: "REG1_TARG", Pos: PMU_REG_CORE_REG1_TARG, Mask: 0b11111},
+		{Name: "REG0_ADJ", Pos: PMU_REG_CORE_REG0_ADJ, Mask: 0b1111},
+		{Name: "REG0_TARG", Pos: PMU_REG_CORE_REG0_TARG, Mask: 0b11111},
+	}
+)
+
+var (
+	cacrr   = reg.New(CCM_CACRR)
+	cdhipr  = reg.New(CCM_CDHIPR)
+	pllARM  = reg.New(CCM_ANALOG_PLL_ARM)
+	regCore = reg.New(PMU_REG_CORE)
 )
 
 // ARMCoreDiv returns the ARM core divider value
 // (p665, 18.6.5 CCM Arm Clock Root Register, IMX6ULLRM).
 func ARMCoreDiv() (div float32) {
-	cacrr := (*uint32)(unsafe.Pointer(uintptr(CCM_CACRR)))
-	return float32(reg.Get(cacrr, CCM_CACRR_ARM_PODF, 0b111) + 1)
+	return float32(cacrr.Get(CCM_CACRR_ARM_PODF, 0b111) + 1)
 }
 
 // ARMPLLDiv returns the ARM PLL divider value
 // (p714, 18.7.1 Analog ARM PLL control Register, IMX6ULLRM).
 func ARMPLLDiv() (div float32) {
-	pll := (*uint32)(unsafe.Pointer(uintptr(CCM_ANALOG_PLL_ARM)))
-	return float32(reg.Get(pll, CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111)) / 2
+	return float32(pllARM.Get(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111)) / 2
+}
+
+// oscFreq holds the main crystal oscillator frequency.
+var oscFreq uint32 = OSC_FREQ
+
+// OscFreq returns the main crystal oscillator frequency, used as reference
+// for all PLL and clock root frequency calculations.
+func OscFreq() uint32 {
+	return atomic.LoadUint32(&oscFreq)
+}
+
+// SetOscFreq sets the main crystal oscillator frequency, for boards fitted
+// with a crystal other than the standard 24 MHz one (OSC_FREQ). It should be
+// invoked at early initialization, before any clock configuration.
+//
+// Clock listeners (see RegisterClockListener()) are notified if the derived
+// ARM core frequency changes, an out of range frequency is reported as
+// ErrUnsupportedOscillator.
+func SetOscFreq(hz uint32) (err error) {
+	if hz < OSC_MIN_FREQ || hz > OSC_MAX_FREQ {
+		return fmt.Errorf("%w, %d Hz out of range", ErrUnsupportedOscillator, hz)
+	}
+
+	clockMutex.Lock()
+
+	oldHz := ARMFreq()
+	atomic.StoreUint32(&oscFreq, hz)
+	newHz := ARMFreq()
+
+	atomic.StoreUint32(&armFreq, newHz)
+	calibrateDelay()
+
+	clockMutex.Unlock()
+
+	notifyClockListeners(oldHz, newHz)
+
+	return
+}
+
+// armClock returns the ARM core clock numerator (Hz) and denominator.
+func armClock() (num uint64, den uint64) {
+	den = uint64(cacrr.Get(CCM_CACRR_ARM_PODF, 0b111) + 1)
+
+	if ARMPLLBypass() {
+		// OSC_FREQ / (ARM_PODF + 1)
+		return uint64(OscFreq()), den
+	}
+
+	// (OSC_FREQ * DIV_SELECT / 2) / (ARM_PODF + 1)
+	return uint64(OscFreq()) * uint64(pllARM.Get(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111)) / 2, den
+}
+
+// armDivFreq returns the ARM core frequency, rounded to the nearest hertz,
+// for the argument ARM PLL and core dividers.
+func armDivFreq(divSelect uint32, armPodf uint32) uint32 {
+	num := uint64(OscFreq()) * uint64(divSelect) / 2
+	den := uint64(armPodf + 1)
+
+	return uint32((num + den/2) / den)
 }
 
-// ARMFreq returns the ARM core frequency.
+// ARMFreq returns the ARM core frequency, rounded to the nearest hertz.
 func ARMFreq() (hz uint32) {
-	// (OSC_FREQ * (DIV_SELECT / 2)) / (ARM_PODF + 1)
-	return uint32((OSC_FREQ * ARMPLLDiv()) / ARMCoreDiv())
+	num, den := armClock()
+	return uint32((num + den/2) / den)
+}
+
+// ARMFreqHz returns the exact ARM core frequency, which is not an integer
+// for core dividers which do not evenly divide the PLL output.
+func ARMFreqHz() (hz float64) {
+	num, den := armClock()
+	return float64(num) / float64(den)
+}
+
+// ARMPLLBypass returns whether the ARM PLL is bypassed
+// (p714, 18.7.1 Analog ARM PLL control Register, IMX6ULLRM).
+func ARMPLLBypass() bool {
+	return pllARM.Get(CCM_ANALOG_PLL_ARM_BYPASS, 0b1) == 1
 }
 
-func setOperatingPointIMX6ULL(uV uint32) {
+// SetARMPLLBypass enables or disables the ARM PLL bypass, when enabled the
+// ARM core is clocked directly from the main oscillator (divided by the core
+// divider) without changing the PLL configuration.
+//
+// The caller must ensure that the current operating point is adequate for
+// the PLL frequency before disabling the bypass.
+func SetARMPLLBypass(enable bool) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	if enable {
+		// set bypass source to main oscillator
+		pllARM.SetN(CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC, 0b11, 0)
+		pllARM.Set(CCM_ANALOG_PLL_ARM_BYPASS)
+	} else {
+		pllARM.Clear(CCM_ANALOG_PLL_ARM_BYPASS)
+	}
+
+	atomic.StoreUint32(&armFreq, ARMFreq())
+	calibrateDelay()
+}
+
+// Errors returned by SetARMFreq and related functions, wrapped with
+// additional context (see errors.Is).
+var (
+	ErrUnsupportedFamily    = errors.New("unsupported SoC family")
+	ErrUnsupportedFrequency = errors.New("unsupported frequency")
+	ErrUnsupportedVoltage   = errors.New("unsupported voltage")
+
+	// ErrUnsupportedOscillator is returned by SetOscFreq, as distinct from
+	// ErrUnsupportedFrequency which relates to the ARM core frequency.
+	ErrUnsupportedOscillator = errors.New("unsupported oscillator frequency")
+
+	// ErrVoltageNotLowered is returned when the ARM core frequency was
+	// lowered successfully but the core voltage could not be reduced
+	// accordingly, the core is left running safely at a higher voltage.
+	ErrVoltageNotLowered = errors.New("frequency lowered, voltage not lowered")
+
+	// ErrPLLLockTimeout is returned when a PLL fails to lock within
+	// PLLLockTimeout, for the ARM PLL the core is left running from the
+	// main oscillator and a lower frequency can be attempted.
+	ErrPLLLockTimeout = errors.New("PLL lock timeout")
+)
+
+// clockMutex serializes ARM core frequency and operating point changes.
+var clockMutex sync.Mutex
+
+// PLLLockTimeout is the maximum time waited for ARM PLL lock when changing
+// frequency, a zero value (default) waits indefinitely.
+var PLLLockTimeout time.Duration
+
+// armFreq caches the ARM core frequency, a zero value indicates that the
+// cache has not been populated yet.
+var armFreq uint32
+
+// CachedARMFreq returns the ARM core frequency, the value is read from
+// hardware registers only once and then updated on each successful frequency
+// change, making it suitable for frequent polling.
+func CachedARMFreq() (hz uint32) {
+	if hz = atomic.LoadUint32(&armFreq); hz != 0 {
+		return
+	}
+
+	hz = ARMFreq()
+	atomic.StoreUint32(&armFreq, hz)
+
+	return
+}
+
+// regulator, when set, replaces the internal PMU core regulator for ARM core
+// voltage changes, regulatorUV holds the last voltage it applied.
+var (
+	regulator   func(uV uint32) error
+	regulatorUV uint32
+)
+
+// SetRegulator registers a function, invoked on each ARM core frequency change
+// in place of the internal PMU core regulator (PMU_REG_CORE) programming, to
+// set the ARM core voltage (in uV) on boards supplying VDD_ARM and VDD_SOC
+// from an external regulator (e.g. a PMIC, see package pmic).
+//
+// The function must return only once the voltage has settled, a returned
+// error aborts frequency raises before the PLL is changed. A nil function
+// restores the internal regulator (default).
+func SetRegulator(fn func(uV uint32) error) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	regulator = fn
+	regulatorUV = 0
+}
+
+// setOperatingPoint sets the ARM core voltage through the registered
+// regulator, if any, or the internal PMU core regulator.
+func setOperatingPoint(uV uint32) (err error) {
+	if regulator == nil {
+		return setOperatingPointIMX6ULL(uV)
+	}
+
+	if uV == regulatorUV {
+		return
+	}
+
+	logf("imx6_clk: changing ARM core operating point to %d uV (external)\n", uV)
+
+	if err = regulator(uV); err != nil {
+		return
+	}
+
+	regulatorUV = uV
+
+	return
+}
+
+// setOperatingPointIMX6ULL programs the ARM core and SOC regulator targets
+// for the argument ARM core voltage, waiting for the regulator to settle.
+//
+// The regulator targets are read back after settling, an error is returned
+// if they do not match the programmed values (e.g. the PMU ignored the
+// write), so that callers never raise the ARM core frequency without the
+// required voltage.
+func setOperatingPointIMX6ULL(uV uint32) (err error) {
 	var reg0Targ uint32
 	var reg2Targ uint32
 
-	pmu := (*uint32)(unsafe.Pointer(uintptr(PMU_REG_CORE)))
-	curTarg := reg.Get(pmu, PMU_REG_CORE_REG0_TARG, 0b11111)
+	curTarg := regCore.Get(PMU_REG_CORE_REG0_TARG, 0b11111)
 
 	// p2456, 39.6.4 Digital Regulator Core Register, IMX6ULLRM
 	if uV < 725000 {
@@ -83,106 +351,671 @@ func setOperatingPointIMX6ULL(uV uint32) {
 		reg2Targ = reg0Targ
 	}
 
-	log.Printf("imx6_clk: changing ARM core operating point to %d uV\n", reg0Targ*25000)
-
-	r := *pmu
+	logf("imx6_clk: changing ARM core operating point to %d uV\n", reg0Targ*25000)
 
-	// clear target voltages
-	reg.ClearN(&r, 0, (0b11111<<PMU_REG_CORE_REG2_TARG | 0b11111<<PMU_REG_CORE_REG0_TARG))
+	// set ARM core and SOC target voltages
+	WithInterruptsDisabled(func() {
+		regCore.SetToN(
+			reg.FieldValue{Pos: PMU_REG_CORE_REG0_TARG, Mask: 0b11111, Val: reg0Targ},
+			reg.FieldValue{Pos: PMU_REG_CORE_REG2_TARG, Mask: 0b11111, Val: reg2Targ},
+		)
+	})
 
-	// set ARM core target voltage
-	reg.SetN(&r, PMU_REG_CORE_REG0_TARG, 0b11111, reg0Targ)
+	Delay(PMU_REG_CORE_SETTLE_US)
 
-	// set SOC target voltage
-	reg.SetN(&r, PMU_REG_CORE_REG2_TARG, 0b11111, reg2Targ)
+	if regCore.Get(PMU_REG_CORE_REG0_TARG, 0b11111) != reg0Targ ||
+		regCore.Get(PMU_REG_CORE_REG2_TARG, 0b11111) != reg2Targ {
+		return errors.New("regulator target mismatch, operating point not applied")
+	}
 
-	*pmu = r
-	busyloop(10000)
+	logf("imx6_clk: %d uV -> %d uV\n", curTarg*25000, reg0Targ*25000)
 
-	log.Printf("imx6_clk: %d uV -> %d uV\n", curTarg*25000, reg0Targ*25000)
+	return
 }
 
-func setARMFreqIMX6ULL(hz uint32) (err error) {
-	var div_select uint32
-	var arm_podf uint32
-	var uV uint32
+// setARMClock programs the ARM PLL and core dividers, the sequence (and the
+// PMU core regulator) is identical on i.MX6UL, i.MX6ULL and i.MX6ULZ.
+//
+// The core divider is programmed while the PLL is still bypassed, so that the
+// core never runs above both the previous and the new frequency.
+//
+// On PLL lock timeout (see PLLLockTimeout) the PLL is left in bypass, running
+// the core from the main oscillator, and ErrPLLLockTimeout is returned. A
+// failure to lower the voltage, after a successful frequency reduction, is
+// reported as ErrVoltageNotLowered.
+func setARMClock(hz uint32, curHz uint32, div_select uint32, arm_podf uint32, uV uint32) (err error) {
+	if hz > curHz {
+		if err = setOperatingPoint(uV); err != nil {
+			return
+		}
+	}
 
-	cacrr := (*uint32)(unsafe.Pointer(uintptr(CCM_CACRR)))
-	pll := (*uint32)(unsafe.Pointer(uintptr(CCM_ANALOG_PLL_ARM)))
-	curHz := ARMFreq()
+	// the core divisor is applied after the PLL change, an invalid
+	// value must therefore be rejected beforehand
+	if arm_podf > 0b111 {
+		return fmt.Errorf("%w, invalid ARM_PODF %d", ErrUnsupportedFrequency, arm_podf)
+	}
 
-	if hz == curHz {
+	// set bypass source to main oscillator
+	pllARM.SetN(CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC, 0b11, 0)
+
+	// bypass
+	pllARM.Set(CCM_ANALOG_PLL_ARM_BYPASS)
+
+	// set PLL divisor
+	if err = pllARM.SetNChecked(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111, div_select); err != nil {
+		// the previous divisor is retained
+		pllARM.Clear(CCM_ANALOG_PLL_ARM_BYPASS)
 		return
 	}
 
-	log.Printf("imx6_clk: changing ARM core frequency to %d MHz\n", hz/1000000)
+	// wait for lock
+	logf("imx6_clk: waiting for PLL lock\n")
+
+	if PLLLockTimeout == 0 {
+		pllARM.Wait(CCM_ANALOG_PLL_ARM_LOCK, 0b1, 1)
+	} else if !pllARM.WaitFor(PLLLockTimeout, CCM_ANALOG_PLL_ARM_LOCK, 0b1, 1) {
+		atomic.StoreUint32(&armFreq, ARMFreq())
+		calibrateDelay()
+		return fmt.Errorf("%w, PLL left in bypass", ErrPLLLockTimeout)
+	}
 
-	// p24, Table 10. Operating Ranges, IMX6ULLCEC
-	switch hz {
-	case 900000000:
-		div_select = 75
-		arm_podf = 0
-		uV = 1275000
-	case 792000000:
-		div_select = 66
-		arm_podf = 0
-		uV = 1225000
-	case 528000000:
-		div_select = 88
-		arm_podf = 1
-		uV = 1175000
-	case 396000000:
-		div_select = 66
-		arm_podf = 1
-		uV = 1025000
-	case 198000000:
-		div_select = 66
-		arm_podf = 3
-		uV = 950000
+	// set core divisor, while still running from the main oscillator,
+	// and wait for its handshake
+	cacrr.SetN(CCM_CACRR_ARM_PODF, 0b111, arm_podf)
+	cdhipr.Wait(CCM_CDHIPR_ARM_PODF_BUSY, 0b1, 0)
+
+	// remove bypass
+	pllARM.Clear(CCM_ANALOG_PLL_ARM_BYPASS)
+
+	if hz < curHz {
+		// lowering the voltage is not critical, the frequency
+		// change has already been applied
+		if verr := setOperatingPoint(uV); verr != nil {
+			logf("imx6_clk: %v\n", verr)
+			err = fmt.Errorf("%w, %v", ErrVoltageNotLowered, verr)
+		}
+	}
+
+	atomic.StoreUint32(&armFreq, ARMFreq())
+	calibrateDelay()
+
+	logf("imx6_clk: %d MHz -> %d MHz\n", curHz/1000000, hz/1000000)
+
+	return
+}
+
+// operatingPoint represents the minimum ARM core voltage required for a given
+// ARM core frequency.
+type operatingPoint struct {
+	hz uint32
+	uV uint32
+}
+
+// Table 10. Operating Ranges, IMX6ULCEC
+var operatingPointsIMX6UL = []operatingPoint{
+	{198000000, 950000},
+	{396000000, 1025000},
+	{528000000, 1175000},
+	{IMX6UL_MAX_ARM_FREQ, 1275000},
+}
+
+// p24, Table 10. Operating Ranges, IMX6ULLCEC
+var operatingPointsIMX6ULL = []operatingPoint{
+	{198000000, 950000},
+	{396000000, 1025000},
+	{528000000, 1175000},
+	{792000000, 1225000},
+	{IMX6ULL_MAX_ARM_FREQ, 1275000},
+}
+
+// Operating Ranges, IMX6ULZCEC
+var operatingPointsIMX6ULZ = []operatingPoint{
+	{198000000, 950000},
+	{396000000, 1025000},
+	{528000000, 1175000},
+	{IMX6ULZ_MAX_ARM_FREQ, 1225000},
+}
+
+func familyOperatingPoints() (ops []operatingPoint, err error) {
+	switch Family {
+	case IMX6UL:
+		ops = operatingPointsIMX6UL
+	case IMX6ULL:
+		ops = operatingPointsIMX6ULL
+	case IMX6ULZ:
+		ops = operatingPointsIMX6ULZ
 	default:
-		return errors.New("unsupported")
+		err = fmt.Errorf("%w (%s)", ErrUnsupportedFamily, Model())
 	}
 
-	if hz > curHz {
-		setOperatingPointIMX6ULL(uV)
+	return
+}
+
+// armOperatingPoints returns the SoC family operating points permitted by the
+// speed grade fuses (see MaxARMFreq()).
+func armOperatingPoints() (ops []operatingPoint, err error) {
+	if ops, err = familyOperatingPoints(); err != nil {
+		return
 	}
 
-	// set bypass source to main oscillator
-	reg.SetN(pll, CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC, 0b11, 0)
+	max := MaxARMFreq()
 
-	// bypass
-	reg.Set(pll, CCM_ANALOG_PLL_ARM_BYPASS)
+	for i, op := range ops {
+		if op.hz > max {
+			return ops[0:i], nil
+		}
+	}
 
-	// set PLL divisor
-	reg.SetN(pll, CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111, div_select)
+	return
+}
 
-	// wait for lock
-	log.Printf("imx6_clk: waiting for PLL lock\n")
-	reg.Wait(pll, CCM_ANALOG_PLL_ARM_LOCK, 0b1, 1)
+// speedGrade holds the OCOTP_CFG3 SPEED_GRADING fuses, read once at
+// initialization (see initSpeedGrade()).
+var speedGrade uint32
 
-	// remove bypass
-	reg.Clear(pll, CCM_ANALOG_PLL_ARM_BYPASS)
+func init() {
+	initSpeedGrade()
+	// reported once the speed grade, which limits MaxARMFreq(), is known
+	logSiliconRevision()
+}
 
-	// set core divisor
-	reg.SetN(cacrr, CCM_CACRR_ARM_PODF, 0b111, arm_podf)
+// initSpeedGrade reads the speed grade fuses through the OCOTP driver, on
+// failure (or under emulation) the speed grade is left unknown.
+func initSpeedGrade() {
+	if !Native {
+		return
+	}
 
-	if hz < curHz {
-		setOperatingPointIMX6ULL(uV)
+	cfg3, err := ocotp.Read(OCOTP_CFG3_BANK, OCOTP_CFG3_WORD)
+
+	if err != nil {
+		return
 	}
 
-	log.Printf("imx6_clk: %d MHz -> %d MHz\n", curHz/1000000, hz/1000000)
+	speedGrade = (cfg3 >> OCOTP_CFG3_SPEED_GRADING) & 0b11
+}
+
+// speedGradeFreq returns the maximum ARM core frequency encoded in the
+// OCOTP_CFG3 SPEED_GRADING fuses, unprogrammed, reserved or unknown values
+// result in the lowest speed grade (SPEED_GRADE_MIN_FREQ).
+func speedGradeFreq() uint32 {
+	switch speedGrade {
+	case 0b10:
+		if Family == IMX6ULL || Family == IMX6ULZ {
+			return 792000000
+		}
+
+		return IMX6UL_MAX_ARM_FREQ
+	case 0b11:
+		switch Family {
+		case IMX6ULL:
+			return IMX6ULL_MAX_ARM_FREQ
+		case IMX6ULZ:
+			return IMX6ULZ_MAX_ARM_FREQ
+		}
+	}
+
+	return SPEED_GRADE_MIN_FREQ
+}
+
+// armFreqErrata lists ARM core frequency restrictions applying to silicon
+// revisions older than the one indicated for each SoC family.
+var armFreqErrata = []struct {
+	family uint32
+	major  int
+	minor  int
+	hz     uint32
+}{
+	// i.MX6UL TO1.0 is not qualified above the 528 MHz operating point.
+	{IMX6UL, 1, 1, SPEED_GRADE_MIN_FREQ},
+}
+
+// revisionFreq returns the maximum ARM core frequency permitted by
+// armFreqErrata for the detected family and silicon revision, zero if no
+// restriction applies.
+func revisionFreq() (hz uint32) {
+	for _, e := range armFreqErrata {
+		if e.family == Family && siliconRevisionBefore(e.major, e.minor) {
+			return e.hz
+		}
+	}
 
 	return
 }
 
+// MaxARMFreq returns the maximum ARM core frequency (in hertz) supported by
+// the SoC, as the lowest between the SoC family maximum and the speed grade
+// programmed in OCOTP fuses, further limited on silicon revisions affected by
+// frequency errata (see SiliconRevision()). SetARMFreq and SetOperatingPoints
+// reject frequencies above it.
+func MaxARMFreq() (hz uint32) {
+	ops, err := familyOperatingPoints()
+
+	if err != nil {
+		return
+	}
+
+	hz = ops[len(ops)-1].hz
+
+	if grade := speedGradeFreq(); grade < hz {
+		hz = grade
+	}
+
+	if rev := revisionFreq(); rev != 0 && rev < hz {
+		hz = rev
+	}
+
+	return
+}
+
+// minVoltage returns the minimum ARM core voltage for the argument frequency,
+// linearly interpolated between the two closest operating points and rounded
+// up to the next regulator step.
+func minVoltage(ops []operatingPoint, hz uint32) (uV uint32) {
+	if hz <= ops[0].hz {
+		return ops[0].uV
+	}
+
+	for i := 1; i < len(ops); i++ {
+		lo := ops[i-1]
+		hi := ops[i]
+
+		if hz > hi.hz {
+			continue
+		}
+
+		uV = lo.uV + uint32(uint64(hi.uV-lo.uV)*uint64(hz-lo.hz)/uint64(hi.hz-lo.hz))
+		break
+	}
+
+	// p2456, 39.6.4 Digital Regulator Core Register, IMX6ULLRM
+	if r := uV % 25000; r != 0 {
+		uV += 25000 - r
+	}
+
+	return
+}
+
+// solveARMClock searches the ARM PLL (div_select) and core (arm_podf) divider
+// combination which achieves the closest frequency not exceeding the
+// requested one, the minimum voltage for the resulting frequency is also
+// returned.
+func solveARMClock(hz uint32) (divSelect uint32, armPodf uint32, uV uint32, err error) {
+	var best uint32
+
+	ops, err := armOperatingPoints()
+
+	if err != nil {
+		return
+	}
+
+	if max := ops[len(ops)-1].hz; hz > max {
+		err = fmt.Errorf("%w, %d MHz exceeds %s speed grade maximum (%d MHz)", ErrUnsupportedFrequency, hz/1000000, Model(), max/1000000)
+		return
+	}
+
+	// p714, 18.7.1 Analog ARM PLL control Register, IMX6ULLRM
+	// Fout = Fin * div_select/2.0, 54 <= div_select <= 108
+	for podf := uint32(0); podf <= 7; podf++ {
+		for div := uint32(54); div <= 108; div++ {
+			f := armDivFreq(div, podf)
+
+			if f > hz || f <= best {
+				continue
+			}
+
+			best = f
+			divSelect = div
+			armPodf = podf
+		}
+	}
+
+	if best == 0 {
+		err = fmt.Errorf("%w, %d MHz is below the minimum achievable frequency", ErrUnsupportedFrequency, hz/1000000)
+		return
+	}
+
+	uV = minVoltage(ops, best)
+
+	return
+}
+
+// setARMFreqIMX6UL changes the ARM core frequency on i.MX6UL, i.MX6ULL and
+// i.MX6ULZ parts, which only differ in their operating points.
+func setARMFreqIMX6UL(hz uint32) (err error) {
+	var div_select, arm_podf, uV uint32
+
+	if customOperatingPoints != nil {
+		var op OperatingPoint
+
+		if op, err = customOperatingPoint(hz); err != nil {
+			return
+		}
+
+		div_select, arm_podf, uV = op.DivSelect, op.ARMPodf, op.UV
+	} else if div_select, arm_podf, uV, err = solveARMClock(hz); err != nil {
+		return
+	}
+
+	curHz := ARMFreq()
+	hz = armDivFreq(div_select, arm_podf)
+
+	if hz == curHz {
+		return
+	}
+
+	logf("imx6_clk: changing ARM core frequency to %d MHz\n", hz/1000000)
+
+	return setARMClock(hz, curHz, div_select, arm_podf, uV)
+}
+
 // SetARMFreq changes the ARM core frequency to the desired setting (in hertz).
+//
+// Any frequency up to the maximum allowed by the SoC family can be requested,
+// the closest achievable frequency not exceeding it is programmed and can be
+// retrieved with ARMFreq().
+//
+// When a custom operating point table is registered (see
+// SetOperatingPoints()) the highest entry not exceeding the requested
+// frequency is programmed instead.
+//
+// When ThermalLimit is set and exceeded by the die temperature the requested
+// frequency is clamped to THERMAL_MAX_ARM_FREQ.
+//
+// Errors caused by the SoC family or by the requested frequency wrap
+// ErrUnsupportedFamily and ErrUnsupportedFrequency respectively.
+// ErrVoltageNotLowered indicates that the frequency change was applied, but
+// the core voltage could not be reduced. ErrPLLLockTimeout indicates that the
+// core is left running from the main oscillator, a lower frequency can be
+// attempted.
 func SetARMFreq(hz uint32) (err error) {
+	_, err = SetARMFreqEx(hz)
+	return
+}
+
+// SetARMFreqEx is like SetARMFreq but it also returns the ARM core frequency
+// which was active immediately before the change, allowing its restoration:
+//
+//	prev, err := imx6.SetARMFreqEx(900000000)
+//	defer imx6.SetARMFreqEx(prev)
+func SetARMFreqEx(hz uint32) (prev uint32, err error) {
+	clockMutex.Lock()
+	prev, err = setARMFreq(hz)
+	cur := ARMFreq()
+	clockMutex.Unlock()
+
+	// the frequency also changes when the PLL failed to lock, as the core
+	// is left running from the main oscillator
+	if err == nil || errors.Is(err, ErrVoltageNotLowered) || errors.Is(err, ErrPLLLockTimeout) {
+		notifyClockListeners(prev, cur)
+	}
+
+	return
+}
+
+// activeOperatingPoints returns the custom operating points set with
+// SetOperatingPoints(), or the SoC family datasheet ones, in ascending
+// frequency order.
+func activeOperatingPoints() (ops []operatingPoint, err error) {
+	if customOperatingPoints == nil {
+		return armOperatingPoints()
+	}
+
+	for _, op := range customOperatingPoints {
+		ops = append(ops, operatingPoint{hz: op.Hz, uV: op.UV})
+	}
+
+	return
+}
+
+// SetARMFreqRamp changes the ARM core frequency to the desired setting (in
+// hertz), as SetARMFreq, stepping through all intermediate operating points
+// (the custom table set with SetOperatingPoints() or the SoC family
+// datasheet ones) and waiting for the argument duration after each step, to
+// limit power supply transients.
+//
+// Steps are taken in the direction of the target frequency, an error at any
+// step aborts the ramp, leaving the ARM core at the last applied step.
+func SetARMFreqRamp(hz uint32, stepSettle time.Duration) (err error) {
+	var steps []uint32
+
+	clockMutex.Lock()
+	cur := ARMFreq()
+	ops, err := activeOperatingPoints()
+	clockMutex.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	for _, op := range ops {
+		steps = append(steps, op.hz)
+	}
+
+	var ramp []uint32
+
+	if hz > cur {
+		for _, step := range steps {
+			if step > cur && step < hz {
+				ramp = append(ramp, step)
+			}
+		}
+	} else {
+		for i := len(steps) - 1; i >= 0; i-- {
+			if steps[i] < cur && steps[i] > hz {
+				ramp = append(ramp, steps[i])
+			}
+		}
+	}
+
+	for _, step := range append(ramp, hz) {
+		if serr := SetARMFreq(step); serr != nil {
+			// the step was applied when only the voltage
+			// reduction failed, keep ramping
+			if !errors.Is(serr, ErrVoltageNotLowered) {
+				return serr
+			}
+
+			err = serr
+		}
+
+		time.Sleep(stepSettle)
+	}
+
+	return
+}
+
+// ValidateOperatingPoints cycles the ARM core frequency through all operating
+// points (the custom table set with SetOperatingPoints() or the SoC family
+// datasheet ones), in ascending and then descending order, for the argument
+// number of iterations, waiting for the argument duration after each change.
+//
+// After each change the ARM core frequency, ARM PLL lock and bypass status
+// and core regulator target (see ClockStatus()) are verified, the first
+// mismatch is returned as error. The frequency active before validation is
+// restored on return.
+//
+// This function is meant for board validation, as it exercises both the
+// clock and power supply at all operating points.
+func ValidateOperatingPoints(dwell time.Duration, iterations int) (err error) {
+	clockMutex.Lock()
+	prev := ARMFreq()
+	ops, err := activeOperatingPoints()
+	clockMutex.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if e := SetARMFreq(prev); err == nil {
+			err = e
+		}
+	}()
+
+	cycle := append([]operatingPoint{}, ops...)
+
+	for i := len(ops) - 2; i > 0; i-- {
+		cycle = append(cycle, ops[i])
+	}
+
+	for n := 0; n < iterations; n++ {
+		for _, op := range cycle {
+			if err = SetARMFreq(op.hz); err != nil {
+				return fmt.Errorf("iteration %d, %d MHz: %w", n, op.hz/1000000, err)
+			}
+
+			time.Sleep(dwell)
+
+			s := ClockStatus()
+
+			switch {
+			case s.ARMFreq != op.hz:
+				err = fmt.Errorf("ARM core at %d Hz", s.ARMFreq)
+			case !s.PLLLock:
+				err = errors.New("ARM PLL not locked")
+			case s.PLLBypass:
+				err = errors.New("ARM PLL bypassed")
+			case s.ARMUV != 0 && s.ARMUV+25000 <= op.uV:
+				// the PMU regulator has 25 mV steps
+				err = fmt.Errorf("ARM core at %d uV, below %d uV", s.ARMUV, op.uV)
+			}
+
+			if err != nil {
+				return fmt.Errorf("iteration %d, %d MHz: %w", n, op.hz/1000000, err)
+			}
+		}
+	}
+
+	return
+}
+
+func setARMFreq(hz uint32) (prev uint32, err error) {
+	prev = ARMFreq()
+
+	if hz == 0 {
+		err = fmt.Errorf("%w, invalid zero frequency", ErrUnsupportedFrequency)
+		return
+	}
+
+	if ThermalLimit != 0 && hz > THERMAL_MAX_ARM_FREQ {
+		var t float32
+
+		if t, err = ReadTemperature(); err != nil {
+			return
+		}
+
+		if t > ThermalLimit {
+			logf("imx6_clk: die temperature %.1f C above limit, limiting to %d MHz\n", t, THERMAL_MAX_ARM_FREQ/1000000)
+			hz = THERMAL_MAX_ARM_FREQ
+		}
+	}
+
 	switch Family {
-	case IMX6ULL:
-		err = setARMFreqIMX6ULL(hz)
+	case IMX6UL, IMX6ULL, IMX6ULZ:
+		err = setARMFreqIMX6UL(hz)
 	default:
-		err = errors.New("unsupported")
+		err = fmt.Errorf("%w (%s)", ErrUnsupportedFamily, Model())
+	}
+
+	return
+}
+
+// ClockState represents a snapshot of the ARM core clock and regulator
+// configuration, as returned by ClockStatus().
+type ClockState struct {
+	// ARM PLL DIV_SELECT and core ARM_PODF fields
+	DivSelect uint32
+	ARMPodf   uint32
+	// ARM core frequency (Hz)
+	ARMFreq uint32
+	// ARM PLL lock and bypass status
+	PLLLock   bool
+	PLLBypass bool
+	// ARM core (REG0) and SOC (REG2) regulator targets (uV), a zero value
+	// indicates a power gated or bypassed regulator, ARMUV reports the last
+	// voltage applied by an external regulator when set (see
+	// SetRegulator())
+	ARMUV uint32
+	SOCUV uint32
+	// peripheral clock gates (CCM_CCGR0-6)
+	CCGR [7]uint32
+	// decoded CCM_CACRR, CCM_ANALOG_PLL_ARM and PMU_REG_CORE fields,
+	// indexed by register and field name
+	Fields map[string]map[string]uint32
+}
+
+// regulatorTarget converts a PMU_REG_CORE target field to microvolts
+// (p2456, 39.6.4 Digital Regulator Core Register, IMX6ULLRM).
+func regulatorTarget(targ uint32) uint32 {
+	if targ == 0 || targ == 0b11111 {
+		return 0
+	}
+
+	return 700000 + targ*25000
+}
+
+// CoreVoltageTarget returns the raw ARM core regulator (REG0_TARG) target
+// code.
+func CoreVoltageTarget() uint32 {
+	return regCore.Get(PMU_REG_CORE_REG0_TARG, 0b11111)
+}
+
+// SOCVoltageTarget returns the raw SOC regulator (REG2_TARG) target code.
+func SOCVoltageTarget() uint32 {
+	return regCore.Get(PMU_REG_CORE_REG2_TARG, 0b11111)
+}
+
+// CoreVoltage returns the ARM core (VDD_ARM_CAP) regulator target (uV), a
+// zero value indicates a power gated or bypassed regulator. The PMU register
+// is read back, therefore the voltage applied by an external regulator (see
+// SetRegulator()) is not reflected.
+func CoreVoltage() uint32 {
+	return regulatorTarget(CoreVoltageTarget())
+}
+
+// SOCVoltage returns the SOC (VDD_SOC_CAP) regulator target (uV), a zero
+// value indicates a power gated or bypassed regulator.
+func SOCVoltage() uint32 {
+	return regulatorTarget(SOCVoltageTarget())
+}
+
+// ClockStatus returns the current ARM core clock and regulator configuration,
+// gathered from CCM, CCM_ANALOG and PMU registers.
+func ClockStatus() (s ClockState) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	s.DivSelect = pllARM.Get(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111)
+	s.ARMPodf = cacrr.Get(CCM_CACRR_ARM_PODF, 0b111)
+	s.ARMFreq = ARMFreq()
+	s.PLLLock = pllARM.Get(CCM_ANALOG_PLL_ARM_LOCK, 0b1) == 1
+	s.PLLBypass = ARMPLLBypass()
+	s.ARMUV = CoreVoltage()
+
+	if regulator != nil {
+		s.ARMUV = regulatorUV
+	}
+
+	s.SOCUV = SOCVoltage()
+
+	for i := range s.CCGR {
+		r, _ := ccgrRegister(i, 0)
+		s.CCGR[i] = reg.Read(r)
+	}
+
+	s.Fields = map[string]map[string]uint32{
+		"CCM_CACRR":          cacrr.Decode(cacrrFields),
+		"CCM_ANALOG_PLL_ARM": pllARM.Decode(pllARMFields),
+		"PMU_REG_CORE":       regCore.Decode(regCoreFields),
 	}
 
 	return
 }
+
+// String returns a human readable representation of the clock status.
+func (s ClockState) String() string {
+	return fmt.Sprintf("ARM %d MHz (DIV_SELECT:%d ARM_PODF:%d lock:%v bypass:%v) ARM:%d uV SOC:%d uV CCGR:%#08x",
+		s.ARMFreq/1000000, s.DivSelect, s.ARMPodf, s.PLLLock, s.PLLBypass, s.ARMUV, s.SOCUV, s.CCGR)
+}
@@ -0,0 +1,124 @@
+// NXP Central Security Unit (CSU) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package csu implements a driver for the NXP i.MX6 Central Security Unit
+// (CSU), allowing TrustZone access control configuration of peripherals
+// through the Config Security Level (CSL) registers.
+//
+// Each CSL register controls two peripherals (slaves), the CSL index and
+// slave position for each peripheral are listed in the CSU Slave Assignments
+// table of the SoC reference manual.
+package csu
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// CSU Memory Map/Register Definition, IMX6ULLRM
+const (
+	CSU_BASE uint32 = 0x021c0000
+
+	// Config Security Level registers (CSU_CSL0-39)
+	CSU_CSL     = 0x00
+	CSU_CSL_MAX = 39
+
+	// slave field positions within each CSL register
+	CSL_SLAVE1 = 0
+	CSL_SLAVE2 = 16
+
+	// field lock bit, relative to slave field position
+	CSL_LOCK = 8
+)
+
+// CSL access permission bits
+const (
+	SUR = 1 << 0 // secure user read
+	SSR = 1 << 1 // secure supervisor read
+	NUR = 1 << 2 // non-secure user read
+	NSR = 1 << 3 // non-secure supervisor read
+	SUW = 1 << 4 // secure user write
+	SSW = 1 << 5 // secure supervisor write
+	NUW = 1 << 6 // non-secure user write
+	NSW = 1 << 7 // non-secure supervisor write
+
+	// secure world only access
+	SECURE = SUR | SSR | SUW | SSW
+	// secure and non-secure world access (reset default)
+	NON_SECURE = SECURE | NUR | NSR | NUW | NSW
+)
+
+var mutex sync.Mutex
+
+func csl(n int, slave int) (r *uint32, err error) {
+	if n < 0 || n > CSU_CSL_MAX {
+		return nil, errors.New("invalid CSL index")
+	}
+
+	if slave != CSL_SLAVE1 && slave != CSL_SLAVE2 {
+		return nil, errors.New("invalid CSL slave")
+	}
+
+	return (*uint32)(unsafe.Pointer(uintptr(CSU_BASE + CSU_CSL + uint32(4*n)))), nil
+}
+
+// SetPermissions sets the access permission bits for the peripheral
+// identified by its CSL register index (0-39) and slave field (CSL_SLAVE1 or
+// CSL_SLAVE2), leaving the paired peripheral unaffected.
+func SetPermissions(n int, slave int, perm uint32) (err error) {
+	r, err := csl(n, slave)
+
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if reg.Get(r, slave+CSL_LOCK, 0b1) == 1 {
+		return errors.New("CSL field locked")
+	}
+
+	reg.SetN(r, slave, 0xff, perm)
+
+	return
+}
+
+// SetAccess restricts the peripheral, identified by its CSL register index
+// (0-39) and slave field (CSL_SLAVE1 or CSL_SLAVE2), to secure world access
+// only or allows both secure and non-secure access.
+func SetAccess(n int, slave int, secure bool) (err error) {
+	if secure {
+		return SetPermissions(n, slave, SECURE)
+	}
+
+	return SetPermissions(n, slave, NON_SECURE)
+}
+
+// Lock prevents further modifications to the peripheral access permissions
+// until the next reset.
+func Lock(n int, slave int) (err error) {
+	r, err := csl(n, slave)
+
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reg.Set(r, slave+CSL_LOCK)
+
+	return
+}
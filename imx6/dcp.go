@@ -16,7 +16,6 @@ import (
 	"crypto/aes"
 	"errors"
 	"fmt"
-	"log"
 	"sync"
 	"unsafe"
 
@@ -32,6 +31,7 @@ const (
 	HW_DCP_CTRL_CLKGATE = 30
 
 	HW_DCP_STAT     = HW_DCP_BASE + 0x10
+	HW_DCP_STAT_CLR = HW_DCP_BASE + 0x18
 	HW_DCP_STAT_IRQ = 0
 
 	HW_DCP_CHANNELCTRL = HW_DCP_BASE + 0x0020
@@ -48,6 +48,7 @@ const (
 
 const (
 	// p1068, 13.2.6.4.2 Control0 Field, MCIMX28RM
+	DCP_CTRL0_PAYLOAD_KEY     = 11
 	DCP_CTRL0_OTP_KEY         = 10
 	DCP_CTRL0_CIPHER_INIT     = 9
 	DCP_CTRL0_CIPHER_ENCRYPT  = 8
@@ -60,6 +61,7 @@ const (
 	DCP_CTRL1_CIPHER_SELECT = 0
 	// p1098, 13.3.11 HW_DCP_PACKET2 field descriptions, MCIMX28RM
 	AES128     = 0x0
+	ECB        = 0x0
 	CBC        = 0x1
 	UNIQUE_KEY = 0xfe
 )
@@ -90,6 +92,7 @@ type dcp struct {
 
 	ctrl     *uint32
 	status   *uint32
+	staclr   *uint32
 	chctrl   *uint32
 	chstatus *uint32
 	chstaclr *uint32
@@ -101,6 +104,7 @@ type dcp struct {
 var DCP = &dcp{
 	ctrl:     (*uint32)(unsafe.Pointer(uintptr(HW_DCP_CTRL))),
 	status:   (*uint32)(unsafe.Pointer(uintptr(HW_DCP_STAT))),
+	staclr:   (*uint32)(unsafe.Pointer(uintptr(HW_DCP_STAT_CLR))),
 	chctrl:   (*uint32)(unsafe.Pointer(uintptr(HW_DCP_CHANNELCTRL))),
 	chstatus: (*uint32)(unsafe.Pointer(uintptr(HW_DCP_CH0STAT))),
 	chstaclr: (*uint32)(unsafe.Pointer(uintptr(HW_DCP_CH0STAT_CLR))),
@@ -195,11 +199,20 @@ func (hw *dcp) DeriveKey(diversifier []byte, iv []byte) (key []byte, err error)
 	// p1073, Table 13-12. DCP Payload Field, MCIMX28RM
 	workPacket.PayloadPointer = &iv[0]
 
+	logf("imx6_dcp: waiting for key derivation")
+	err = hw.cmd(workPacket)
+
+	return
+}
+
+// cmd executes a work packet on channel 0 and waits for its completion.
+func (hw *dcp) cmd(workPacket *WorkPacket) (err error) {
 	hw.Lock()
 	defer hw.Unlock()
 
-	// clear channel status
+	// clear channel and global interrupt status
 	*(hw.chstaclr) = 0xffffffff
+	*(hw.staclr) = 1 << HW_DCP_STAT_IRQ
 
 	*(hw.pkt) = workPacket
 	// Flush D cache just before starting the DCP via write to semaphore
@@ -207,9 +220,11 @@ func (hw *dcp) DeriveKey(diversifier []byte, iv []byte) (key []byte, err error)
 	cache.FlushData()
 	reg.Set(hw.sem, 1)
 
-	log.Printf("imx6_dcp: waiting for key derivation")
 	reg.Wait(hw.status, HW_DCP_STAT_IRQ, 0b1, 1)
 
+	// invalidate D cache to observe DCP results
+	cache.FlushData()
+
 	if chstatus := reg.Get(hw.chstatus, 1, 0b111111); chstatus != 0 {
 		if chstatus == 0x2 {
 			// FIXME: even if the operation is correctly done a NO_CHAIN error is
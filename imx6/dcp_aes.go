@@ -0,0 +1,148 @@
+// NXP Data Co-Processor (DCP) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// DCP buffers are copied to cache line aligned buffers to prevent cache
+// maintenance operations from affecting adjacent data.
+const cacheLineSize = 64
+
+// aesCipher performs in-place AES-128 encryption or decryption of the argument
+// buffer, using a software provided key.
+func (hw *dcp) aesCipher(mode uint32, encrypt bool, key []byte, iv []byte, buf []byte) (err error) {
+	if len(key) != aes.BlockSize {
+		return errors.New("invalid key size")
+	}
+
+	if mode == CBC && len(iv) != aes.BlockSize {
+		return errors.New("invalid IV size")
+	}
+
+	// p1057, 13.1.1 DCP Limitations for Software, MCIMX28RM
+	// * buffer size must be aligned to 16 bytes for AES operations
+	if len(buf) == 0 || len(buf)%aes.BlockSize != 0 {
+		return errors.New("invalid buffer size")
+	}
+
+	src := mem.NewAlignmentBuffer(uintptr(len(buf)), cacheLineSize)
+	dst := mem.NewAlignmentBuffer(uintptr(len(buf)), cacheLineSize)
+	mem.Copy(src, buf)
+
+	// p1073, Table 13-12. DCP Payload Field, MCIMX28RM
+	payload := mem.NewAlignmentBuffer(2*aes.BlockSize, cacheLineSize)
+	mem.Copy(payload, append(append([]byte{}, key...), iv...))
+
+	workPacket := &WorkPacket{}
+	reg.Set(&workPacket.Control0, DCP_CTRL0_INTERRUPT_ENABL)
+	reg.Set(&workPacket.Control0, DCP_CTRL0_DECR_SEMAPHORE)
+	reg.Set(&workPacket.Control0, DCP_CTRL0_ENABLE_CIPHER)
+	reg.Set(&workPacket.Control0, DCP_CTRL0_CIPHER_INIT)
+
+	// software key, the payload contains the key followed by the IV
+	reg.Set(&workPacket.Control0, DCP_CTRL0_PAYLOAD_KEY)
+
+	if encrypt {
+		reg.Set(&workPacket.Control0, DCP_CTRL0_CIPHER_ENCRYPT)
+	}
+
+	workPacket.Control1 |= (AES128 << DCP_CTRL1_CIPHER_SELECT)
+	workPacket.Control1 |= (mode << DCP_CTRL1_CIPHER_MODE)
+
+	workPacket.BufferSize = uint32(len(buf))
+	workPacket.SourceBufferAddress = &src.Data()[0]
+	workPacket.DestinationBufferAddress = &dst.Data()[0]
+	workPacket.PayloadPointer = &payload.Data()[0]
+
+	if err = hw.cmd(workPacket); err != nil {
+		return
+	}
+
+	copy(buf, dst.Data())
+
+	return
+}
+
+// AESECBEncrypt performs in-place AES-128 ECB encryption, the buffer size
+// must be a multiple of the AES block size.
+func (hw *dcp) AESECBEncrypt(key []byte, buf []byte) error {
+	return hw.aesCipher(ECB, true, key, nil, buf)
+}
+
+// AESECBDecrypt performs in-place AES-128 ECB decryption, the buffer size
+// must be a multiple of the AES block size.
+func (hw *dcp) AESECBDecrypt(key []byte, buf []byte) error {
+	return hw.aesCipher(ECB, false, key, nil, buf)
+}
+
+// AESCBCEncrypt performs in-place AES-128 CBC encryption, the buffer size
+// must be a multiple of the AES block size.
+func (hw *dcp) AESCBCEncrypt(key []byte, iv []byte, buf []byte) error {
+	return hw.aesCipher(CBC, true, key, iv, buf)
+}
+
+// AESCBCDecrypt performs in-place AES-128 CBC decryption, the buffer size
+// must be a multiple of the AES block size.
+func (hw *dcp) AESCBCDecrypt(key []byte, iv []byte, buf []byte) error {
+	return hw.aesCipher(CBC, false, key, iv, buf)
+}
+
+type dcpCipher struct {
+	hw  *dcp
+	key []byte
+}
+
+// NewCipher returns a cipher.Block, for use with crypto/cipher modes,
+// implementing AES-128 with the DCP.
+func (hw *dcp) NewCipher(key []byte) (cipher.Block, error) {
+	if len(key) != aes.BlockSize {
+		return nil, errors.New("invalid key size")
+	}
+
+	return &dcpCipher{
+		hw:  hw,
+		key: append([]byte{}, key...),
+	}, nil
+}
+
+func (c *dcpCipher) BlockSize() int {
+	return aes.BlockSize
+}
+
+func (c *dcpCipher) Encrypt(dst, src []byte) {
+	c.crypt(true, dst, src)
+}
+
+func (c *dcpCipher) Decrypt(dst, src []byte) {
+	c.crypt(false, dst, src)
+}
+
+func (c *dcpCipher) crypt(encrypt bool, dst, src []byte) {
+	if len(src) < aes.BlockSize || len(dst) < aes.BlockSize {
+		panic("imx6_dcp: invalid block size")
+	}
+
+	buf := append([]byte{}, src[0:aes.BlockSize]...)
+
+	if err := c.hw.aesCipher(ECB, encrypt, c.key, nil, buf); err != nil {
+		panic("imx6_dcp: " + err.Error())
+	}
+
+	copy(dst, buf)
+}
@@ -0,0 +1,202 @@
+// NXP Data Co-Processor (DCP) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// p1068, 13.2.6.4.2 Control0 Field, MCIMX28RM
+	DCP_CTRL0_HASH_TERM   = 13
+	DCP_CTRL0_HASH_INIT   = 12
+	DCP_CTRL0_ENABLE_HASH = 6
+	// p1070, 13.2.6.4.3 Control1 Field, MCIMX28RM
+	DCP_CTRL1_HASH_SELECT = 16
+	// HASH_SELECT field, IMX6ULLRM
+	SHA256 = 0x2
+)
+
+// DCP hash packets are submitted from an aligned buffer of up to
+// dcpHashChunk bytes (a multiple of the SHA-256 block size).
+const dcpHashChunk = 64 * 1024
+
+type dcpHash struct {
+	hw *dcp
+
+	// HASH_INIT packet submitted
+	started bool
+	// pending data, not yet submitted to the DCP (at most one block)
+	buf []byte
+	// aligned packet buffer
+	chunk *mem.AlignmentBuffer
+	// digest, set by Sum()
+	sum []byte
+	// first DCP error
+	err error
+}
+
+// NewSHA256 returns a hash.Hash computing SHA-256 checksums with the DCP, an
+// error is returned if the DCP is not initialized (see Init()).
+//
+// Written data is submitted to the DCP in full blocks as it is received, the
+// first work packet carrying the hash initialization flag, only the trailing
+// partial (or last full) block is retained until Sum(), which submits it with
+// the hash termination flag. Between the first Write() and Sum() the hash
+// state is held by the DCP channel, therefore no other DCP operation must be
+// performed meanwhile.
+//
+// Once Sum() is invoked the hash is finalized, further writes are rejected
+// until Reset() is called. Sum() panics if the DCP reports an error, DCP
+// errors are also returned by Write().
+func (hw *dcp) NewSHA256() (h hash.Hash, err error) {
+	if reg.Get(hw.ctrl, HW_DCP_CTRL_SFTRST, 0b1) == 1 || reg.Get(hw.ctrl, HW_DCP_CTRL_CLKGATE, 0b1) == 1 {
+		return nil, errors.New("DCP not initialized")
+	}
+
+	return &dcpHash{
+		hw: hw,
+	}, nil
+}
+
+func (d *dcpHash) Write(p []byte) (n int, err error) {
+	if d.sum != nil {
+		return 0, errors.New("hash already finalized")
+	}
+
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	n = len(p)
+
+	// Submit all data but the last block, which is retained to ensure that
+	// the terminating packet is never empty.
+	for len(d.buf)+len(p) > sha256.BlockSize {
+		size := (len(d.buf) + len(p) - 1) / sha256.BlockSize * sha256.BlockSize
+
+		if size > dcpHashChunk {
+			size = dcpHashChunk
+		}
+
+		if d.chunk == nil {
+			d.chunk = mem.NewAlignmentBuffer(dcpHashChunk, cacheLineSize)
+		}
+
+		data := d.chunk.Data()[0:size]
+		off := copy(data, d.buf)
+		copy(data[off:], p[0:size-off])
+
+		p = p[size-off:]
+		d.buf = d.buf[:0]
+
+		if _, err = d.hw.hash(data, !d.started, false); err != nil {
+			d.err = err
+			return 0, err
+		}
+
+		d.started = true
+	}
+
+	d.buf = append(d.buf, p...)
+
+	return
+}
+
+func (d *dcpHash) Sum(b []byte) []byte {
+	if d.sum == nil {
+		if d.err != nil {
+			panic("imx6_dcp: " + d.err.Error())
+		}
+
+		if !d.started && len(d.buf) == 0 {
+			// zero length buffers are not supported by the DCP
+			s := sha256.Sum256(nil)
+			d.sum = s[:]
+		} else {
+			src := mem.NewAlignmentBuffer(uintptr(len(d.buf)), cacheLineSize)
+			mem.Copy(src, d.buf)
+
+			sum, err := d.hw.hash(src.Data()[0:len(d.buf)], !d.started, true)
+
+			if err != nil {
+				panic("imx6_dcp: " + err.Error())
+			}
+
+			d.sum = sum
+		}
+	}
+
+	return append(b, d.sum...)
+}
+
+func (d *dcpHash) Reset() {
+	d.started = false
+	d.buf = d.buf[:0]
+	d.sum = nil
+	d.err = nil
+}
+
+func (d *dcpHash) Size() int {
+	return sha256.Size
+}
+
+func (d *dcpHash) BlockSize() int {
+	return sha256.BlockSize
+}
+
+// hash submits a SHA-256 work packet for the argument aligned buffer, the
+// digest is returned only for terminating packets.
+func (hw *dcp) hash(data []byte, init bool, term bool) (sum []byte, err error) {
+	workPacket := &WorkPacket{}
+	reg.Set(&workPacket.Control0, DCP_CTRL0_INTERRUPT_ENABL)
+	reg.Set(&workPacket.Control0, DCP_CTRL0_DECR_SEMAPHORE)
+	reg.Set(&workPacket.Control0, DCP_CTRL0_ENABLE_HASH)
+
+	if init {
+		reg.Set(&workPacket.Control0, DCP_CTRL0_HASH_INIT)
+	}
+
+	workPacket.Control1 |= (SHA256 << DCP_CTRL1_HASH_SELECT)
+
+	workPacket.BufferSize = uint32(len(data))
+	workPacket.SourceBufferAddress = &data[0]
+	workPacket.DestinationBufferAddress = nil
+
+	var digest *mem.AlignmentBuffer
+
+	if term {
+		reg.Set(&workPacket.Control0, DCP_CTRL0_HASH_TERM)
+
+		// the resulting digest is written in the payload
+		digest = mem.NewAlignmentBuffer(sha256.Size, cacheLineSize)
+		workPacket.PayloadPointer = &digest.Data()[0]
+	}
+
+	if err = hw.cmd(workPacket); err != nil || !term {
+		return
+	}
+
+	sum = make([]byte, sha256.Size)
+
+	// the DCP returns the digest in reversed byte order
+	for i, b := range digest.Data()[0:sha256.Size] {
+		sum[sha256.Size-1-i] = b
+	}
+
+	return
+}
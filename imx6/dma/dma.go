@@ -0,0 +1,245 @@
+// NXP i.MX6 DMA memory allocation
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package dma provides a simple allocator for DMA buffers, carved from a
+// memory region reserved outside the Go runtime heap, ensuring that buffers
+// passed to DMA capable peripherals are never moved or collected by the
+// garbage collector.
+//
+// The region must be excluded from the memory available to the runtime, by
+// reducing the board RAM size (runtime.ramSize), before being passed to
+// Reserve() (or Init(), which does not check the runtime memory layout):
+//
+//	// board package: reserve the last 4 MB of a 512 MB DDR
+//	//go:linkname ramSize runtime.ramSize
+//	var ramSize uint32 = 0x20000000 - 0x400000
+//
+//	// application
+//	dma.Reserve(0x80000000+0x20000000-0x400000, 0x400000)
+//
+//	buf, addr, err := dma.Alloc(512, 64)
+//	defer dma.Free(addr)
+package dma
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/cache"
+)
+
+// Buffers are aligned, at minimum, to the cache line size to prevent cache
+// maintenance operations from affecting adjacent data.
+const CACHE_LINE_SIZE = 64
+
+type block struct {
+	addr uint32
+	size uint32
+	res  bool
+}
+
+// defined in the SoC and board packages
+//
+//go:linkname ramStart runtime.ramStart
+var ramStart uint32
+
+//go:linkname ramSize runtime.ramSize
+var ramSize uint32
+
+var (
+	mutex  sync.Mutex
+	start  uint32
+	end    uint32
+	blocks *list.List
+)
+
+// Init initializes the DMA memory region, any previous allocation is
+// discarded.
+func Init(addr uint32, size int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if size <= 0 || uint64(addr)+uint64(size) > 1<<32 {
+		return errors.New("invalid DMA region")
+	}
+
+	start = addr
+	end = addr + uint32(size)
+
+	blocks = list.New()
+	blocks.PushFront(&block{addr: start, size: uint32(size)})
+
+	return
+}
+
+// Reserve initializes the DMA memory region, as Init(), after verifying that
+// it does not overlap with the RAM available to the Go runtime
+// (runtime.ramStart, runtime.ramSize), which must therefore be reduced at
+// link time to exclude the region.
+func Reserve(base uintptr, size uintptr) (err error) {
+	if size == 0 || uint64(base)+uint64(size) > 1<<32 {
+		return errors.New("invalid DMA region")
+	}
+
+	ramEnd := uint64(ramStart) + uint64(ramSize)
+
+	if uint64(base) < ramEnd && uint64(base)+uint64(size) > uint64(ramStart) {
+		return errors.New("DMA region overlaps runtime memory")
+	}
+
+	return Init(uint32(base), int(size))
+}
+
+// Alloc reserves a buffer of the argument size and alignment (rounded up to a
+// multiple of CACHE_LINE_SIZE) from the DMA region, returning its slice and
+// physical address.
+func Alloc(size int, align int) (buf []byte, addr uint32, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if blocks == nil {
+		return nil, 0, errors.New("DMA region not initialized")
+	}
+
+	if size <= 0 {
+		return nil, 0, errors.New("invalid size")
+	}
+
+	if align < CACHE_LINE_SIZE {
+		align = CACHE_LINE_SIZE
+	}
+
+	if align&(align-1) != 0 {
+		return nil, 0, errors.New("invalid alignment")
+	}
+
+	// round up to preserve alignment of following blocks
+	n := (uint32(size) + CACHE_LINE_SIZE - 1) &^ (CACHE_LINE_SIZE - 1)
+
+	for e := blocks.Front(); e != nil; e = e.Next() {
+		b := e.Value.(*block)
+
+		if b.res {
+			continue
+		}
+
+		pad := (uint32(align) - b.addr%uint32(align)) % uint32(align)
+
+		if b.size < pad+n {
+			continue
+		}
+
+		if pad != 0 {
+			// split alignment padding as a free block
+			blocks.InsertBefore(&block{addr: b.addr, size: pad}, e)
+			b.addr += pad
+			b.size -= pad
+		}
+
+		if b.size > n {
+			// split remainder as a free block
+			blocks.InsertAfter(&block{addr: b.addr + n, size: b.size - n}, e)
+			b.size = n
+		}
+
+		b.res = true
+
+		return slice(b.addr, size), b.addr, nil
+	}
+
+	return nil, 0, errors.New("out of DMA memory")
+}
+
+// Free releases a buffer, identified by its physical address, previously
+// obtained with Alloc().
+func Free(addr uint32) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if blocks == nil {
+		return errors.New("DMA region not initialized")
+	}
+
+	for e := blocks.Front(); e != nil; e = e.Next() {
+		b := e.Value.(*block)
+
+		if b.addr != addr || !b.res {
+			continue
+		}
+
+		b.res = false
+
+		// coalesce with adjacent free blocks
+		if next := e.Next(); next != nil && !next.Value.(*block).res {
+			b.size += next.Value.(*block).size
+			blocks.Remove(next)
+		}
+
+		if prev := e.Prev(); prev != nil && !prev.Value.(*block).res {
+			prev.Value.(*block).size += b.size
+			blocks.Remove(e)
+		}
+
+		return
+	}
+
+	return errors.New("invalid DMA buffer")
+}
+
+func slice(addr uint32, size int) []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(uintptr(addr)))[:size:size]
+}
+
+func check(addr uint32, off int, size int) error {
+	if addr < start || off < 0 || uint64(addr)+uint64(off)+uint64(size) > uint64(end) {
+		return errors.New("invalid DMA buffer range")
+	}
+
+	return nil
+}
+
+// Read copies data from a DMA buffer, at the argument offset, to a slice.
+// Cache maintenance is performed before reading, to observe data written by
+// DMA.
+func Read(addr uint32, off int, buf []byte) (err error) {
+	if err = check(addr, off, len(buf)); err != nil {
+		return
+	}
+
+	cache.InvalidateRange(uintptr(addr)+uintptr(off), uintptr(len(buf)))
+	copy(buf, slice(addr+uint32(off), len(buf)))
+
+	return
+}
+
+// Write copies data from a slice to a DMA buffer, at the argument offset.
+// Cache maintenance is performed after writing, to ensure data visibility to
+// DMA.
+func Write(addr uint32, off int, buf []byte) (err error) {
+	if err = check(addr, off, len(buf)); err != nil {
+		return
+	}
+
+	copy(slice(addr+uint32(off), len(buf)), buf)
+	cache.CleanRange(uintptr(addr)+uintptr(off), uintptr(len(buf)))
+
+	return
+}
+
+// FlushInvalidate cleans and invalidates the data cache lines of a DMA
+// buffer, it must be invoked before handing buffers to DMA and before
+// accessing DMA written buffers, when buffers are accessed directly rather
+// than with Read() and Write().
+func FlushInvalidate(addr uint32, size int) {
+	cache.FlushRange(uintptr(addr), uintptr(size))
+}
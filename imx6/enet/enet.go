@@ -0,0 +1,461 @@
+// NXP 10/100-Mbps Ethernet MAC (ENET) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package enet implements a driver for the NXP i.MX6 10/100-Mbps Ethernet MAC
+// (ENET), using legacy buffer descriptors for frame transmission and
+// reception.
+//
+// The ENET pads, and reference clock direction (IOMUXC_GPR1), must be
+// configured before use, for example:
+//
+//	enet.ENET1.Init(enet.RMII)
+//	enet.ENET1.ResetPHY(0)
+//	enet.ENET1.Start(func(frame []byte) {
+//		// handle incoming frame
+//	})
+//
+//	err := enet.ENET1.Transmit(frame)
+//
+// Controllers also implement the NIC interface, to be used as link layer
+// endpoint of a network stack.
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+	"github.com/inversepath/tamago/imx6/ocotp"
+)
+
+// ENET Memory Map/Register Definition, IMX6ULLRM
+const (
+	ENET1_BASE uint32 = 0x02188000
+	ENET2_BASE uint32 = 0x020b4000
+
+	ENET_EIR     = 0x004
+	ENET_EIR_TXF = 27
+	ENET_EIR_RXF = 25
+	ENET_EIR_MII = 23
+
+	ENET_EIMR = 0x008
+
+	ENET_RDAR = 0x010
+	ENET_TDAR = 0x014
+	// RDAR/TDAR descriptor active
+	ENET_DAR_ACTIVE = 24
+
+	ENET_ECR         = 0x024
+	ENET_ECR_DBSWP   = 8
+	ENET_ECR_ETHEREN = 1
+	ENET_ECR_RESET   = 0
+
+	ENET_MMFR    = 0x040
+	ENET_MMFR_ST = 30
+	ENET_MMFR_OP = 28
+	ENET_MMFR_PA = 23
+	ENET_MMFR_RA = 18
+	ENET_MMFR_TA = 16
+
+	ENET_MSCR           = 0x044
+	ENET_MSCR_MII_SPEED = 1
+
+	ENET_RCR           = 0x084
+	ENET_RCR_MAX_FL    = 16
+	ENET_RCR_CRCFWD    = 14
+	ENET_RCR_RMII_MODE = 8
+	ENET_RCR_FCE       = 5
+	ENET_RCR_MII_MODE  = 2
+
+	ENET_TCR      = 0x0c4
+	ENET_TCR_FDEN = 2
+
+	ENET_PALR = 0x0e4
+	ENET_PAUR = 0x0e8
+	ENET_IAUR = 0x118
+	ENET_IALR = 0x11c
+	ENET_GAUR = 0x120
+	ENET_GALR = 0x124
+
+	ENET_TFWR        = 0x144
+	ENET_TFWR_STRFWD = 8
+
+	ENET_RDSR = 0x180
+	ENET_TDSR = 0x184
+	ENET_MRBR = 0x188
+)
+
+// Legacy buffer descriptor status flags
+// (Legacy Buffer Descriptors, IMX6ULLRM).
+const (
+	BD_RX_E  = 1 << 15
+	BD_RX_W  = 1 << 13
+	BD_RX_L  = 1 << 11
+	BD_RX_LG = 1 << 5
+	BD_RX_NO = 1 << 4
+	BD_RX_CR = 1 << 2
+	BD_RX_OV = 1 << 1
+	BD_RX_TR = 1 << 0
+
+	BD_RX_ERRORS = BD_RX_LG | BD_RX_NO | BD_RX_CR | BD_RX_OV | BD_RX_TR
+
+	BD_TX_R  = 1 << 15
+	BD_TX_W  = 1 << 13
+	BD_TX_L  = 1 << 11
+	BD_TX_TC = 1 << 10
+
+	// buffer descriptor size
+	BD_SIZE = 8
+)
+
+const (
+	// MII/RMII interface modes
+	MII = iota
+	RMII
+
+	// maximum frame length (excluding CRC)
+	MAX_FRAME_SIZE = 1514
+	// receive buffer size, DMA buffers and rings are aligned to 64 bytes
+	BUFFER_SIZE = 1536
+	ALIGNMENT   = 64
+
+	// number of buffer descriptors per ring
+	RING_SIZE = 16
+
+	// maximum MDC frequency
+	MDC_FREQ = 2500000
+)
+
+type ring struct {
+	desc *mem.AlignmentBuffer
+	buf  *mem.AlignmentBuffer
+	idx  int
+}
+
+type enet struct {
+	sync.Mutex
+
+	// controller index
+	n int
+
+	eir  *uint32
+	eimr *uint32
+	rdar *uint32
+	tdar *uint32
+	ecr  *uint32
+	mmfr *uint32
+	mscr *uint32
+	rcr  *uint32
+	tcr  *uint32
+	palr *uint32
+	paur *uint32
+	iaur *uint32
+	ialr *uint32
+	gaur *uint32
+	galr *uint32
+	tfwr *uint32
+	rdsr *uint32
+	tdsr *uint32
+	mrbr *uint32
+
+	// MAC address
+	mac net.HardwareAddr
+
+	rx ring
+	tx ring
+
+	// receive handler identifier
+	rxid uint64
+}
+
+func newENET(n int, base uint32) *enet {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &enet{
+		n:    n,
+		eir:  r(ENET_EIR),
+		eimr: r(ENET_EIMR),
+		rdar: r(ENET_RDAR),
+		tdar: r(ENET_TDAR),
+		ecr:  r(ENET_ECR),
+		mmfr: r(ENET_MMFR),
+		mscr: r(ENET_MSCR),
+		rcr:  r(ENET_RCR),
+		tcr:  r(ENET_TCR),
+		palr: r(ENET_PALR),
+		paur: r(ENET_PAUR),
+		iaur: r(ENET_IAUR),
+		ialr: r(ENET_IALR),
+		gaur: r(ENET_GAUR),
+		galr: r(ENET_GALR),
+		tfwr: r(ENET_TFWR),
+		rdsr: r(ENET_RDSR),
+		tdsr: r(ENET_TDSR),
+		mrbr: r(ENET_MRBR),
+	}
+}
+
+var ENET1 = newENET(1, ENET1_BASE)
+var ENET2 = newENET(2, ENET2_BASE)
+
+// SetMAC overrides the MAC address, which otherwise defaults to the one
+// programmed in OCOTP fuses, it must be invoked before Init().
+func (hw *enet) SetMAC(mac net.HardwareAddr) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if len(mac) != 6 {
+		return errors.New("invalid MAC address")
+	}
+
+	hw.mac = append(net.HardwareAddr{}, mac...)
+
+	return
+}
+
+// MAC returns the configured MAC address.
+func (hw *enet) MAC() net.HardwareAddr {
+	return hw.mac
+}
+
+func newRing(size int) ring {
+	return ring{
+		desc: mem.NewAlignmentBuffer(RING_SIZE*BD_SIZE, ALIGNMENT),
+		buf:  mem.NewAlignmentBuffer(uintptr(RING_SIZE*size), ALIGNMENT),
+	}
+}
+
+func (r *ring) status(i int) uint16 {
+	return binary.LittleEndian.Uint16(r.desc.Data()[i*BD_SIZE+2:])
+}
+
+func (r *ring) length(i int) uint16 {
+	return binary.LittleEndian.Uint16(r.desc.Data()[i*BD_SIZE:])
+}
+
+func (r *ring) set(i int, length uint16, status uint16) {
+	d := r.desc.Data()[i*BD_SIZE:]
+
+	if i == RING_SIZE-1 {
+		// wrap (last descriptor), same position for RX and TX
+		status |= BD_RX_W
+	}
+
+	binary.LittleEndian.PutUint16(d[0:], length)
+	binary.LittleEndian.PutUint32(d[4:], uint32(r.buf.Addr)+uint32(i*BUFFER_SIZE))
+	// status is written last as it hands over the descriptor ownership
+	binary.LittleEndian.PutUint16(d[2:], status)
+}
+
+func (r *ring) data(i int) []byte {
+	return r.buf.Data()[i*BUFFER_SIZE : (i+1)*BUFFER_SIZE]
+}
+
+// Init initializes and enables the ENET controller in the argument interface
+// mode (MII or RMII), for 100 Mbps full-duplex operation.
+func (hw *enet) Init(mode int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if mode != MII && mode != RMII {
+		return errors.New("invalid interface mode")
+	}
+
+	if hw.mac == nil {
+		if hw.mac, err = ocotp.MAC(); err != nil {
+			return
+		}
+	}
+
+	if err = imx6.EnableClock(3, imx6.CCM_CCGR3_ENET, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	if mode == RMII {
+		// 50 MHz reference clock
+		if err = imx6.SetENETPLL(hw.n, 50000000); err != nil {
+			return
+		}
+	}
+
+	// soft reset
+	reg.Set(hw.ecr, ENET_ECR_RESET)
+	reg.Wait(hw.ecr, ENET_ECR_RESET, 0b1, 0)
+
+	// mask and clear all interrupts (events are polled)
+	reg.Write(hw.eimr, 0)
+	reg.Write(hw.eir, 0xffffffff)
+
+	hw.setMDC()
+
+	// MAC address
+	reg.Write(hw.palr, binary.BigEndian.Uint32(hw.mac[0:4]))
+	reg.Write(hw.paur, uint32(binary.BigEndian.Uint16(hw.mac[4:6]))<<16)
+
+	// clear hash tables
+	reg.Write(hw.iaur, 0)
+	reg.Write(hw.ialr, 0)
+	reg.Write(hw.gaur, 0)
+	reg.Write(hw.galr, 0)
+
+	rcr := uint32(MAX_FRAME_SIZE+4) << ENET_RCR_MAX_FL
+	rcr |= 1<<ENET_RCR_CRCFWD | 1<<ENET_RCR_FCE | 1<<ENET_RCR_MII_MODE
+
+	if mode == RMII {
+		rcr |= 1 << ENET_RCR_RMII_MODE
+	}
+
+	reg.Write(hw.rcr, rcr)
+	reg.Write(hw.tcr, 1<<ENET_TCR_FDEN)
+	reg.Write(hw.tfwr, 1<<ENET_TFWR_STRFWD)
+
+	// buffer descriptor rings
+	hw.rx = newRing(BUFFER_SIZE)
+	hw.tx = newRing(BUFFER_SIZE)
+
+	for i := 0; i < RING_SIZE; i++ {
+		hw.rx.set(i, 0, BD_RX_E)
+		hw.tx.set(i, 0, 0)
+	}
+
+	cache.FlushData()
+
+	reg.Write(hw.rdsr, uint32(hw.rx.desc.Addr))
+	reg.Write(hw.tdsr, uint32(hw.tx.desc.Addr))
+	reg.Write(hw.mrbr, BUFFER_SIZE)
+
+	// little endian descriptors
+	reg.Set(hw.ecr, ENET_ECR_DBSWP)
+	reg.Set(hw.ecr, ENET_ECR_ETHEREN)
+
+	reg.Write(hw.rdar, 1<<ENET_DAR_ACTIVE)
+
+	return
+}
+
+// Transmit queues an Ethernet frame (excluding CRC, which is appended by the
+// controller) for transmission.
+func (hw *enet) Transmit(frame []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if len(frame) > MAX_FRAME_SIZE {
+		return errors.New("invalid frame size")
+	}
+
+	if hw.tx.desc == nil {
+		return errors.New("controller not initialized")
+	}
+
+	i := hw.tx.idx
+
+	cache.FlushData()
+
+	if hw.tx.status(i)&BD_TX_R != 0 {
+		return errors.New("transmit ring full")
+	}
+
+	copy(hw.tx.data(i), frame)
+	hw.tx.set(i, uint16(len(frame)), BD_TX_R|BD_TX_L|BD_TX_TC)
+
+	cache.FlushData()
+	reg.Write(hw.tdar, 1<<ENET_DAR_ACTIVE)
+
+	hw.tx.idx = (i + 1) % RING_SIZE
+
+	return
+}
+
+// Receive returns a received Ethernet frame (excluding CRC), if available,
+// frames with reception errors are discarded.
+func (hw *enet) Receive() (frame []byte) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.rx.desc == nil {
+		return
+	}
+
+	for {
+		i := hw.rx.idx
+
+		cache.FlushData()
+		status := hw.rx.status(i)
+
+		if status&BD_RX_E != 0 {
+			return
+		}
+
+		if status&BD_RX_L != 0 && status&BD_RX_ERRORS == 0 {
+			length := int(hw.rx.length(i))
+			frame = append([]byte{}, hw.rx.data(i)[0:length]...)
+		}
+
+		// hand the descriptor back to the controller
+		hw.rx.set(i, 0, BD_RX_E)
+		cache.FlushData()
+		reg.Write(hw.rdar, 1<<ENET_DAR_ACTIVE)
+
+		hw.rx.idx = (i + 1) % RING_SIZE
+
+		if frame != nil {
+			return
+		}
+	}
+}
+
+// Start executes the argument function for each received Ethernet frame,
+// which is invoked from a goroutine polling the receive ring. Any previously
+// started handler is replaced.
+func (hw *enet) Start(rx func([]byte)) {
+	hw.Lock()
+	hw.rxid += 1
+	id := hw.rxid
+	hw.Unlock()
+
+	go func() {
+		for {
+			hw.Lock()
+			active := hw.rxid == id
+			hw.Unlock()
+
+			if !active {
+				return
+			}
+
+			if frame := hw.Receive(); frame != nil {
+				rx(frame)
+				continue
+			}
+
+			// tamago is single-threaded so we must force giving
+			// other goroutines a chance
+			runtime.Gosched()
+		}
+	}()
+}
+
+// Stop stops the receive handler set with Start().
+func (hw *enet) Stop() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.rxid += 1
+}
@@ -0,0 +1,133 @@
+// NXP 10/100-Mbps Ethernet MAC (ENET) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package enet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// MMFR operation codes (clause 22)
+	MMFR_ST    = 0b01
+	MMFR_READ  = 0b10
+	MMFR_WRITE = 0b01
+	MMFR_TA    = 0b10
+
+	// IEEE 802.3 Basic Mode Control Register
+	PHY_BMCR         = 0x00
+	PHY_BMCR_RESET   = 15
+	PHY_BMCR_ANEN    = 12
+	PHY_BMCR_RESTART = 9
+
+	MDIO_TIMEOUT = 10 * time.Millisecond
+)
+
+func (hw *enet) setMDC() {
+	// MDC = IPG / ((MII_SPEED + 1) * 2)
+	speed := (imx6.IPGFreq()+2*MDC_FREQ-1)/(2*MDC_FREQ) - 1
+	reg.SetN(hw.mscr, ENET_MSCR_MII_SPEED, 0b111111, speed)
+}
+
+// InitMDIO enables the controller clock and configures the MDC clock divider,
+// allowing PHY management through MDIORead() and MDIOWrite() without a full
+// controller initialization (e.g. to probe PHY identifiers during bring-up).
+//
+// The MDIO pads must be configured (see package iomuxc) before use, any
+// subsequent Init() reconfigures the MDC clock divider.
+func (hw *enet) InitMDIO() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if err = imx6.EnableClock(3, imx6.CCM_CCGR3_ENET, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	hw.setMDC()
+
+	return
+}
+
+func (hw *enet) mdio(op uint32, pa int, ra int, data uint16) (val uint16, err error) {
+	if pa < 0 || pa > 31 || ra < 0 || ra > 31 {
+		return 0, errors.New("invalid PHY or register address")
+	}
+
+	reg.Write(hw.eir, 1<<ENET_EIR_MII)
+
+	mmfr := uint32(MMFR_ST<<ENET_MMFR_ST | MMFR_TA<<ENET_MMFR_TA)
+	mmfr |= op << ENET_MMFR_OP
+	mmfr |= uint32(pa) << ENET_MMFR_PA
+	mmfr |= uint32(ra) << ENET_MMFR_RA
+	mmfr |= uint32(data)
+
+	reg.Write(hw.mmfr, mmfr)
+
+	if !reg.WaitFor(MDIO_TIMEOUT, hw.eir, ENET_EIR_MII, 0b1, 1) {
+		return 0, errors.New("MDIO timeout")
+	}
+
+	reg.Write(hw.eir, 1<<ENET_EIR_MII)
+
+	return uint16(reg.Read(hw.mmfr)), nil
+}
+
+// MDIORead reads a PHY register through the MDIO management interface, the
+// controller must be initialized with Init() or InitMDIO().
+func (hw *enet) MDIORead(pa int, ra int) (val uint16, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.mdio(MMFR_READ, pa, ra, 0)
+}
+
+// MDIOWrite writes a PHY register through the MDIO management interface, the
+// controller must be initialized with Init() or InitMDIO().
+func (hw *enet) MDIOWrite(pa int, ra int, val uint16) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	_, err = hw.mdio(MMFR_WRITE, pa, ra, val)
+
+	return
+}
+
+// ResetPHY resets the PHY at the argument address and restarts
+// auto-negotiation.
+func (hw *enet) ResetPHY(pa int) (err error) {
+	if err = hw.MDIOWrite(pa, PHY_BMCR, 1<<PHY_BMCR_RESET); err != nil {
+		return
+	}
+
+	start := time.Now()
+
+	for {
+		bmcr, err := hw.MDIORead(pa, PHY_BMCR)
+
+		if err != nil {
+			return err
+		}
+
+		if bmcr&(1<<PHY_BMCR_RESET) == 0 {
+			break
+		}
+
+		if time.Since(start) > 500*time.Millisecond {
+			return errors.New("PHY reset timeout")
+		}
+	}
+
+	return hw.MDIOWrite(pa, PHY_BMCR, 1<<PHY_BMCR_ANEN|1<<PHY_BMCR_RESTART)
+}
@@ -0,0 +1,72 @@
+// NXP 10/100-Mbps Ethernet MAC (ENET) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package enet
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+// MTU represents the maximum Ethernet payload size, excluding the 14 bytes
+// Ethernet header.
+const MTU = MAX_FRAME_SIZE - 14
+
+// NIC represents a link layer endpoint, to bind the controller to a network
+// stack (e.g. gVisor tcpip link endpoints), exchanging complete Ethernet
+// frames excluding the FCS.
+type NIC interface {
+	WritePacket(frame []byte) error
+	ReadPacket() ([]byte, error)
+	LinkAddress() net.HardwareAddr
+	MTU() uint32
+}
+
+var _ NIC = (*enet)(nil)
+
+// WritePacket transmits an Ethernet frame, excluding the FCS, as Transmit().
+func (hw *enet) WritePacket(frame []byte) error {
+	return hw.Transmit(frame)
+}
+
+// ReadPacket waits for, and returns, a received Ethernet frame with the FCS
+// stripped. It must not be used concurrently with Start(), as frames are
+// consumed by either.
+func (hw *enet) ReadPacket() (frame []byte, err error) {
+	for {
+		hw.Lock()
+		initialized := hw.rx.desc != nil
+		hw.Unlock()
+
+		if !initialized {
+			return nil, errors.New("controller not initialized")
+		}
+
+		if frame = hw.Receive(); frame != nil {
+			return
+		}
+
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+}
+
+// LinkAddress returns the controller MAC address.
+func (hw *enet) LinkAddress() net.HardwareAddr {
+	return hw.MAC()
+}
+
+// MTU returns the maximum Ethernet payload size.
+func (hw *enet) MTU() uint32 {
+	return MTU
+}
@@ -0,0 +1,181 @@
+// NXP Enhanced Periodic Interrupt Timer (EPIT) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package epit implements a driver for the NXP i.MX6 Enhanced Periodic
+// Interrupt Timer (EPIT), clocked from PERCLK, providing periodic callbacks
+// independent from the GPT.
+//
+// Callbacks are dispatched through the GIC (see package gic), which must be
+// initialized and serviced by the application:
+//
+//	gic.Init()
+//	epit.EPIT1.SetInterval(10*time.Millisecond, readSensor)
+//	gic.Start()
+package epit
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/gic"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// EPIT Memory Map/Register Definition, IMX6ULLRM
+const (
+	EPIT1_BASE uint32 = 0x020d0000
+	EPIT2_BASE uint32 = 0x020d4000
+
+	EPIT_CR           = 0x00
+	EPIT_CR_CLKSRC    = 24
+	EPIT_CR_STOPEN    = 21
+	EPIT_CR_WAITEN    = 19
+	EPIT_CR_DBGEN     = 18
+	EPIT_CR_IOVW      = 17
+	EPIT_CR_SWR       = 16
+	EPIT_CR_PRESCALAR = 4
+	EPIT_CR_RLD       = 3
+	EPIT_CR_OCIEN     = 2
+	EPIT_CR_ENMOD     = 1
+	EPIT_CR_EN        = 0
+
+	EPIT_SR      = 0x04
+	EPIT_SR_OCIF = 0
+
+	EPIT_LR   = 0x08
+	EPIT_CMPR = 0x0c
+	EPIT_CNR  = 0x10
+
+	// ipg_clk_highfreq (PERCLK)
+	CLKSRC_HIGHFREQ = 0b10
+
+	// maximum prescaler value (PRESCALAR + 1)
+	MAX_PRESCALER = 4096
+
+	// EPIT output compare interrupts (GIC interrupt ID)
+	EPIT1_IRQ = gic.SPI_BASE + 56
+	EPIT2_IRQ = gic.SPI_BASE + 57
+
+	// interrupt priority
+	EPIT_PRIORITY = 0x80
+)
+
+type epit struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+	// interrupt ID
+	irq int
+
+	cr   *uint32
+	sr   *uint32
+	lr   *uint32
+	cmpr *uint32
+}
+
+func newEPIT(base uint32, cg int, irq int) *epit {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &epit{
+		cg:   cg,
+		irq:  irq,
+		cr:   r(EPIT_CR),
+		sr:   r(EPIT_SR),
+		lr:   r(EPIT_LR),
+		cmpr: r(EPIT_CMPR),
+	}
+}
+
+var EPIT1 = newEPIT(EPIT1_BASE, imx6.CCM_CCGR1_EPIT1, EPIT1_IRQ)
+var EPIT2 = newEPIT(EPIT2_BASE, imx6.CCM_CCGR1_EPIT2, EPIT2_IRQ)
+
+// SetInterval starts the EPIT as a periodic down-counter, invoking the
+// argument function, from the GIC interrupt handler, every time the interval
+// elapses. Any previous interval is replaced.
+func (hw *epit) SetInterval(d time.Duration, fn func()) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if d <= 0 || fn == nil {
+		return errors.New("invalid interval")
+	}
+
+	// interval in PERCLK cycles
+	cycles := uint64(d) * uint64(imx6.PerclkFreq()) / uint64(time.Second)
+	prescaler := cycles>>32 + 1
+
+	if prescaler > MAX_PRESCALER {
+		return errors.New("interval too long")
+	}
+
+	load := cycles / prescaler
+
+	if load == 0 {
+		return errors.New("interval too short")
+	}
+
+	if err = imx6.EnableClock(1, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	hw.stop()
+
+	// software reset
+	reg.Set(hw.cr, EPIT_CR_SWR)
+	reg.Wait(hw.cr, EPIT_CR_SWR, 0b1, 0)
+
+	// reload from EPITLR, compare event on counter reaching zero
+	reg.Write(hw.cr, CLKSRC_HIGHFREQ<<EPIT_CR_CLKSRC|
+		uint32(prescaler-1)<<EPIT_CR_PRESCALAR|
+		1<<EPIT_CR_IOVW|
+		1<<EPIT_CR_RLD|
+		1<<EPIT_CR_ENMOD)
+
+	reg.Write(hw.lr, uint32(load-1))
+	reg.Write(hw.cmpr, 0)
+	reg.Write(hw.sr, 1<<EPIT_SR_OCIF)
+
+	gic.SetHandler(hw.irq, func() {
+		reg.Write(hw.sr, 1<<EPIT_SR_OCIF)
+		fn()
+	})
+
+	if err = gic.EnableInterrupt(hw.irq, EPIT_PRIORITY); err != nil {
+		return
+	}
+
+	reg.Set(hw.cr, EPIT_CR_OCIEN)
+	reg.Set(hw.cr, EPIT_CR_EN)
+
+	return
+}
+
+// Stop stops the EPIT and disables its interrupt.
+func (hw *epit) Stop() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.stop()
+}
+
+func (hw *epit) stop() {
+	reg.Clear(hw.cr, EPIT_CR_EN)
+	reg.Clear(hw.cr, EPIT_CR_OCIEN)
+
+	gic.DisableInterrupt(hw.irq)
+	gic.SetHandler(hw.irq, nil)
+}
@@ -0,0 +1,459 @@
+// NXP Flexible Controller Area Network (FlexCAN) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package flexcan implements a driver for the NXP i.MX6 Flexible Controller
+// Area Network (FlexCAN) controllers, clocked from the 24 MHz crystal
+// oscillator, supporting standard (11-bit) and extended (29-bit) data
+// frames.
+//
+// The CAN pads must be configured (see package iomuxc) before use, for
+// example:
+//
+//	flexcan.CAN1.Init(500000)
+//	flexcan.CAN1.Send(0x123, []byte{0xde, 0xad})
+//
+//	if id, data, ok := flexcan.CAN1.Receive(); ok {
+//		// handle frame
+//	}
+package flexcan
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// FlexCAN Memory Map/Register Definition, IMX6ULLRM
+const (
+	CAN1_BASE uint32 = 0x02090000
+	CAN2_BASE uint32 = 0x02094000
+
+	CAN_MCR         = 0x00
+	CAN_MCR_MDIS    = 31
+	CAN_MCR_FRZ     = 30
+	CAN_MCR_HALT    = 28
+	CAN_MCR_NOTRDY  = 27
+	CAN_MCR_SOFTRST = 25
+	CAN_MCR_FRZACK  = 24
+	CAN_MCR_LPMACK  = 20
+	CAN_MCR_SRXDIS  = 17
+	CAN_MCR_MAXMB   = 0
+
+	CAN_CTRL1         = 0x04
+	CAN_CTRL1_PRESDIV = 24
+	CAN_CTRL1_RJW     = 22
+	CAN_CTRL1_PSEG1   = 19
+	CAN_CTRL1_PSEG2   = 16
+	CAN_CTRL1_CLKSRC  = 13
+	CAN_CTRL1_PROPSEG = 0
+
+	CAN_TIMER    = 0x08
+	CAN_RXMGMASK = 0x10
+	CAN_IMASK1   = 0x28
+	CAN_IFLAG1   = 0x30
+
+	// message buffers
+	CAN_MB      = 0x80
+	CAN_MB_SIZE = 0x10
+
+	MB_CS_CODE = 24
+	MB_CS_SRR  = 22
+	MB_CS_IDE  = 21
+	MB_CS_DLC  = 16
+
+	MB_ID_STD = 18
+	MB_ID_EXT = 0
+
+	// message buffer codes
+	CODE_RX_EMPTY    = 0b0100
+	CODE_RX_FULL     = 0b0010
+	CODE_RX_OVERRUN  = 0b0110
+	CODE_TX_INACTIVE = 0b1000
+	CODE_TX_DATA     = 0b1100
+
+	// message buffers 0-7 receive, 8 transmits
+	RX_MB = 8
+	TX_MB = 8
+
+	// maximum data length
+	MAX_DLC = 8
+
+	// CiA recommended sample point (per mille)
+	SAMPLE_POINT = 875
+)
+
+// EXTENDED flags identifiers as extended (29-bit) frame identifiers, in Send(),
+// Receive() and SetFilter().
+const EXTENDED = 1 << 31
+
+// Timeout is the maximum duration for controller mode changes and frame
+// transmission.
+var Timeout = 100 * time.Millisecond
+
+type flexcan struct {
+	sync.Mutex
+
+	// clock gates
+	cg  int
+	cgs int
+
+	base   uint32
+	mcr    *uint32
+	ctrl1  *uint32
+	timer  *uint32
+	mask   *uint32
+	imask1 *uint32
+	iflag1 *uint32
+}
+
+func newCAN(base uint32, cg int, cgs int) *flexcan {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &flexcan{
+		cg:     cg,
+		cgs:    cgs,
+		base:   base,
+		mcr:    r(CAN_MCR),
+		ctrl1:  r(CAN_CTRL1),
+		timer:  r(CAN_TIMER),
+		mask:   r(CAN_RXMGMASK),
+		imask1: r(CAN_IMASK1),
+		iflag1: r(CAN_IFLAG1),
+	}
+}
+
+var CAN1 = newCAN(CAN1_BASE, imx6.CCM_CCGR0_CAN1, imx6.CCM_CCGR0_CAN1_SERIAL)
+var CAN2 = newCAN(CAN2_BASE, imx6.CCM_CCGR0_CAN2, imx6.CCM_CCGR0_CAN2_SERIAL)
+
+// mb returns the argument message buffer words (CS, ID, DATA0, DATA1).
+func (hw *flexcan) mb(n int) (cs *uint32, id *uint32, data0 *uint32, data1 *uint32) {
+	addr := uintptr(hw.base + CAN_MB + uint32(n*CAN_MB_SIZE))
+
+	cs = (*uint32)(unsafe.Pointer(addr))
+	id = (*uint32)(unsafe.Pointer(addr + 4))
+	data0 = (*uint32)(unsafe.Pointer(addr + 8))
+	data1 = (*uint32)(unsafe.Pointer(addr + 12))
+
+	return
+}
+
+// bitTiming returns the CTRL1 timing fields for the argument source clock and
+// bitrate, choosing the highest number of time quanta (8 to 25) which
+// exactly divides the bitrate and the sample point closest to SAMPLE_POINT.
+func bitTiming(clk uint32, bitrate uint32) (presdiv, propseg, pseg1, pseg2, rjw uint32, err error) {
+	for tq := uint32(25); tq >= 8; tq-- {
+		if clk%(bitrate*tq) != 0 {
+			continue
+		}
+
+		presdiv = clk / (bitrate * tq)
+
+		if presdiv < 1 || presdiv > 256 {
+			continue
+		}
+
+		// time quanta before the sample point, excluding the sync
+		// segment
+		tseg1 := (tq*SAMPLE_POINT+500)/1000 - 1
+		tseg2 := tq - 1 - tseg1
+
+		if tseg2 < 2 {
+			tseg2 = 2
+			tseg1 = tq - 1 - tseg2
+		}
+
+		if tseg2 > 8 || tseg1 < 2 || tseg1 > 16 {
+			continue
+		}
+
+		// split between propagation and phase segment 1
+		prop := tseg1 / 2
+		ps1 := tseg1 - prop
+
+		if ps1 > 8 {
+			ps1 = 8
+			prop = tseg1 - ps1
+		}
+
+		rjw = tseg2
+
+		if rjw > 4 {
+			rjw = 4
+		}
+
+		return presdiv - 1, prop - 1, ps1 - 1, tseg2 - 1, rjw - 1, nil
+	}
+
+	err = errors.New("unsupported bitrate")
+
+	return
+}
+
+func (hw *flexcan) freeze(enable bool) (err error) {
+	if enable {
+		reg.Set(hw.mcr, CAN_MCR_FRZ)
+		reg.Set(hw.mcr, CAN_MCR_HALT)
+
+		if !reg.WaitFor(Timeout, hw.mcr, CAN_MCR_FRZACK, 0b1, 1) {
+			return errors.New("freeze timeout")
+		}
+
+		return
+	}
+
+	reg.Clear(hw.mcr, CAN_MCR_HALT)
+
+	if !reg.WaitFor(Timeout, hw.mcr, CAN_MCR_FRZACK, 0b1, 0) {
+		return errors.New("freeze exit timeout")
+	}
+
+	if !reg.WaitFor(Timeout, hw.mcr, CAN_MCR_NOTRDY, 0b1, 0) {
+		return errors.New("bus synchronization timeout")
+	}
+
+	return
+}
+
+// Init initializes the controller at the argument bitrate (in bits per
+// second), accepting all standard frames on half of the receive message
+// buffers and all extended frames on the other half (see SetFilter()).
+func (hw *flexcan) Init(bitrate int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if bitrate <= 0 {
+		return errors.New("invalid bitrate")
+	}
+
+	presdiv, propseg, pseg1, pseg2, rjw, err := bitTiming(imx6.OscFreq(), uint32(bitrate))
+
+	if err != nil {
+		return
+	}
+
+	if err = imx6.EnableClock(0, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	if err = imx6.EnableClock(0, hw.cgs, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	// select the oscillator clock, only allowed while disabled
+	reg.Set(hw.mcr, CAN_MCR_MDIS)
+	reg.Clear(hw.ctrl1, CAN_CTRL1_CLKSRC)
+	reg.Clear(hw.mcr, CAN_MCR_MDIS)
+
+	if !reg.WaitFor(Timeout, hw.mcr, CAN_MCR_LPMACK, 0b1, 0) {
+		return errors.New("enable timeout")
+	}
+
+	// soft reset, leaving the module in freeze mode
+	reg.Set(hw.mcr, CAN_MCR_SOFTRST)
+
+	if !reg.WaitFor(Timeout, hw.mcr, CAN_MCR_SOFTRST, 0b1, 0) {
+		return errors.New("reset timeout")
+	}
+
+	if err = hw.freeze(true); err != nil {
+		return
+	}
+
+	// disable self reception, last message buffer
+	reg.Set(hw.mcr, CAN_MCR_SRXDIS)
+	reg.SetN(hw.mcr, CAN_MCR_MAXMB, 0x7f, TX_MB)
+
+	reg.Write(hw.ctrl1, presdiv<<CAN_CTRL1_PRESDIV|
+		rjw<<CAN_CTRL1_RJW|
+		pseg1<<CAN_CTRL1_PSEG1|
+		pseg2<<CAN_CTRL1_PSEG2|
+		propseg<<CAN_CTRL1_PROPSEG)
+
+	// interrupts are not used, status flags are polled
+	reg.Write(hw.imask1, 0)
+	reg.Write(hw.iflag1, 0xffffffff)
+
+	// accept all frames
+	reg.Write(hw.mask, 0)
+
+	for n := 0; n < RX_MB; n++ {
+		cs, id, _, _ := hw.mb(n)
+		reg.Write(id, 0)
+
+		if n < RX_MB/2 {
+			reg.Write(cs, CODE_RX_EMPTY<<MB_CS_CODE)
+		} else {
+			reg.Write(cs, CODE_RX_EMPTY<<MB_CS_CODE|1<<MB_CS_IDE)
+		}
+	}
+
+	cs, _, _, _ := hw.mb(TX_MB)
+	reg.Write(cs, CODE_TX_INACTIVE<<MB_CS_CODE)
+
+	return hw.freeze(false)
+}
+
+// encodeID returns the message buffer ID word and IDE flag for the argument
+// identifier.
+func encodeID(id uint32) (word uint32, ide uint32) {
+	if id&EXTENDED != 0 {
+		return (id &^ EXTENDED) & 0x1fffffff << MB_ID_EXT, 1
+	}
+
+	return (id & 0x7ff) << MB_ID_STD, 0
+}
+
+// SetFilter configures all receive message buffers to accept only frames
+// whose identifier matches the argument identifier on all bits set in the
+// argument mask. The identifier type (standard or extended, see EXTENDED)
+// must match as well.
+func (hw *flexcan) SetFilter(id uint32, mask uint32) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	word, ide := encodeID(id)
+	maskWord, _ := encodeID(mask | id&EXTENDED)
+
+	if err = hw.freeze(true); err != nil {
+		return
+	}
+
+	reg.Write(hw.mask, maskWord)
+
+	for n := 0; n < RX_MB; n++ {
+		cs, idw, _, _ := hw.mb(n)
+
+		reg.Write(cs, 0)
+		reg.Write(idw, word)
+		reg.Write(cs, CODE_RX_EMPTY<<MB_CS_CODE|ide<<MB_CS_IDE)
+	}
+
+	reg.Write(hw.iflag1, 1<<RX_MB-1)
+
+	return hw.freeze(false)
+}
+
+// Send transmits a data frame, with a standard identifier or an extended one
+// (flagged with EXTENDED), waiting for its transmission.
+func (hw *flexcan) Send(id uint32, data []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if len(data) > MAX_DLC {
+		return errors.New("invalid data length")
+	}
+
+	var buf [MAX_DLC]byte
+	copy(buf[:], data)
+
+	word, ide := encodeID(id)
+	cs, idw, data0, data1 := hw.mb(TX_MB)
+
+	reg.Write(hw.iflag1, 1<<TX_MB)
+	reg.Write(cs, CODE_TX_INACTIVE<<MB_CS_CODE)
+
+	reg.Write(idw, word)
+	reg.Write(data0, uint32(buf[0])<<24|uint32(buf[1])<<16|uint32(buf[2])<<8|uint32(buf[3]))
+	reg.Write(data1, uint32(buf[4])<<24|uint32(buf[5])<<16|uint32(buf[6])<<8|uint32(buf[7]))
+
+	reg.Write(cs, CODE_TX_DATA<<MB_CS_CODE|
+		ide<<MB_CS_SRR|
+		ide<<MB_CS_IDE|
+		uint32(len(data))<<MB_CS_DLC)
+
+	if !reg.WaitFor(Timeout, hw.iflag1, TX_MB, 0b1, 1) {
+		// abort pending transmission
+		reg.Write(cs, CODE_TX_INACTIVE<<MB_CS_CODE)
+		return errors.New("transmission timeout")
+	}
+
+	reg.Write(hw.iflag1, 1<<TX_MB)
+
+	return
+}
+
+// Receive returns the oldest received frame, if any, its identifier is
+// flagged with EXTENDED for extended frames.
+func (hw *flexcan) Receive() (id uint32, data []byte, ok bool) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	flags := reg.Read(hw.iflag1) & (1<<RX_MB - 1)
+
+	if flags == 0 {
+		return
+	}
+
+	now := uint16(reg.Read(hw.timer))
+	oldest := -1
+	var age uint16
+
+	// select the oldest frame by its time stamp
+	for n := 0; n < RX_MB; n++ {
+		if flags&(1<<uint(n)) == 0 {
+			continue
+		}
+
+		cs, _, _, _ := hw.mb(n)
+
+		if a := now - uint16(reg.Read(cs)); oldest < 0 || a > age {
+			oldest = n
+			age = a
+		}
+	}
+
+	cs, idw, data0, data1 := hw.mb(oldest)
+
+	// reading the control and status word locks the message buffer
+	csw := reg.Read(cs)
+	code := (csw >> MB_CS_CODE) & 0b1111
+
+	if code == CODE_RX_FULL || code == CODE_RX_OVERRUN {
+		word := reg.Read(idw)
+
+		if (csw>>MB_CS_IDE)&1 == 1 {
+			id = (word>>MB_ID_EXT)&0x1fffffff | EXTENDED
+		} else {
+			id = (word >> MB_ID_STD) & 0x7ff
+		}
+
+		dlc := int((csw >> MB_CS_DLC) & 0b1111)
+
+		if dlc > MAX_DLC {
+			dlc = MAX_DLC
+		}
+
+		d0 := reg.Read(data0)
+		d1 := reg.Read(data1)
+		buf := []byte{
+			byte(d0 >> 24), byte(d0 >> 16), byte(d0 >> 8), byte(d0),
+			byte(d1 >> 24), byte(d1 >> 16), byte(d1 >> 8), byte(d1),
+		}
+
+		data = buf[0:dlc]
+		ok = true
+	}
+
+	// re-arm the message buffer
+	reg.Write(cs, CODE_RX_EMPTY<<MB_CS_CODE|csw&(1<<MB_CS_IDE))
+	reg.Write(hw.iflag1, 1<<uint(oldest))
+
+	// unlock the message buffer
+	reg.Read(hw.timer)
+
+	return
+}
@@ -0,0 +1,227 @@
+// ARM Generic Interrupt Controller (GIC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package gic implements a driver for the ARM Generic Interrupt Controller
+// (GICv2) integrated in the i.MX6UL Cortex-A7 core.
+//
+// The tamago runtime does not expose the ARM exception vectors, interrupts
+// are therefore dispatched by polling the CPU interface: a pending interrupt
+// is acknowledged (GICC_IAR), its handler executed and the interrupt
+// completed (GICC_EOIR), from ServiceInterrupt() or the goroutine started by
+// Start():
+//
+//	gic.Init()
+//	gic.SetHandler(id, handler)
+//	gic.EnableInterrupt(id, 0x80)
+//	gic.Start()
+package gic
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// GIC Memory Map, IMX6ULLRM (ARM peripherals base at 0x00a00000)
+const (
+	GICD_BASE uint32 = 0x00a01000
+	GICC_BASE uint32 = 0x00a02000
+
+	// Distributor register map (ARM IHI 0048B)
+	GICD_CTLR       = 0x000
+	GICD_TYPER      = 0x004
+	GICD_IGROUPR    = 0x080
+	GICD_ISENABLER  = 0x100
+	GICD_ICENABLER  = 0x180
+	GICD_ICPENDR    = 0x280
+	GICD_IPRIORITYR = 0x400
+	GICD_ITARGETSR  = 0x800
+	GICD_ICFGR      = 0xc00
+
+	GICD_CTLR_ENABLE = 0
+
+	// CPU interface register map (ARM IHI 0048B)
+	GICC_CTLR = 0x00
+	GICC_PMR  = 0x04
+	GICC_BPR  = 0x08
+	GICC_IAR  = 0x0c
+	GICC_EOIR = 0x10
+
+	GICC_CTLR_ENABLE = 0
+)
+
+// Interrupt ID ranges
+const (
+	// Private Peripheral Interrupts
+	PPI_BASE = 16
+	// Shared Peripheral Interrupts, i.MX6UL peripheral IRQ n has ID
+	// SPI_BASE + n
+	SPI_BASE = 32
+
+	// maximum interrupt ID
+	MAX_INTERRUPT = SPI_BASE + 128 - 1
+	// spurious interrupt ID
+	SPURIOUS = 1023
+)
+
+var (
+	mutex    sync.Mutex
+	handlers = make(map[int]func())
+	running  bool
+)
+
+func gicd(off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(GICD_BASE + off)))
+}
+
+func gicc(off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(GICC_BASE + off)))
+}
+
+// Init initializes the GIC distributor and CPU interface, all interrupts are
+// disabled and any priority level is allowed.
+func Init() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reg.Clear(gicd(GICD_CTLR), GICD_CTLR_ENABLE)
+
+	// disable and clear all PPIs and SPIs
+	for n := uint32(0); n <= MAX_INTERRUPT/32; n++ {
+		reg.Write(gicd(GICD_ICENABLER+4*n), 0xffffffff)
+		reg.Write(gicd(GICD_ICPENDR+4*n), 0xffffffff)
+	}
+
+	reg.Write(gicc(GICC_PMR), 0xff)
+	reg.Write(gicc(GICC_BPR), 0)
+
+	reg.Set(gicd(GICD_CTLR), GICD_CTLR_ENABLE)
+	reg.Set(gicc(GICC_CTLR), GICC_CTLR_ENABLE)
+}
+
+// Enabled returns whether both the GIC distributor and CPU interface are
+// enabled (see Init()), and therefore able to signal interrupts to the core.
+func Enabled() bool {
+	return reg.Get(gicd(GICD_CTLR), GICD_CTLR_ENABLE, 0b1) == 1 && reg.Get(gicc(GICC_CTLR), GICC_CTLR_ENABLE, 0b1) == 1
+}
+
+func check(id int) error {
+	if id < PPI_BASE || id > MAX_INTERRUPT {
+		return errors.New("invalid interrupt ID")
+	}
+
+	return nil
+}
+
+// EnableInterrupt enables a PPI or SPI, with the argument priority (lower
+// values have higher priority), targeting CPU 0.
+func EnableInterrupt(id int, prio uint8) (err error) {
+	if err = check(id); err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	n := uint32(id)
+
+	reg.Write8((*uint8)(unsafe.Pointer(uintptr(GICD_BASE+GICD_IPRIORITYR+n))), prio)
+
+	if id >= SPI_BASE {
+		reg.Write8((*uint8)(unsafe.Pointer(uintptr(GICD_BASE+GICD_ITARGETSR+n))), 0b1)
+	}
+
+	reg.Write(gicd(GICD_ISENABLER+4*(n/32)), 1<<(n%32))
+
+	return
+}
+
+// DisableInterrupt disables a PPI or SPI.
+func DisableInterrupt(id int) (err error) {
+	if err = check(id); err != nil {
+		return
+	}
+
+	n := uint32(id)
+	reg.Write(gicd(GICD_ICENABLER+4*(n/32)), 1<<(n%32))
+
+	return
+}
+
+// SetHandler registers the function executed when the argument interrupt is
+// serviced, a nil function removes the handler.
+func SetHandler(id int, fn func()) (err error) {
+	if err = check(id); err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if fn == nil {
+		delete(handlers, id)
+	} else {
+		handlers[id] = fn
+	}
+
+	return
+}
+
+// ServiceInterrupt acknowledges the highest priority pending interrupt, if
+// any, executes its handler and signals its completion. The serviced
+// interrupt ID is returned, SPURIOUS is returned when no interrupt is
+// pending.
+func ServiceInterrupt() (id int) {
+	iar := reg.Read(gicc(GICC_IAR))
+	id = int(iar & 0x3ff)
+
+	if id == SPURIOUS {
+		return
+	}
+
+	mutex.Lock()
+	fn := handlers[id]
+	mutex.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+
+	reg.Write(gicc(GICC_EOIR), iar)
+
+	return
+}
+
+// Start services pending interrupts from a polling goroutine, subsequent
+// calls have no effect.
+func Start() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if running {
+		return
+	}
+
+	running = true
+
+	go func() {
+		for {
+			if ServiceInterrupt() == SPURIOUS {
+				// tamago is single-threaded so we must force
+				// giving other goroutines a chance
+				runtime.Gosched()
+			}
+		}
+	}()
+}
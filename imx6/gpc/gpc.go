@@ -0,0 +1,232 @@
+// NXP General Power Controller (GPC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package gpc implements a driver for the NXP i.MX6 General Power Controller
+// (GPC), controlling the Power Gating Controllers (PGC) of the SoC power
+// domains and the interrupt masks used as wake-up sources from low power
+// modes:
+//
+//	// i.MX6Q: power gate the GPU/VPU domain
+//	gpc.PowerDown(gpc.DOMAIN_PU)
+//
+//	// power gate the ARM core in STOP mode
+//	gpc.SetCPUPowerDown(true)
+package gpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/gic"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// GPC Memory Map/Register Definition, IMX6ULLRM
+const (
+	GPC_BASE uint32 = 0x020dc000
+
+	GPC_CNTR = GPC_BASE + 0x00
+	// GPU/VPU power requests (i.MX6Q), IMX6DQRM
+	GPC_CNTR_GPU_VPU_PUP_REQ = 1
+	GPC_CNTR_GPU_VPU_PDN_REQ = 0
+
+	GPC_PGR  = GPC_BASE + 0x04
+	GPC_IMR1 = GPC_BASE + 0x08
+	GPC_ISR1 = GPC_BASE + 0x18
+
+	// PU (GPU/VPU) PGC (i.MX6Q), IMX6DQRM
+	PGC_GPU_CTRL   = GPC_BASE + 0x260
+	PGC_GPU_PUPSCR = GPC_BASE + 0x264
+	PGC_GPU_PDNSCR = GPC_BASE + 0x268
+
+	// ARM core PGC
+	PGC_CPU_CTRL   = GPC_BASE + 0x2a0
+	PGC_CPU_PUPSCR = GPC_BASE + 0x2a4
+	PGC_CPU_PDNSCR = GPC_BASE + 0x2a8
+
+	PGC_CTRL_PCR = 0
+
+	PGC_PUPSCR_SW2ISO = 8
+	PGC_PUPSCR_SW     = 0
+	PGC_PDNSCR_ISO2SW = 8
+	PGC_PDNSCR_ISO    = 0
+
+	// PGC power switch and isolation timings, in IPG clock cycles
+	// (reset values)
+	PGC_SW2ISO = 0xf
+	PGC_SW     = 0x1
+	PGC_ISO2SW = 0x1
+	PGC_ISO    = 0x1
+
+	// GPC_IMR1-4 cover shared peripheral interrupts 0-127
+	IMR_COUNT = 4
+)
+
+// Power domains
+const (
+	// GPU/VPU domain (i.MX6Q only)
+	DOMAIN_PU = iota
+)
+
+// Timeout is the maximum duration for power domain transitions.
+var Timeout = 10 * time.Millisecond
+
+var mutex sync.Mutex
+
+var cntr = reg.New(GPC_CNTR)
+
+type domain struct {
+	pup  int
+	pdn  int
+	ctrl *reg.Register
+	pscr *reg.Register
+	dscr *reg.Register
+}
+
+func lookup(d int) (dom *domain, err error) {
+	switch {
+	case d == DOMAIN_PU && imx6.Family == imx6.IMX6Q:
+		return &domain{
+			pup:  GPC_CNTR_GPU_VPU_PUP_REQ,
+			pdn:  GPC_CNTR_GPU_VPU_PDN_REQ,
+			ctrl: reg.New(PGC_GPU_CTRL),
+			pscr: reg.New(PGC_GPU_PUPSCR),
+			dscr: reg.New(PGC_GPU_PDNSCR),
+		}, nil
+	default:
+		return nil, errors.New("power domain not available")
+	}
+}
+
+// PowerDown power gates the argument domain, its peripherals must be idle
+// and their clocks gated beforehand. An error is returned if the domain is
+// not available on the SoC family.
+func PowerDown(d int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	dom, err := lookup(d)
+
+	if err != nil {
+		return
+	}
+
+	dom.dscr.SetN(PGC_PDNSCR_ISO2SW, 0x3f, PGC_ISO2SW)
+	dom.dscr.SetN(PGC_PDNSCR_ISO, 0x3f, PGC_ISO)
+
+	// switch off the domain on power down requests
+	dom.ctrl.Set(PGC_CTRL_PCR)
+
+	cntr.Set(dom.pdn)
+
+	if !cntr.WaitFor(Timeout, dom.pdn, 0b1, 0) {
+		return errors.New("power down timeout")
+	}
+
+	return
+}
+
+// PowerUp restores power to the argument domain, its supply must be enabled
+// beforehand (e.g. the i.MX6Q VDDPU regulator). An error is returned if the
+// domain is not available on the SoC family.
+func PowerUp(d int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	dom, err := lookup(d)
+
+	if err != nil {
+		return
+	}
+
+	dom.pscr.SetN(PGC_PUPSCR_SW2ISO, 0x3f, PGC_SW2ISO)
+	dom.pscr.SetN(PGC_PUPSCR_SW, 0x3f, PGC_SW)
+
+	cntr.Set(dom.pup)
+
+	if !cntr.WaitFor(Timeout, dom.pup, 0b1, 0) {
+		return errors.New("power up timeout")
+	}
+
+	dom.ctrl.Clear(PGC_CTRL_PCR)
+
+	return
+}
+
+// SetCPUPowerDown configures whether the ARM core power domain is switched
+// off while in STOP mode (see imx6.LPM_STOP), the core state is lost and
+// execution resumes from the reset vector (ROM) on wake-up.
+func SetCPUPowerDown(enable bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	ctrl := reg.New(PGC_CPU_CTRL)
+	pscr := reg.New(PGC_CPU_PUPSCR)
+	dscr := reg.New(PGC_CPU_PDNSCR)
+
+	if !enable {
+		ctrl.Clear(PGC_CTRL_PCR)
+		return
+	}
+
+	pscr.SetN(PGC_PUPSCR_SW2ISO, 0x3f, PGC_SW2ISO)
+	pscr.SetN(PGC_PUPSCR_SW, 0x3f, PGC_SW)
+	dscr.SetN(PGC_PDNSCR_ISO2SW, 0x3f, PGC_ISO2SW)
+	dscr.SetN(PGC_PDNSCR_ISO, 0x3f, PGC_ISO)
+
+	ctrl.Set(PGC_CTRL_PCR)
+}
+
+func imr(id int) (r *reg.Register, pos int, err error) {
+	n := id - gic.SPI_BASE
+
+	if n < 0 || n >= IMR_COUNT*32 {
+		return nil, 0, errors.New("invalid interrupt")
+	}
+
+	return reg.New(GPC_IMR1 + uint32(4*(n/32))), n % 32, nil
+}
+
+// Unmask enables the argument shared peripheral interrupt (GIC interrupt ID)
+// as GPC wake-up source from low power modes.
+func Unmask(id int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, pos, err := imr(id)
+
+	if err != nil {
+		return
+	}
+
+	r.Clear(pos)
+
+	return
+}
+
+// Mask disables the argument shared peripheral interrupt (GIC interrupt ID)
+// as GPC wake-up source from low power modes.
+func Mask(id int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, pos, err := imr(id)
+
+	if err != nil {
+		return
+	}
+
+	r.Set(pos)
+
+	return
+}
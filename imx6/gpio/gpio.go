@@ -0,0 +1,298 @@
+// NXP General Purpose Input/Output (GPIO) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package gpio implements a driver for the NXP i.MX6 General Purpose
+// Input/Output (GPIO) controllers.
+//
+// The pad associated to each GPIO must be configured in GPIO mode (see package
+// iomuxc) before use, for example to drive an LED:
+//
+//	led, _ := gpio.Init(4, 21)
+//	led.Out()
+//	led.Set()
+package gpio
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// GPIO Memory Map/Register Definition, IMX6ULLRM
+const (
+	// base addresses, defined in package imx6 (see imx6.Peripherals)
+	GPIO1_BASE = imx6.GPIO1_BASE
+	GPIO2_BASE = imx6.GPIO2_BASE
+	GPIO3_BASE = imx6.GPIO3_BASE
+	GPIO4_BASE = imx6.GPIO4_BASE
+	GPIO5_BASE = imx6.GPIO5_BASE
+
+	GPIO_DR       = 0x00
+	GPIO_GDIR     = 0x04
+	GPIO_PSR      = 0x08
+	GPIO_ICR1     = 0x0c
+	GPIO_ICR2     = 0x10
+	GPIO_IMR      = 0x14
+	GPIO_ISR      = 0x18
+	GPIO_EDGE_SEL = 0x1c
+)
+
+// Interrupt trigger conditions (GPIO_ICR fields).
+const (
+	LOW_LEVEL    = 0b00
+	HIGH_LEVEL   = 0b01
+	RISING_EDGE  = 0b10
+	FALLING_EDGE = 0b11
+	// any edge, configured through GPIO_EDGE_SEL
+	ANY_EDGE = 0b100
+)
+
+// Pin represents a single GPIO line.
+type Pin struct {
+	num int
+
+	dr   *uint32
+	gdir *uint32
+	psr  *uint32
+	icr  *uint32
+	imr  *uint32
+	isr  *uint32
+	edge *uint32
+
+	// interrupt request identifier
+	irq uint64
+}
+
+// GPIO registers are shared among all pins of a bank, read-modify-write
+// operations are serialized across all pins.
+var mutex sync.Mutex
+
+var gates = []struct {
+	ccgr int
+	pos  int
+}{
+	{1, imx6.CCM_CCGR1_GPIO1},
+	{0, imx6.CCM_CCGR0_GPIO2},
+	{2, imx6.CCM_CCGR2_GPIO3},
+	{3, imx6.CCM_CCGR3_GPIO4},
+	{1, imx6.CCM_CCGR1_GPIO5},
+}
+
+func bankBase(bank int) (base uint32, err error) {
+	switch bank {
+	case 1:
+		base = imx6.Peripherals.GPIO1Base
+	case 2:
+		base = imx6.Peripherals.GPIO2Base
+	case 3:
+		base = imx6.Peripherals.GPIO3Base
+	case 4:
+		base = imx6.Peripherals.GPIO4Base
+	case 5:
+		base = imx6.Peripherals.GPIO5Base
+	default:
+		err = fmt.Errorf("invalid GPIO bank %d", bank)
+	}
+
+	return
+}
+
+func register(addr uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(addr)))
+}
+
+// Init returns the GPIO instance for the argument bank (1-5) and pin number
+// (0-31), enabling the bank clock.
+func Init(bank int, num int) (gpio *Pin, err error) {
+	base, err := bankBase(bank)
+
+	if err != nil {
+		return
+	}
+
+	if num < 0 || num > 31 {
+		return nil, fmt.Errorf("invalid GPIO number %d", num)
+	}
+
+	gate := gates[bank-1]
+
+	if err = imx6.EnableClock(gate.ccgr, gate.pos, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	gpio = &Pin{
+		num:  num,
+		dr:   register(base + GPIO_DR),
+		gdir: register(base + GPIO_GDIR),
+		psr:  register(base + GPIO_PSR),
+		imr:  register(base + GPIO_IMR),
+		isr:  register(base + GPIO_ISR),
+		edge: register(base + GPIO_EDGE_SEL),
+	}
+
+	if num < 16 {
+		gpio.icr = register(base + GPIO_ICR1)
+	} else {
+		gpio.icr = register(base + GPIO_ICR2)
+	}
+
+	return
+}
+
+// Out configures the GPIO as output.
+func (gpio *Pin) Out() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	imx6.WithInterruptsDisabled(func() {
+		reg.Set(gpio.gdir, gpio.num)
+	})
+}
+
+// In configures the GPIO as input.
+func (gpio *Pin) In() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	imx6.WithInterruptsDisabled(func() {
+		reg.Clear(gpio.gdir, gpio.num)
+	})
+}
+
+// Set drives the GPIO output high.
+func (gpio *Pin) Set() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	imx6.WithInterruptsDisabled(func() {
+		reg.Set(gpio.dr, gpio.num)
+	})
+}
+
+// Clear drives the GPIO output low.
+func (gpio *Pin) Clear() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	imx6.WithInterruptsDisabled(func() {
+		reg.Clear(gpio.dr, gpio.num)
+	})
+}
+
+// Toggle inverts the GPIO output level.
+func (gpio *Pin) Toggle() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	imx6.WithInterruptsDisabled(func() {
+		reg.Toggle(gpio.dr, gpio.num)
+	})
+}
+
+// Value returns the GPIO output (data register) value.
+func (gpio *Pin) Value() (high bool) {
+	return reg.Get(gpio.dr, gpio.num, 0b1) == 1
+}
+
+// Get returns the GPIO pad status, reflecting the input line level.
+func (gpio *Pin) Get() (high bool) {
+	return reg.Get(gpio.psr, gpio.num, 0b1) == 1
+}
+
+// Status returns whether an interrupt condition has been detected on the GPIO
+// (GPIO_ISR).
+func (gpio *Pin) Status() bool {
+	return reg.Get(gpio.isr, gpio.num, 0b1) == 1
+}
+
+// ClearStatus clears any detected interrupt condition on the GPIO.
+func (gpio *Pin) ClearStatus() {
+	// write 1 to clear, other pins are unaffected
+	reg.Write(gpio.isr, 1<<uint(gpio.num))
+}
+
+// Trigger configures the interrupt condition (LOW_LEVEL, HIGH_LEVEL,
+// RISING_EDGE, FALLING_EDGE or ANY_EDGE) detected on the GPIO.
+func (gpio *Pin) Trigger(cond int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	switch cond {
+	case LOW_LEVEL, HIGH_LEVEL, RISING_EDGE, FALLING_EDGE:
+		reg.Clear(gpio.edge, gpio.num)
+		reg.SetN(gpio.icr, (gpio.num%16)*2, 0b11, uint32(cond))
+	case ANY_EDGE:
+		reg.Set(gpio.edge, gpio.num)
+	default:
+		return errors.New("invalid interrupt condition")
+	}
+
+	return
+}
+
+// EnableInterrupt unmasks the GPIO interrupt and executes the argument
+// function each time the interrupt condition, set with Trigger(), is
+// detected. Level triggered conditions re-execute the function as long as the
+// level is held.
+//
+// The function is invoked from a goroutine polling the interrupt status,
+// which is cleared before each invocation.
+func (gpio *Pin) EnableInterrupt(fn func()) {
+	mutex.Lock()
+	gpio.irq += 1
+	id := gpio.irq
+
+	gpio.ClearStatus()
+	reg.Set(gpio.imr, gpio.num)
+	mutex.Unlock()
+
+	go func() {
+		for {
+			// tamago is single-threaded so we must force giving
+			// other goroutines a chance
+			runtime.Gosched()
+
+			mutex.Lock()
+
+			if gpio.irq != id {
+				mutex.Unlock()
+				return
+			}
+
+			pending := gpio.Status()
+
+			if pending {
+				gpio.ClearStatus()
+			}
+
+			mutex.Unlock()
+
+			if pending {
+				fn()
+			}
+		}
+	}()
+}
+
+// DisableInterrupt masks the GPIO interrupt, cancelling any function set with
+// EnableInterrupt().
+func (gpio *Pin) DisableInterrupt() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	gpio.irq += 1
+	reg.Clear(gpio.imr, gpio.num)
+}
@@ -0,0 +1,375 @@
+// NXP General Purpose Timer (GPT) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package gpt implements a driver for the NXP i.MX6 General Purpose Timer
+// (GPT), clocked from the 24 MHz crystal oscillator and therefore independent
+// from ARM core frequency changes.
+//
+// The GPT can be used as runtime monotonic clock source, at early
+// initialization, as follows:
+//
+//	func init() {
+//		if err := gpt.GPT1.Init(); err != nil {
+//			panic(err)
+//		}
+//
+//		gpt.GPT1.InstallTimer()
+//	}
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// GPT Memory Map/Register Definition, IMX6ULLRM
+	GPT1_BASE uint32 = 0x02098000
+
+	GPT_CR         = 0x00
+	GPT_CR_FO1     = 29
+	GPT_CR_IM2     = 18
+	GPT_CR_IM1     = 16
+	GPT_CR_SWR     = 15
+	GPT_CR_EN_24M  = 10
+	GPT_CR_FRR     = 9
+	GPT_CR_CLKSRC  = 6
+	GPT_CR_STOPEN  = 5
+	GPT_CR_DOZEEN  = 4
+	GPT_CR_WAITEN  = 3
+	GPT_CR_DBGEN   = 2
+	GPT_CR_ENMOD   = 1
+	GPT_CR_EN      = 0
+	CLKSRC_CRYSTAL = 0b101
+
+	GPT_PR              = 0x04
+	GPT_PR_PRESCALER24M = 12
+	GPT_PR_PRESCALER    = 0
+
+	GPT_SR     = 0x08
+	GPT_SR_ROV = 5
+	GPT_SR_IF2 = 4
+	GPT_SR_IF1 = 3
+	GPT_SR_OF1 = 0
+
+	GPT_IR   = 0x0c
+	GPT_OCR1 = 0x10
+	GPT_ICR1 = 0x1c
+	GPT_ICR2 = 0x20
+	GPT_CNT  = 0x24
+
+	// 24 MHz / (11 + 1) / (1 + 1) = 1 MHz
+	PRESCALER24M = 11
+	PRESCALER    = 1
+
+	// counter frequency
+	TICK_FREQ = 1000000
+)
+
+// Input capture operating modes (GPT_CR IM1/IM2 fields)
+const (
+	CAPTURE_DISABLED = 0b00
+	CAPTURE_RISING   = 0b01
+	CAPTURE_FALLING  = 0b10
+	CAPTURE_BOTH     = 0b11
+)
+
+type gpt struct {
+	sync.Mutex
+
+	cr   *uint32
+	pr   *uint32
+	sr   *uint32
+	ir   *uint32
+	ocr1 *uint32
+	icr1 *uint32
+	icr2 *uint32
+	cnt  *uint32
+
+	// counter overflow tracking
+	last uint32
+	high uint64
+
+	// output compare request identifier
+	compare uint64
+	// input capture request identifiers
+	capture [2]uint64
+}
+
+var GPT1 = &gpt{
+	cr:   (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_CR))),
+	pr:   (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_PR))),
+	sr:   (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_SR))),
+	ir:   (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_IR))),
+	ocr1: (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_OCR1))),
+	icr1: (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_ICR1))),
+	icr2: (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_ICR2))),
+	cnt:  (*uint32)(unsafe.Pointer(uintptr(GPT1_BASE + GPT_CNT))),
+}
+
+// prescalers returns the PRESCALER24M and PRESCALER register values which
+// divide the argument oscillator frequency to exactly 1 MHz.
+func prescalers(osc uint32) (pre24M uint32, pre uint32, err error) {
+	if osc%TICK_FREQ != 0 {
+		return 0, 0, fmt.Errorf("oscillator frequency (%d Hz) cannot be divided to 1 MHz", osc)
+	}
+
+	div := osc / TICK_FREQ
+
+	// GPT Prescaler Register (GPT_PR), IMX6ULLRM
+	// PRESCALER24M: divide by 1-16, PRESCALER: divide by 1-4096
+	for d := uint32(16); d > 0; d-- {
+		if div%d == 0 && div/d <= 4096 {
+			return d - 1, div/d - 1, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("oscillator frequency (%d Hz) cannot be divided to 1 MHz", osc)
+}
+
+// Init initializes the GPT as a free-running 1 MHz counter, an error is
+// returned if the main crystal oscillator frequency (see imx6.OscFreq())
+// cannot be divided exactly to 1 MHz (e.g. 19.2 MHz), as the counter would
+// otherwise not track microseconds.
+func (hw *gpt) Init() (err error) {
+	pre24M, pre, err := prescalers(imx6.OscFreq())
+
+	if err != nil {
+		return
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	// enable clocks
+	imx6.EnableClock(1, imx6.CCM_CCGR1_GPT1_BUS, imx6.CCGR_ON)
+	imx6.EnableClock(1, imx6.CCM_CCGR1_GPT1_SERIAL, imx6.CCGR_ON)
+
+	// Clock Source Selection, General Purpose Timer (GPT), IMX6ULLRM
+
+	// disable GPT and its interrupts
+	reg.Clear(hw.cr, GPT_CR_EN)
+	reg.Write(hw.ir, 0)
+
+	// soft reset
+	reg.Set(hw.cr, GPT_CR_SWR)
+	reg.Wait(hw.cr, GPT_CR_SWR, 0b1, 0)
+
+	// clear status register
+	reg.Write(hw.sr, 0b111111)
+
+	// PRESCALER24M and PRESCALER for the standard 24 MHz oscillator,
+	// otherwise derived from its actual frequency (see imx6.SetOscFreq())
+	reg.SetN(hw.pr, GPT_PR_PRESCALER24M, 0b1111, pre24M)
+	reg.SetN(hw.pr, GPT_PR_PRESCALER, 0xfff, pre)
+
+	// select crystal oscillator in free-run mode, resetting the counter
+	// on enable
+	reg.SetN(hw.cr, GPT_CR_CLKSRC, 0b111, CLKSRC_CRYSTAL)
+	reg.Set(hw.cr, GPT_CR_EN_24M)
+	reg.Set(hw.cr, GPT_CR_FRR)
+	reg.Set(hw.cr, GPT_CR_ENMOD)
+
+	hw.last = 0
+	hw.high = 0
+
+	reg.Set(hw.cr, GPT_CR_EN)
+
+	return
+}
+
+// Now returns the number of microseconds elapsed since initialization.
+//
+// The 32-bit counter rollover (every ~71 minutes) is tracked in software,
+// therefore Now must be invoked at least once per rollover period.
+func (hw *gpt) Now() (us uint64) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.now()
+}
+
+func (hw *gpt) now() uint64 {
+	cnt := *hw.cnt
+
+	if cnt < hw.last {
+		hw.high += 1 << 32
+	}
+
+	hw.last = cnt
+
+	return hw.high | uint64(cnt)
+}
+
+// InstallTimer sets the GPT counter as runtime monotonic clock source, to be
+// used by time.Now(), time.Sleep(), etc., ensuring that timing is preserved
+// across ARM core frequency changes. The GPT must be initialized with Init()
+// before calling this function.
+func (hw *gpt) InstallTimer() {
+	imx6.SetTimer(hw.timer, TICK_FREQ)
+}
+
+// timer is invoked by the runtime, it must not block (therefore hw.Mutex is
+// not used, as goroutine switching cannot occur within hw.now()).
+func (hw *gpt) timer() int64 {
+	return int64(hw.now())
+}
+
+// Sleep waits for at least the argument duration, measured with the GPT
+// counter.
+func (hw *gpt) Sleep(d time.Duration) {
+	end := hw.Now() + uint64(d/time.Microsecond)
+
+	for hw.Now() < end {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+}
+
+// SetCompare configures a one-shot output compare event, at the argument
+// counter value (in microseconds, see Now()), which executes the argument
+// function. Any previously configured compare event is cancelled.
+//
+// The function is invoked from a goroutine polling the compare status flag.
+func (hw *gpt) SetCompare(us uint64, fn func()) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	now := hw.now()
+
+	if us <= now {
+		return errors.New("compare value already elapsed")
+	}
+
+	if us-now > 0xffffffff {
+		return errors.New("compare value exceeds counter range")
+	}
+
+	hw.compare += 1
+	id := hw.compare
+
+	// clear any pending event before arming
+	reg.Write(hw.sr, 1<<GPT_SR_OF1)
+	reg.Write(hw.ocr1, uint32(us))
+
+	go func() {
+		for {
+			runtime.Gosched()
+
+			hw.Lock()
+
+			if hw.compare != id {
+				hw.Unlock()
+				return
+			}
+
+			if reg.Get(hw.sr, GPT_SR_OF1, 0b1) == 1 {
+				reg.Write(hw.sr, 1<<GPT_SR_OF1)
+				hw.compare += 1
+				hw.Unlock()
+
+				fn()
+				return
+			}
+
+			hw.Unlock()
+		}
+	}()
+
+	return
+}
+
+// SetCapture configures input capture on the argument channel (1 or 2), which
+// latches the counter on the selected edges (CAPTURE_RISING, CAPTURE_FALLING
+// or CAPTURE_BOTH) of the corresponding GPT1_CAPTUREx input, executing the
+// argument function with each captured value (in microseconds, see Now()).
+// Any previously configured capture on the channel is cancelled, the
+// CAPTURE_DISABLED mode only cancels it.
+//
+// The GPT1_CAPTUREx pad must be configured (see package iomuxc) before use.
+// The 32-bit captured value is extended to the 64-bit counter, consistently
+// with Now(), therefore events must be serviced within one counter rollover
+// period (~71 minutes), which is the case as long as goroutines are
+// scheduled.
+//
+// The function is invoked from a goroutine polling the capture status flag,
+// edges occurring before it is serviced overwrite the previous capture.
+func (hw *gpt) SetCapture(channel int, mode int, fn func(us uint64)) (err error) {
+	var im int
+	var flag int
+	var icr *uint32
+
+	switch channel {
+	case 1:
+		im, flag, icr = GPT_CR_IM1, GPT_SR_IF1, hw.icr1
+	case 2:
+		im, flag, icr = GPT_CR_IM2, GPT_SR_IF2, hw.icr2
+	default:
+		return errors.New("invalid capture channel")
+	}
+
+	if mode < CAPTURE_DISABLED || mode > CAPTURE_BOTH {
+		return errors.New("invalid capture mode")
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.capture[channel-1] += 1
+	id := hw.capture[channel-1]
+
+	reg.SetN(hw.cr, im, 0b11, uint32(mode))
+
+	// clear any pending event before arming
+	reg.Write(hw.sr, 1<<uint(flag))
+
+	if mode == CAPTURE_DISABLED {
+		return
+	}
+
+	go func() {
+		for {
+			runtime.Gosched()
+
+			hw.Lock()
+
+			if hw.capture[channel-1] != id {
+				hw.Unlock()
+				return
+			}
+
+			if reg.Get(hw.sr, flag, 0b1) == 0 {
+				hw.Unlock()
+				continue
+			}
+
+			val := reg.Read(icr)
+			reg.Write(hw.sr, 1<<uint(flag))
+
+			now := hw.now()
+			us := now - uint64(uint32(now)-val)
+
+			hw.Unlock()
+
+			fn(us)
+		}
+	}()
+
+	return
+}
@@ -0,0 +1,279 @@
+// GPIO based I2C driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package i2c
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/gpio"
+)
+
+// BitBang represents a software I2C master, driving two GPIO lines.
+type BitBang struct {
+	sync.Mutex
+
+	scl *gpio.Pin
+	sda *gpio.Pin
+
+	// half clock period (us)
+	delay uint32
+}
+
+// NewBitBang returns a software I2C master, with the same Read/Write
+// interface of the I2C controller driver, operating the argument GPIO lines
+// at the argument bus speed (in Hz).
+//
+// The lines are driven as open-drain: a low level is asserted by configuring
+// the GPIO as output, while a high level is obtained by configuring it as
+// input and relying on the bus pull-up resistors. The pads must therefore be
+// configured, as GPIO, with pull-up (either external or internal, see package
+// iomuxc) before use.
+//
+// Bus timing is obtained with imx6.DelayUS(), resulting in a bus speed lower
+// than or equal to the requested one, devices holding SCL low (clock
+// stretching) are waited for up to Timeout.
+func NewBitBang(scl *gpio.Pin, sda *gpio.Pin, speed int) (hw *BitBang, err error) {
+	if scl == nil || sda == nil {
+		return nil, errors.New("invalid GPIO")
+	}
+
+	if speed <= 0 || speed > 500000 {
+		return nil, errors.New("invalid speed")
+	}
+
+	hw = &BitBang{
+		scl:   scl,
+		sda:   sda,
+		delay: uint32((500000 + speed - 1) / speed),
+	}
+
+	// release the bus
+	hw.scl.Clear()
+	hw.sda.Clear()
+	hw.scl.In()
+	hw.sda.In()
+
+	return
+}
+
+func (hw *BitBang) wait() {
+	imx6.DelayUS(hw.delay)
+}
+
+func (hw *BitBang) low(pin *gpio.Pin) {
+	pin.Clear()
+	pin.Out()
+}
+
+func (hw *BitBang) release(pin *gpio.Pin) {
+	pin.In()
+}
+
+// releaseSCL releases the clock line and waits for any device holding it low
+// (clock stretching).
+func (hw *BitBang) releaseSCL() (err error) {
+	hw.release(hw.scl)
+
+	start := time.Now()
+
+	for !hw.scl.Get() {
+		if time.Since(start) > Timeout {
+			return errors.New("timeout")
+		}
+	}
+
+	return
+}
+
+func (hw *BitBang) start(repeated bool) (err error) {
+	if repeated {
+		hw.release(hw.sda)
+		hw.wait()
+
+		if err = hw.releaseSCL(); err != nil {
+			return
+		}
+
+		hw.wait()
+	} else if !hw.scl.Get() || !hw.sda.Get() {
+		return errors.New("bus busy")
+	}
+
+	// SDA falling edge while SCL is high
+	hw.low(hw.sda)
+	hw.wait()
+	hw.low(hw.scl)
+
+	return
+}
+
+func (hw *BitBang) stop() {
+	hw.low(hw.sda)
+	hw.wait()
+
+	hw.releaseSCL()
+	hw.wait()
+
+	// SDA rising edge while SCL is high
+	hw.release(hw.sda)
+	hw.wait()
+}
+
+func (hw *BitBang) writeBit(bit bool) (err error) {
+	if bit {
+		hw.release(hw.sda)
+	} else {
+		hw.low(hw.sda)
+	}
+
+	hw.wait()
+
+	if err = hw.releaseSCL(); err != nil {
+		return
+	}
+
+	hw.wait()
+	hw.low(hw.scl)
+
+	return
+}
+
+func (hw *BitBang) readBit() (bit bool, err error) {
+	hw.release(hw.sda)
+	hw.wait()
+
+	if err = hw.releaseSCL(); err != nil {
+		return
+	}
+
+	hw.wait()
+	bit = hw.sda.Get()
+	hw.low(hw.scl)
+
+	return
+}
+
+func (hw *BitBang) tx(b byte) (err error) {
+	for i := 7; i >= 0; i-- {
+		if err = hw.writeBit(b&(1<<uint(i)) != 0); err != nil {
+			return
+		}
+	}
+
+	nack, err := hw.readBit()
+
+	if err != nil {
+		return
+	}
+
+	if nack {
+		return errors.New("no acknowledge")
+	}
+
+	return
+}
+
+func (hw *BitBang) rx(buf []byte) (err error) {
+	for i := range buf {
+		var b byte
+
+		for j := 0; j < 8; j++ {
+			bit, err := hw.readBit()
+
+			if err != nil {
+				return err
+			}
+
+			b <<= 1
+
+			if bit {
+				b |= 1
+			}
+		}
+
+		buf[i] = b
+
+		// do not acknowledge the last byte
+		if err = hw.writeBit(i == len(buf)-1); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Read reads a sequence of bytes, starting from the argument register
+// address, from the target I2C (7-bit) address.
+func (hw *BitBang) Read(addr uint8, r uint8, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if len(buf) == 0 {
+		return
+	}
+
+	defer hw.stop()
+
+	if err = hw.start(false); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr << 1); err != nil {
+		return
+	}
+
+	if err = hw.tx(r); err != nil {
+		return
+	}
+
+	if err = hw.start(true); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr<<1 | 1); err != nil {
+		return
+	}
+
+	return hw.rx(buf)
+}
+
+// Write writes a sequence of bytes, starting from the argument register
+// address, to the target I2C (7-bit) address.
+func (hw *BitBang) Write(addr uint8, r uint8, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	defer hw.stop()
+
+	if err = hw.start(false); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr << 1); err != nil {
+		return
+	}
+
+	if err = hw.tx(r); err != nil {
+		return
+	}
+
+	for _, b := range buf {
+		if err = hw.tx(b); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,346 @@
+// NXP I2C Controller (I2C) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package i2c implements a master mode driver for the NXP i.MX6 I2C
+// controllers.
+//
+// The I2C pads must be configured (see package iomuxc) before use, for
+// example to read a register from a PMIC:
+//
+//	i2c.I2C1.Init(100000)
+//	buf := make([]byte, 1)
+//	err := i2c.I2C1.Read(0x08, 0x00, buf)
+package i2c
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// I2C Memory Map/Register Definition, IMX6ULLRM
+const (
+	// base addresses, defined in package imx6 (see imx6.Peripherals)
+	I2C1_BASE = imx6.I2C1_BASE
+	I2C2_BASE = imx6.I2C2_BASE
+	I2C3_BASE = imx6.I2C3_BASE
+
+	I2C_IADR = 0x00
+	I2C_IFDR = 0x04
+
+	I2C_I2CR      = 0x08
+	I2C_I2CR_IEN  = 7
+	I2C_I2CR_IIEN = 6
+	I2C_I2CR_MSTA = 5
+	I2C_I2CR_MTX  = 4
+	I2C_I2CR_TXAK = 3
+	I2C_I2CR_RSTA = 2
+
+	I2C_I2SR      = 0x0c
+	I2C_I2SR_ICF  = 7
+	I2C_I2SR_IAAS = 6
+	I2C_I2SR_IBB  = 5
+	I2C_I2SR_IAL  = 4
+	I2C_I2SR_SRW  = 2
+	I2C_I2SR_IIF  = 1
+	I2C_I2SR_RXAK = 0
+
+	I2C_I2DR = 0x10
+)
+
+// Timeout is the maximum duration for bus state changes and byte transfers.
+var Timeout = 10 * time.Millisecond
+
+// Bus represents an I2C master, implemented by both the I2C controller
+// driver (I2C1, I2C2, I2C3) and the software one (NewBitBang()), allowing
+// device drivers to be agnostic of the bus implementation.
+type Bus interface {
+	// Read reads a sequence of bytes, starting from the argument register
+	// address, from the target I2C (7-bit) address.
+	Read(addr uint8, r uint8, buf []byte) error
+	// Write writes a sequence of bytes, starting from the argument
+	// register address, to the target I2C (7-bit) address.
+	Write(addr uint8, r uint8, buf []byte) error
+}
+
+var _ Bus = (*i2c)(nil)
+var _ Bus = (*BitBang)(nil)
+
+// Clock divider values and their IFDR encoding
+// (Table "I2C_IFDR Register Field Values", IMX6ULLRM).
+var dividers = [...]struct {
+	div uint32
+	val uint16
+}{
+	{22, 0x20}, {24, 0x21}, {26, 0x22}, {28, 0x23}, {30, 0x00}, {32, 0x24},
+	{36, 0x25}, {40, 0x26}, {42, 0x03}, {44, 0x27}, {48, 0x28}, {52, 0x05},
+	{56, 0x29}, {60, 0x06}, {64, 0x2a}, {72, 0x2b}, {80, 0x2c}, {88, 0x09},
+	{96, 0x2d}, {104, 0x0a}, {112, 0x2e}, {128, 0x2f}, {144, 0x0c}, {160, 0x30},
+	{192, 0x31}, {224, 0x32}, {240, 0x0f}, {256, 0x33}, {288, 0x10}, {320, 0x34},
+	{384, 0x35}, {448, 0x36}, {480, 0x13}, {512, 0x37}, {576, 0x14}, {640, 0x38},
+	{768, 0x39}, {896, 0x3a}, {960, 0x17}, {1024, 0x3b}, {1152, 0x18}, {1280, 0x3c},
+	{1536, 0x3d}, {1792, 0x3e}, {1920, 0x1b}, {2048, 0x3f}, {2304, 0x1c}, {2560, 0x1d},
+	{3072, 0x1e}, {3840, 0x1f},
+}
+
+type i2c struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	iadr *uint16
+	ifdr *uint16
+	i2cr *uint16
+	i2sr *uint16
+	i2dr *uint16
+}
+
+func newI2C(base uint32, cg int) *i2c {
+	r := func(off uint32) *uint16 {
+		return (*uint16)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &i2c{
+		cg:   cg,
+		iadr: r(I2C_IADR),
+		ifdr: r(I2C_IFDR),
+		i2cr: r(I2C_I2CR),
+		i2sr: r(I2C_I2SR),
+		i2dr: r(I2C_I2DR),
+	}
+}
+
+var I2C1 = newI2C(imx6.Peripherals.I2C1Base, imx6.CCM_CCGR2_I2C1)
+var I2C2 = newI2C(imx6.Peripherals.I2C2Base, imx6.CCM_CCGR2_I2C2)
+var I2C3 = newI2C(imx6.Peripherals.I2C3Base, imx6.CCM_CCGR2_I2C3)
+
+// Init initializes the I2C controller for master mode operation at the
+// argument bus speed (in Hz), which is approximated with the closest lower or
+// equal frequency achievable from the I2C module clock (PERCLK).
+func (hw *i2c) Init(speed int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if speed <= 0 {
+		return errors.New("invalid speed")
+	}
+
+	if err = imx6.EnableClock(2, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	ifdr, err := divider(imx6.PerclkFreq(), uint32(speed))
+
+	if err != nil {
+		return
+	}
+
+	// disable and reset the module
+	reg.Write16(hw.i2cr, 0)
+
+	reg.Write16(hw.ifdr, ifdr)
+	reg.Write16(hw.i2sr, 0)
+
+	reg.Write16(hw.i2cr, 1<<I2C_I2CR_IEN)
+
+	return
+}
+
+func divider(clk uint32, speed uint32) (val uint16, err error) {
+	for _, d := range dividers {
+		if clk/d.div <= speed {
+			return d.val, nil
+		}
+	}
+
+	return 0, errors.New("unsupported speed")
+}
+
+func (hw *i2c) wait(pos int, val uint16) (err error) {
+	start := time.Now()
+
+	for reg.Get16(hw.i2sr, pos, 0b1) != val {
+		if time.Since(start) > Timeout {
+			return errors.New("timeout")
+		}
+	}
+
+	return
+}
+
+func (hw *i2c) start(repeated bool) (err error) {
+	if repeated {
+		reg.SetN16(hw.i2cr, I2C_I2CR_RSTA, 0b1, 1)
+	} else {
+		if err = hw.wait(I2C_I2SR_IBB, 0); err != nil {
+			return errors.New("bus busy")
+		}
+
+		reg.SetN16(hw.i2cr, I2C_I2CR_MSTA, 0b1, 1)
+
+		if err = hw.wait(I2C_I2SR_IBB, 1); err != nil {
+			return
+		}
+	}
+
+	reg.SetN16(hw.i2cr, I2C_I2CR_MTX, 0b1, 1)
+
+	return
+}
+
+func (hw *i2c) stop() {
+	reg.SetN16(hw.i2cr, I2C_I2CR_MSTA, 0b1, 0)
+	reg.SetN16(hw.i2cr, I2C_I2CR_MTX, 0b1, 0)
+
+	hw.wait(I2C_I2SR_IBB, 0)
+}
+
+// transfer completion, the interrupt flag is cleared on return
+func (hw *i2c) complete() (err error) {
+	if err = hw.wait(I2C_I2SR_IIF, 1); err != nil {
+		return
+	}
+
+	if reg.Get16(hw.i2sr, I2C_I2SR_IAL, 0b1) == 1 {
+		err = errors.New("arbitration lost")
+	}
+
+	// clear interrupt flag (and arbitration lost status, if any)
+	reg.Write16(hw.i2sr, 0)
+
+	return
+}
+
+func (hw *i2c) tx(b byte) (err error) {
+	reg.Write16(hw.i2dr, uint16(b))
+
+	if err = hw.complete(); err != nil {
+		return
+	}
+
+	if reg.Get16(hw.i2sr, I2C_I2SR_RXAK, 0b1) == 1 {
+		return errors.New("no acknowledge")
+	}
+
+	return
+}
+
+func (hw *i2c) rx(buf []byte) (err error) {
+	// switch to receive mode
+	reg.SetN16(hw.i2cr, I2C_I2CR_MTX, 0b1, 0)
+
+	// do not acknowledge the last byte
+	if len(buf) == 1 {
+		reg.SetN16(hw.i2cr, I2C_I2CR_TXAK, 0b1, 1)
+	} else {
+		reg.SetN16(hw.i2cr, I2C_I2CR_TXAK, 0b1, 0)
+	}
+
+	// dummy read to initiate reception
+	reg.Read16(hw.i2dr)
+
+	for i := range buf {
+		if err = hw.complete(); err != nil {
+			return
+		}
+
+		switch i {
+		case len(buf) - 1:
+			// generate stop before reading the last byte
+			reg.SetN16(hw.i2cr, I2C_I2CR_MSTA, 0b1, 0)
+		case len(buf) - 2:
+			reg.SetN16(hw.i2cr, I2C_I2CR_TXAK, 0b1, 1)
+		}
+
+		buf[i] = byte(reg.Read16(hw.i2dr))
+	}
+
+	return
+}
+
+// Read reads a sequence of bytes, starting from the argument register
+// address, from the target I2C (7-bit) address.
+func (hw *i2c) Read(addr uint8, r uint8, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if len(buf) == 0 {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			hw.stop()
+		}
+	}()
+
+	if err = hw.start(false); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr << 1); err != nil {
+		return
+	}
+
+	if err = hw.tx(r); err != nil {
+		return
+	}
+
+	if err = hw.start(true); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr<<1 | 1); err != nil {
+		return
+	}
+
+	if err = hw.rx(buf); err != nil {
+		return
+	}
+
+	hw.stop()
+
+	return
+}
+
+// Write writes a sequence of bytes, starting from the argument register
+// address, to the target I2C (7-bit) address.
+func (hw *i2c) Write(addr uint8, r uint8, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	defer hw.stop()
+
+	if err = hw.start(false); err != nil {
+		return
+	}
+
+	if err = hw.tx(addr << 1); err != nil {
+		return
+	}
+
+	if err = hw.tx(r); err != nil {
+		return
+	}
+
+	for _, b := range buf {
+		if err = hw.tx(b); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -17,21 +17,49 @@ import (
 
 const USB_ANALOG_DIGPROG uint32 = 0x020c8260
 
+// p214, Table 2-1, ARM MP SCU, IMX6DQRM
+const SCU_CONFIG uint32 = 0x00a00004
+
 const IMX6Q = 0x63
 const IMX6UL = 0x64
 const IMX6ULL = 0x65
 
+// The i.MX6ULZ shares the i.MX6ULL USB_ANALOG_DIGPROG value and is identified
+// through its fuses (Value of OTP Bank0 Word6, IMX6ULLRM), the IMX6ULZ
+// constant is therefore not a DIGPROG family value but only used as Family.
+const IMX6ULZ = 0x6b
+
+const (
+	OCOTP_CFG5     uint32 = 0x021bc460
+	OCOTP_CFG5_ULZ        = 6
+)
+
+// Family holds the SoC family, read from USB_ANALOG_DIGPROG (see
+// SiliconVersion()) at early runtime initialization.
 var Family uint32
+
+// Native indicates whether the runtime is executing on real hardware rather
+// than under emulation.
 var Native bool
 
+// defined in imx6.s
+func read_l2ctlr() uint32
+
 // hwinit takes care of the lower level SoC initialization triggered early in
 // runtime setup, care must be taken to ensure that no heap allocation is
 // performed (e.g. defer is not possible).
+//
 //go:linkname hwinit runtime.hwinit
 func hwinit() {
 	_, fam, revMajor, revMinor := SiliconVersion()
 	Family = fam
 
+	if Family == IMX6ULL && (*(*uint32)(unsafe.Pointer(uintptr(OCOTP_CFG5))))&(1<<OCOTP_CFG5_ULZ) != 0 {
+		Family = IMX6ULZ
+	}
+
+	initPeripherals()
+
 	if revMajor != 0 || revMinor != 0 {
 		Native = true
 	}
@@ -39,7 +67,7 @@ func hwinit() {
 	switch Family {
 	case IMX6Q:
 		initGlobalTimers()
-	case IMX6UL, IMX6ULL:
+	case IMX6UL, IMX6ULL, IMX6ULZ:
 		initGenericTimers()
 	default:
 		initGlobalTimers()
@@ -72,6 +100,33 @@ func SiliconVersion() (sv, family, revMajor, revMinor uint32) {
 	return
 }
 
+// SiliconRevision returns the SoC silicon revision (e.g. 1.1 for TO1.1),
+// derived from the USB_ANALOG_DIGPROG major and minor fields, to allow
+// drivers and applications to apply revision specific errata workarounds.
+func SiliconRevision() (major, minor int) {
+	_, _, revMajor, revMinor := SiliconVersion()
+	return int(revMajor) + 1, int(revMinor)
+}
+
+// siliconRevisionBefore returns whether the SoC silicon revision is older
+// than the argument one (e.g. 1, 1 matches only TO1.0), to gate revision
+// specific errata workarounds.
+func siliconRevisionBefore(major, minor int) bool {
+	m, n := SiliconRevision()
+	return m < major || (m == major && n < minor)
+}
+
+// logSiliconRevision reports the SoC model, silicon revision and maximum ARM
+// core frequency, it must be invoked after initSpeedGrade().
+func logSiliconRevision() {
+	if !Native {
+		return
+	}
+
+	major, minor := SiliconRevision()
+	logf("imx6_soc: %s TO%d.%d (max ARM core frequency %d MHz)\n", Model(), major, minor, MaxARMFreq()/1000000)
+}
+
 // Model returns the SoC model name.
 func Model() (model string) {
 	switch Family {
@@ -81,9 +136,27 @@ func Model() (model string) {
 		model = "i.MX6UL"
 	case IMX6ULL:
 		model = "i.MX6ULL"
+	case IMX6ULZ:
+		model = "i.MX6ULZ"
 	default:
 		model = "unknown"
 	}
 
 	return
 }
+
+// Cores returns the number of ARM cores present on the SoC.
+func Cores() int {
+	switch Family {
+	case IMX6UL, IMX6ULL, IMX6ULZ:
+		// Cortex™-A7 MPCore® Technical Reference Manual
+		// 4.3.50 L2 Control Register, bits [25:24]
+		return int((read_l2ctlr()>>24)&0b11) + 1
+	case IMX6Q:
+		// Cortex™-A9 MPCore® Technical Reference Manual
+		// 2.2.2 SCU Configuration Register, bits [1:0]
+		return int((*(*uint32)(unsafe.Pointer(uintptr(SCU_CONFIG))))&0b11) + 1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,17 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func read_l2ctlr() uint32
+TEXT ·read_l2ctlr(SB),$0
+	// Cortex™-A7 MPCore® Technical Reference Manual
+	// 4.3.50 L2 Control Register
+	WORD	$0xee390f50 // mrc p15, 1, r0, c9, c0, 2
+
+	MOVW	R0, ret+0(FP)
+
+	RET
@@ -0,0 +1,45 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package cache
+
+// B4.1.130 SCTLR, System Control Register, ARMv7-AR ARM
+const (
+	SCTLR_I = 12
+	SCTLR_C = 2
+)
+
+// defined in cache.s
+func InvalidateData()
+func sctlr() uint32
+func disableL1()
+
+// EnableL1 invalidates, and then enables, the L1 instruction and data caches.
+//
+// The caches are invalidated first to prevent stale lines, left from before
+// reset or a previous DisableL1(), from being used. The function has no
+// effect if the data cache is already enabled, as invalidation would discard
+// dirty lines.
+func EnableL1() {
+	if sctlr()&(1<<SCTLR_C) != 0 {
+		return
+	}
+
+	FlushInstruction()
+	InvalidateData()
+	Enable()
+}
+
+// DisableL1 disables, and then cleans and invalidates, the L1 instruction
+// and data caches, writing back any dirty line to memory.
+func DisableL1() {
+	disableL1()
+	FlushInstruction()
+}
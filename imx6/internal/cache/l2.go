@@ -0,0 +1,90 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package cache
+
+import (
+	"unsafe"
+)
+
+// PL310 L2 cache controller registers, CoreLink Level 2 Cache Controller
+// L2C-310 Technical Reference Manual.
+const (
+	L2X0_CTRL    = 0x100
+	L2X0_CTRL_EN = 0
+
+	L2X0_AUX_CTRL       = 0x104
+	L2X0_AUX_CTRL_ASSOC = 16
+
+	L2X0_CACHE_SYNC    = 0x730
+	L2X0_INV_WAY       = 0x77c
+	L2X0_CLEAN_INV_WAY = 0x7fc
+)
+
+func l2x0(base uint32, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+}
+
+// l2x0Ways returns the way mask for all cache ways (8 or 16).
+func l2x0Ways(base uint32) uint32 {
+	if (*l2x0(base, L2X0_AUX_CTRL)>>L2X0_AUX_CTRL_ASSOC)&1 == 1 {
+		return 0xffff
+	}
+
+	return 0xff
+}
+
+// l2x0Maintain performs a background operation on all cache ways and waits
+// for its completion.
+func l2x0Maintain(base uint32, off uint32) {
+	op := l2x0(base, off)
+	sync := l2x0(base, L2X0_CACHE_SYNC)
+
+	*op = l2x0Ways(base)
+
+	for *op != 0 {
+		// wait for completion
+	}
+
+	*sync = 0
+
+	for *sync&1 != 0 {
+		// wait for completion
+	}
+}
+
+// EnableL2 invalidates, and then enables, the PL310 L2 cache controller at
+// the argument base address. The function has no effect if the L2 cache is
+// already enabled.
+func EnableL2(base uint32) {
+	ctrl := l2x0(base, L2X0_CTRL)
+
+	if *ctrl&(1<<L2X0_CTRL_EN) != 0 {
+		return
+	}
+
+	l2x0Maintain(base, L2X0_INV_WAY)
+	*ctrl |= 1 << L2X0_CTRL_EN
+}
+
+// DisableL2 cleans and invalidates, and then disables, the PL310 L2 cache
+// controller at the argument base address. The L1 data cache should be
+// cleaned beforehand (see FlushData()), to write back its dirty lines to the
+// L2 cache before it is disabled.
+func DisableL2(base uint32) {
+	ctrl := l2x0(base, L2X0_CTRL)
+
+	if *ctrl&(1<<L2X0_CTRL_EN) == 0 {
+		return
+	}
+
+	l2x0Maintain(base, L2X0_CLEAN_INV_WAY)
+	*ctrl &^= 1 << L2X0_CTRL_EN
+}
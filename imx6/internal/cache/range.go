@@ -0,0 +1,72 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package cache
+
+// LINE_SIZE represents the Cortex-A7 data cache line size, range operations
+// affect all cache lines overlapping the argument range.
+const LINE_SIZE = 64
+
+// defined in range.s
+func cleanRange(addr uintptr, size uintptr)
+func invalidateRange(addr uintptr, size uintptr)
+func flushRange(addr uintptr, size uintptr)
+
+// CleanRange cleans (writes back) the data cache lines for the argument
+// memory range to the point of coherency (DCCMVAC), to be used before a
+// device reads a buffer.
+func CleanRange(addr uintptr, size uintptr) {
+	if size == 0 {
+		return
+	}
+
+	cleanRange(addr, size)
+}
+
+// InvalidateRange invalidates the data cache lines for the argument memory
+// range (DCIMVAC), to be used after a device writes a buffer.
+//
+// Partial cache lines, at the boundaries of unaligned ranges, are cleaned and
+// invalidated (DCCIMVAC) rather than invalidated, to avoid discarding writes
+// to adjacent data. Any dirty adjacent data is therefore written back over
+// the device written buffer ends, buffers meant for device writes should be
+// aligned to LINE_SIZE (and sized in multiples of it) to prevent this.
+func InvalidateRange(addr uintptr, size uintptr) {
+	if size == 0 {
+		return
+	}
+
+	end := addr + size
+
+	if addr&(LINE_SIZE-1) != 0 {
+		flushRange(addr, 1)
+		addr = (addr + LINE_SIZE) &^ (LINE_SIZE - 1)
+	}
+
+	if end&(LINE_SIZE-1) != 0 && end > addr {
+		flushRange(end-1, 1)
+		end &^= LINE_SIZE - 1
+	}
+
+	if end > addr {
+		invalidateRange(addr, end-addr)
+	}
+}
+
+// FlushRange cleans and invalidates the data cache lines for the argument
+// memory range (DCCIMVAC), to be used for buffers both read and written by a
+// device.
+func FlushRange(addr uintptr, size uintptr) {
+	if size == 0 {
+		return
+	}
+
+	flushRange(addr, size)
+}
@@ -0,0 +1,61 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Cortex-A7 data cache line size
+#define LINE_SIZE 64
+
+// func cleanRange(addr uintptr, size uintptr)
+TEXT ·cleanRange(SB),$0-8
+	MOVW	addr+0(FP), R0
+	MOVW	size+4(FP), R1
+	ADD	R0, R1				// end address
+	BIC	$(LINE_SIZE-1), R0		// align to cache line
+clean_loop:
+	CMP	R1, R0
+	BHS	clean_done
+	MCR	15, 0, R0, C7, C10, 1		// DCCMVAC
+	ADD	$LINE_SIZE, R0
+	B	clean_loop
+clean_done:
+	WORD	$0xf57ff04f			// DSB SY
+	WORD	$0xf57ff06f			// ISB SY
+	RET
+
+// func invalidateRange(addr uintptr, size uintptr)
+TEXT ·invalidateRange(SB),$0-8
+	MOVW	addr+0(FP), R0
+	MOVW	size+4(FP), R1
+	ADD	R0, R1				// end address
+	BIC	$(LINE_SIZE-1), R0		// align to cache line
+invalidate_loop:
+	CMP	R1, R0
+	BHS	invalidate_done
+	MCR	15, 0, R0, C7, C6, 1		// DCIMVAC
+	ADD	$LINE_SIZE, R0
+	B	invalidate_loop
+invalidate_done:
+	WORD	$0xf57ff04f			// DSB SY
+	WORD	$0xf57ff06f			// ISB SY
+	RET
+
+// func flushRange(addr uintptr, size uintptr)
+TEXT ·flushRange(SB),$0-8
+	MOVW	addr+0(FP), R0
+	MOVW	size+4(FP), R1
+	ADD	R0, R1				// end address
+	BIC	$(LINE_SIZE-1), R0		// align to cache line
+flush_loop:
+	CMP	R1, R0
+	BHS	flush_done
+	MCR	15, 0, R0, C7, C14, 1		// DCCIMVAC
+	ADD	$LINE_SIZE, R0
+	B	flush_loop
+flush_done:
+	WORD	$0xf57ff04f			// DSB SY
+	WORD	$0xf57ff06f			// ISB SY
+	RET
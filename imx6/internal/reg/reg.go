@@ -11,22 +11,25 @@
 package reg
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
 	"sync"
 	"time"
-
-	"github.com/inversepath/tamago/imx6/internal/cache"
+	"unsafe"
 )
 
-// TODO: disable cache for peripheral space instead of cache.FlushData() every
-// time
+// TODO: disable cache for peripheral space instead of flushData() every time
 
 var mutex sync.Mutex
 
+// ErrTimeout is returned by WaitUntil when its deadline expires.
+var ErrTimeout = errors.New("register wait timeout")
+
 func Get(reg *uint32, pos int, mask int) (val uint32) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	val = uint32((int(*reg) >> pos) & mask)
 
 	mutex.Unlock()
@@ -37,17 +40,30 @@ func Get(reg *uint32, pos int, mask int) (val uint32) {
 func Set(reg *uint32, pos int) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	*reg |= (1 << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+func Read(reg *uint32) (val uint32) {
+	mutex.Lock()
+
+	flushData()
+	val = *reg
 
 	mutex.Unlock()
+
+	return
 }
 
 func Write(reg *uint32, val uint32) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	*reg = val
+	Barrier()
 
 	mutex.Unlock()
 }
@@ -55,26 +71,254 @@ func Write(reg *uint32, val uint32) {
 func Clear(reg *uint32, pos int) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	*reg &= ^(1 << pos)
+	Barrier()
 
 	mutex.Unlock()
 }
 
+// SetN sets a register field, identified by its bit position and unshifted
+// mask (e.g. pos 18 and mask 0b11111 for bits [22:18]), to a value. The value
+// is not masked, see SetTo and SetNChecked for safer alternatives.
 func SetN(reg *uint32, pos int, mask int, val uint32) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	*reg = (*reg & (^(uint32(mask) << pos))) | (val << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+// SetNChecked is like SetN but it returns an error, without modifying the
+// register, if the value exceeds the field mask or the field exceeds the
+// register width, rather than silently corrupting adjacent fields.
+func SetNChecked(reg *uint32, pos int, mask int, val uint32) error {
+	if pos < 0 || mask <= 0 || uint64(mask)<<uint(pos) > 0xffffffff {
+		return fmt.Errorf("invalid register field (pos:%d mask:%#x)", pos, mask)
+	}
+
+	if val > uint32(mask) {
+		return fmt.Errorf("value %#x exceeds register field mask %#x", val, mask)
+	}
+
+	SetN(reg, pos, mask, val)
+
+	return nil
+}
+
+// SetTo clears a register field and sets it to a value, bits of the value
+// outside the field mask are ignored.
+func SetTo(reg *uint32, pos int, mask int, val uint32) {
+	mutex.Lock()
+
+	flushData()
+	*reg = (*reg & (^(uint32(mask) << pos))) | ((val & uint32(mask)) << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+// FieldValue represents a register field value for SetToN.
+type FieldValue struct {
+	Pos  int
+	Mask int
+	Val  uint32
+}
+
+// SetToN clears and sets multiple register fields with a single register
+// write, bits of each value outside its field mask are ignored.
+func SetToN(reg *uint32, fields ...FieldValue) {
+	mutex.Lock()
+
+	flushData()
+	r := *reg
+
+	for _, f := range fields {
+		r = (r & (^(uint32(f.Mask) << f.Pos))) | ((f.Val & uint32(f.Mask)) << f.Pos)
+	}
+
+	*reg = r
+	Barrier()
 
 	mutex.Unlock()
 }
 
+// ClearN clears a register field, following the same convention as SetN: the
+// mask is unshifted and the bits cleared are mask<<pos. A mask already shifted
+// to its position must therefore be passed with pos 0.
 func ClearN(reg *uint32, pos int, mask int) {
 	mutex.Lock()
 
-	cache.FlushData()
+	flushData()
 	*reg &= ^(uint32(mask) << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+// Toggle inverts a single register bit.
+func Toggle(reg *uint32, pos int) {
+	mutex.Lock()
+
+	flushData()
+	*reg ^= (1 << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+// GetN returns a register field of the argument width (in bits), as an
+// alternative to Get when the field mask is not at hand.
+func GetN(reg *uint32, pos int, width int) (val uint32) {
+	mutex.Lock()
+
+	flushData()
+	val = (*reg >> pos) & (1<<uint(width) - 1)
+
+	mutex.Unlock()
+
+	return
+}
+
+// Field describes a named register field, for use with Decode.
+type Field struct {
+	Name string
+	Pos  int
+	Mask int
+}
+
+// Decode reads a register once and returns the value of each argument field,
+// indexed by field name, to ease register inspection while debugging.
+func Decode(reg *uint32, fields []Field) (vals map[string]uint32) {
+	r := Read(reg)
+	vals = make(map[string]uint32, len(fields))
+
+	for _, f := range fields {
+		vals[f.Name] = (r >> f.Pos) & uint32(f.Mask)
+	}
+
+	return
+}
+
+// Any returns whether any bit of a register field is set, as a shorthand
+// for Get(reg, pos, mask) != 0 in status polling.
+func Any(reg *uint32, pos int, mask int) bool {
+	return Get(reg, pos, mask) != 0
+}
+
+// All returns whether all bits of a register field are set, as a shorthand
+// for Get(reg, pos, mask) == mask in status polling.
+func All(reg *uint32, pos int, mask int) bool {
+	return Get(reg, pos, mask) == uint32(mask)
+}
+
+// Read64 returns a 64-bit value split across two 32-bit registers, which
+// cannot be read atomically. The high register is read again after the low
+// one, and the sequence is repeated if it changed, so that a rollover of
+// the low register between the two reads does not result in a torn value.
+func Read64(hi *uint32, lo *uint32) (val uint64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	flushData()
+
+	for {
+		h := read32(hi)
+		l := read32(lo)
+
+		if read32(hi) == h {
+			return uint64(h)<<32 | uint64(l)
+		}
+	}
+}
+
+// The following functions provide 8-bit and 16-bit register access, the
+// caller must match the access width supported by the peripheral register as
+// accessing an 8-bit only register with a 32-bit access (and vice versa) can
+// result in a bus error.
+
+func Read8(reg *uint8) (val uint8) {
+	mutex.Lock()
+
+	flushData()
+	val = *reg
+
+	mutex.Unlock()
+
+	return
+}
+
+func Write8(reg *uint8, val uint8) {
+	mutex.Lock()
+
+	flushData()
+	*reg = val
+	Barrier()
+
+	mutex.Unlock()
+}
+
+func Get8(reg *uint8, pos int, mask int) (val uint8) {
+	mutex.Lock()
+
+	flushData()
+	val = uint8((int(*reg) >> pos) & mask)
+
+	mutex.Unlock()
+
+	return
+}
+
+func SetN8(reg *uint8, pos int, mask int, val uint8) {
+	mutex.Lock()
+
+	flushData()
+	*reg = (*reg & (^(uint8(mask) << pos))) | ((val & uint8(mask)) << pos)
+	Barrier()
+
+	mutex.Unlock()
+}
+
+func Read16(reg *uint16) (val uint16) {
+	mutex.Lock()
+
+	flushData()
+	val = *reg
+
+	mutex.Unlock()
+
+	return
+}
+
+func Write16(reg *uint16, val uint16) {
+	mutex.Lock()
+
+	flushData()
+	*reg = val
+	Barrier()
+
+	mutex.Unlock()
+}
+
+func Get16(reg *uint16, pos int, mask int) (val uint16) {
+	mutex.Lock()
+
+	flushData()
+	val = uint16((int(*reg) >> pos) & mask)
+
+	mutex.Unlock()
+
+	return
+}
+
+func SetN16(reg *uint16, pos int, mask int, val uint16) {
+	mutex.Lock()
+
+	flushData()
+	*reg = (*reg & (^(uint16(mask) << pos))) | ((val & uint16(mask)) << pos)
+	Barrier()
 
 	mutex.Unlock()
 }
@@ -108,3 +352,175 @@ func WaitFor(timeout time.Duration, reg *uint32, pos int, mask int, val uint32)
 
 	return true
 }
+
+// WaitUntil waits, until the argument deadline, for a specific register bit to
+// match a value, returning ErrTimeout if the deadline expires first. The
+// deadline is checked against the runtime monotonic clock, which is
+// independent from the ARM core frequency. This function cannot be used
+// before runtime initialization with `GOOS=tamago`.
+func WaitUntil(reg *uint32, pos int, mask int, val uint32, deadline time.Time) error {
+	for Get(reg, pos, mask) != val {
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+
+	return nil
+}
+
+// WaitWhile waits for a specific register bit to no longer match a value
+// (e.g. while a busy flag is set), as the inverse of Wait. This function
+// cannot be used before runtime initialization with `GOOS=tamago`.
+func WaitWhile(reg *uint32, pos int, mask int, val uint32) {
+	for Get(reg, pos, mask) == val {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+}
+
+// WaitWhileFor waits, until a timeout expires, for a specific register bit to
+// no longer match a value, as the inverse of WaitFor. The return boolean
+// indicates whether the wait condition was checked (true) or if it timed out
+// (false). This function cannot be used before runtime initialization with
+// `GOOS=tamago`.
+func WaitWhileFor(timeout time.Duration, reg *uint32, pos int, mask int, val uint32) bool {
+	start := time.Now()
+
+	for Get(reg, pos, mask) == val {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+
+		if time.Since(start) >= timeout {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WaitLoops waits, for a maximum number of polling iterations, for a specific
+// register bit to match a value. The return boolean indicates whether the wait
+// condition was checked (true) or if the maximum number was reached (false), a
+// loops value less than or equal to zero waits indefinitely.
+//
+// Unlike Wait and WaitFor this function does not yield to other goroutines
+// and does not depend on the system timer, therefore it can be used before
+// runtime initialization with `GOOS=tamago`.
+func WaitLoops(reg *uint32, pos int, mask int, val uint32, loops int) bool {
+	for i := 0; Get(reg, pos, mask) != val; i++ {
+		if loops > 0 && i >= loops {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Register represents a 32-bit hardware register, its methods are equivalent
+// to the functions with the same name in this package.
+type Register struct {
+	addr *uint32
+}
+
+// New returns a Register instance for the argument register address.
+func New(addr uint32) *Register {
+	return &Register{
+		addr: (*uint32)(unsafe.Pointer(uintptr(addr))),
+	}
+}
+
+// Addr returns the register pointer, for use with the functions in this
+// package.
+func (r *Register) Addr() *uint32 {
+	return r.addr
+}
+
+func (r *Register) Get(pos int, mask int) uint32 {
+	return Get(r.addr, pos, mask)
+}
+
+func (r *Register) Set(pos int) {
+	Set(r.addr, pos)
+}
+
+func (r *Register) Read() uint32 {
+	return Read(r.addr)
+}
+
+func (r *Register) Write(val uint32) {
+	Write(r.addr, val)
+}
+
+func (r *Register) Clear(pos int) {
+	Clear(r.addr, pos)
+}
+
+func (r *Register) SetN(pos int, mask int, val uint32) {
+	SetN(r.addr, pos, mask, val)
+}
+
+func (r *Register) SetNChecked(pos int, mask int, val uint32) error {
+	return SetNChecked(r.addr, pos, mask, val)
+}
+
+func (r *Register) SetTo(pos int, mask int, val uint32) {
+	SetTo(r.addr, pos, mask, val)
+}
+
+func (r *Register) SetToN(fields ...FieldValue) {
+	SetToN(r.addr, fields...)
+}
+
+func (r *Register) ClearN(pos int, mask int) {
+	ClearN(r.addr, pos, mask)
+}
+
+func (r *Register) Wait(pos int, mask int, val uint32) {
+	Wait(r.addr, pos, mask, val)
+}
+
+func (r *Register) WaitFor(timeout time.Duration, pos int, mask int, val uint32) bool {
+	return WaitFor(timeout, r.addr, pos, mask, val)
+}
+
+func (r *Register) WaitUntil(pos int, mask int, val uint32, deadline time.Time) error {
+	return WaitUntil(r.addr, pos, mask, val, deadline)
+}
+
+func (r *Register) WaitWhile(pos int, mask int, val uint32) {
+	WaitWhile(r.addr, pos, mask, val)
+}
+
+func (r *Register) WaitWhileFor(timeout time.Duration, pos int, mask int, val uint32) bool {
+	return WaitWhileFor(timeout, r.addr, pos, mask, val)
+}
+
+func (r *Register) Toggle(pos int) {
+	Toggle(r.addr, pos)
+}
+
+func (r *Register) GetN(pos int, width int) uint32 {
+	return GetN(r.addr, pos, width)
+}
+
+func (r *Register) Decode(fields []Field) map[string]uint32 {
+	return Decode(r.addr, fields)
+}
+
+func (r *Register) Any(pos int, mask int) bool {
+	return Any(r.addr, pos, mask)
+}
+
+func (r *Register) All(pos int, mask int) bool {
+	return All(r.addr, pos, mask)
+}
+
+func (r *Register) WaitLoops(pos int, mask int, val uint32, loops int) bool {
+	return WaitLoops(r.addr, pos, mask, val, loops)
+}
@@ -0,0 +1,15 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// func Barrier()
+TEXT ·Barrier(SB),$0
+	WORD	$0xf57ff04f // dsb sy
+	WORD	$0xf57ff06f // isb sy
+	RET
@@ -0,0 +1,31 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build !tamago !arm
+
+package reg
+
+// Off-target builds (e.g. `go test` on the host) operate on ordinary memory,
+// therefore cache maintenance and barriers are not required.
+
+// Barrier is a no-op off-target.
+func Barrier() {}
+
+func flushData() {}
+
+// readHook, when set, replaces register reads performed through read32, to
+// allow tests to simulate registers changing between reads (e.g. Read64).
+var readHook func(reg *uint32) uint32
+
+func read32(reg *uint32) uint32 {
+	if readHook != nil {
+		return readHook(reg)
+	}
+
+	return *reg
+}
@@ -0,0 +1,61 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build !tamago !arm
+
+package reg
+
+import (
+	"testing"
+)
+
+// Tests relying on the off-target read hook (see reg_stub.go).
+
+func TestRead64Rollover(t *testing.T) {
+	var hiReads, loReads int
+
+	hi := uint32(0)
+	lo := uint32(0)
+
+	// the low register rolls over, incrementing the high one, between the
+	// first high and low reads
+	readHook = func(reg *uint32) uint32 {
+		switch reg {
+		case &hi:
+			hiReads++
+
+			if hiReads == 1 {
+				return 0x00000001
+			}
+
+			return 0x00000002
+		case &lo:
+			loReads++
+
+			if loReads == 1 {
+				return 0x00000003
+			}
+
+			return 0x00000005
+		}
+
+		return *reg
+	}
+	defer func() { readHook = nil }()
+
+	want := uint64(0x0000000200000005)
+
+	if got := Read64(&hi, &lo); got != want {
+		t.Errorf("Read64() = %#x, want %#x", got, want)
+	}
+
+	// one retry: hi, lo, hi (changed), hi, lo, hi (stable)
+	if hiReads != 4 || loReads != 2 {
+		t.Errorf("Read64() performed %d high and %d low reads, want 4 and 2", hiReads, loReads)
+	}
+}
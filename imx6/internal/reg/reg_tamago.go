@@ -0,0 +1,35 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package reg
+
+import (
+	"github.com/inversepath/tamago/imx6/internal/cache"
+)
+
+// Barrier issues a Data Synchronization Barrier, ensuring that all previous
+// memory accesses (including register writes) are complete before any
+// following one is performed, and an Instruction Synchronization Barrier.
+//
+// All write functions in this package issue a barrier after the register
+// write, therefore an explicit barrier is required only when registers are
+// directly written through pointers and ordering matters (e.g. a peripheral
+// configuration sequence).
+//
+// Defined in reg.s.
+func Barrier()
+
+func flushData() {
+	cache.FlushData()
+}
+
+func read32(reg *uint32) uint32 {
+	return *reg
+}
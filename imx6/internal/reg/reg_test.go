@@ -0,0 +1,466 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package reg
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		mask int
+		want uint32
+	}{
+		{0x00000000, 0, 0b1, 0},
+		{0x00000001, 0, 0b1, 1},
+		{0x80000000, 31, 0b1, 1},
+		{0x00f00000, 20, 0xf, 0xf},
+		{0x12345678, 8, 0xff, 0x56},
+		{0xffffffff, 4, 0b101, 0b101},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+
+		if got := Get(&r, tt.pos, tt.mask); got != tt.want {
+			t.Errorf("Get(%#x, %d, %#x) = %#x, want %#x", tt.val, tt.pos, tt.mask, got, tt.want)
+		}
+
+		if r != tt.val {
+			t.Errorf("Get(%#x, %d, %#x) modified register to %#x", tt.val, tt.pos, tt.mask, r)
+		}
+	}
+}
+
+func TestSetClear(t *testing.T) {
+	tests := []struct {
+		val   uint32
+		pos   int
+		set   uint32
+		clear uint32
+	}{
+		{0x00000000, 0, 0x00000001, 0x00000000},
+		{0x00000000, 31, 0x80000000, 0x00000000},
+		{0xffffffff, 7, 0xffffffff, 0xffffff7f},
+		{0x12345678, 0, 0x12345679, 0x12345678},
+		{0x12345678, 3, 0x12345678, 0x12345670},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+		Set(&r, tt.pos)
+
+		if r != tt.set {
+			t.Errorf("Set(%#x, %d) = %#x, want %#x", tt.val, tt.pos, r, tt.set)
+		}
+
+		r = tt.val
+		Clear(&r, tt.pos)
+
+		if r != tt.clear {
+			t.Errorf("Clear(%#x, %d) = %#x, want %#x", tt.val, tt.pos, r, tt.clear)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	var r uint32
+	want := uint32(0xdeadbeef)
+
+	Write(&r, want)
+
+	if got := Read(&r); got != want {
+		t.Errorf("Read() = %#x, want %#x", got, want)
+	}
+}
+
+func TestSetN(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		mask int
+		set  uint32
+		want uint32
+	}{
+		{0x00000000, 0, 0xf, 0xa, 0x0000000a},
+		{0xffffffff, 4, 0xf, 0x0, 0xffffff0f},
+		{0xffffffff, 28, 0xf, 0x5, 0x5fffffff},
+		{0x12345678, 8, 0xff, 0xab, 0x1234ab78},
+		{0x00000000, 18, 0b11111, 0b10101, 0b10101 << 18},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+		SetN(&r, tt.pos, tt.mask, tt.set)
+
+		if r != tt.want {
+			t.Errorf("SetN(%#x, %d, %#x, %#x) = %#x, want %#x", tt.val, tt.pos, tt.mask, tt.set, r, tt.want)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	r := uint32(0b10)
+
+	// already matching
+	Wait(&r, 1, 0b1, 1)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		Set(&r, 0)
+	}()
+
+	Wait(&r, 0, 0b1, 1)
+
+	if got := Read(&r); got != 0b11 {
+		t.Errorf("register = %#x after Wait, want %#x", got, 0b11)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	var r uint32
+
+	if WaitFor(10*time.Millisecond, &r, 0, 0b1, 1) {
+		t.Error("WaitFor() = true on unchanged register, want false")
+	}
+
+	Set(&r, 0)
+
+	if !WaitFor(10*time.Millisecond, &r, 0, 0b1, 1) {
+		t.Error("WaitFor() = false on matching register, want true")
+	}
+}
+
+func TestSetTo(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		mask int
+		set  uint32
+		want uint32
+	}{
+		{0xffffffff, 4, 0xf, 0x0, 0xffffff0f},
+		{0x00000000, 4, 0xf, 0xa, 0x000000a0},
+		{0xa5a5a5a5, 8, 0xff, 0x3c, 0xa5a53ca5},
+		// bits outside the mask are ignored
+		{0x00000000, 4, 0xf, 0xfa, 0x000000a0},
+		{0xffff0000, 0, 0xff, 0x1234, 0xffff0034},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+		SetTo(&r, tt.pos, tt.mask, tt.set)
+
+		if r != tt.want {
+			t.Errorf("SetTo(%#x, %d, %#x, %#x) = %#x, want %#x", tt.val, tt.pos, tt.mask, tt.set, r, tt.want)
+		}
+
+		field := uint32(tt.mask) << uint(tt.pos)
+
+		if r&^field != tt.val&^field {
+			t.Errorf("SetTo(%#x, %d, %#x, %#x) modified bits outside the field", tt.val, tt.pos, tt.mask, tt.set)
+		}
+	}
+}
+
+func TestSetToN(t *testing.T) {
+	r := uint32(0xa5a5a5a5)
+
+	SetToN(&r,
+		FieldValue{Pos: 0, Mask: 0b11111, Val: 0b00011},
+		FieldValue{Pos: 18, Mask: 0b11111, Val: 0b11100},
+		// bits outside the mask are ignored
+		FieldValue{Pos: 28, Mask: 0b11, Val: 0b1110},
+	)
+
+	// all bits outside the three fields retain their original value
+	want := uint32(0xa5a5a5a5)
+	want = want&^(0b11111<<0) | 0b00011<<0
+	want = want&^(0b11111<<18) | 0b11100<<18
+	want = want&^(0b11<<28) | 0b10<<28
+
+	if r != want {
+		t.Errorf("SetToN() = %#x, want %#x", r, want)
+	}
+
+	// no fields, no change
+	r = 0x12345678
+	SetToN(&r)
+
+	if r != 0x12345678 {
+		t.Errorf("SetToN() without fields modified register to %#x", r)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		want uint32
+	}{
+		{0x00000000, 0, 0x00000001},
+		{0x00000001, 0, 0x00000000},
+		{0x00000000, 31, 0x80000000},
+		{0xffffffff, 16, 0xfffeffff},
+		{0x12345678, 3, 0x12345670},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+		Toggle(&r, tt.pos)
+
+		if r != tt.want {
+			t.Errorf("Toggle(%#x, %d) = %#x, want %#x", tt.val, tt.pos, r, tt.want)
+		}
+
+		// a second toggle restores the original value
+		Toggle(&r, tt.pos)
+
+		if r != tt.val {
+			t.Errorf("Toggle(Toggle(%#x, %d)) = %#x, want %#x", tt.val, tt.pos, r, tt.val)
+		}
+	}
+}
+
+func TestGetN(t *testing.T) {
+	tests := []struct {
+		val   uint32
+		pos   int
+		width int
+		want  uint32
+	}{
+		{0x00000000, 0, 1, 0},
+		{0x80000000, 31, 1, 1},
+		{0x12345678, 0, 8, 0x78},
+		{0x12345678, 8, 8, 0x56},
+		{0x12345678, 28, 4, 0x1},
+		{0x007c0000, 18, 5, 0b11111},
+		{0xffffffff, 0, 32, 0xffffffff},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+
+		if got := GetN(&r, tt.pos, tt.width); got != tt.want {
+			t.Errorf("GetN(%#x, %d, %d) = %#x, want %#x", tt.val, tt.pos, tt.width, got, tt.want)
+		}
+
+		// GetN is equivalent to Get with the corresponding mask
+		if tt.width < 32 {
+			if got := Get(&r, tt.pos, 1<<uint(tt.width)-1); got != tt.want {
+				t.Errorf("Get(%#x, %d, %#x) = %#x, want %#x", tt.val, tt.pos, 1<<uint(tt.width)-1, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRead64(t *testing.T) {
+	hi := uint32(0x00000001)
+	lo := uint32(0x89abcdef)
+
+	if got := Read64(&hi, &lo); got != 0x0000000189abcdef {
+		t.Errorf("Read64() = %#x, want %#x", got, uint64(0x0000000189abcdef))
+	}
+}
+
+func TestAnyAll(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		mask int
+		any  bool
+		all  bool
+	}{
+		{0x00000000, 10, 0b11111, false, false},
+		{0x00000400, 10, 0b11111, true, false},
+		{0x00007c00, 10, 0b11111, true, true},
+		{0x00004000, 10, 0b11111, true, false},
+		// bits outside the field are ignored
+		{0xffff83ff, 10, 0b11111, false, false},
+		{0x80000000, 31, 0b1, true, true},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+
+		if got := Any(&r, tt.pos, tt.mask); got != tt.any {
+			t.Errorf("Any(%#x, %d, %#x) = %v, want %v", tt.val, tt.pos, tt.mask, got, tt.any)
+		}
+
+		if got := All(&r, tt.pos, tt.mask); got != tt.all {
+			t.Errorf("All(%#x, %d, %#x) = %v, want %v", tt.val, tt.pos, tt.mask, got, tt.all)
+		}
+	}
+}
+
+func TestClearN(t *testing.T) {
+	tests := []struct {
+		val  uint32
+		pos  int
+		mask int
+		want uint32
+	}{
+		{0xffffffff, 0, 0b1, 0xfffffffe},
+		{0xffffffff, 4, 0xf, 0xffffff0f},
+		{0xffffffff, 18, 0b11111, 0xff83ffff},
+		{0xffffffff, 30, 0b11, 0x3fffffff},
+		{0x12345678, 8, 0xff, 0x12340078},
+	}
+
+	for _, tt := range tests {
+		r := tt.val
+		ClearN(&r, tt.pos, tt.mask)
+
+		if r != tt.want {
+			t.Errorf("ClearN(%#x, %d, %#x) = %#x, want %#x", tt.val, tt.pos, tt.mask, r, tt.want)
+		}
+	}
+}
+
+// TestFieldConvention pins down the convention shared by SetN and ClearN: the
+// mask is unshifted and the field affected is mask<<pos.
+func TestFieldConvention(t *testing.T) {
+	masks := []int{0b1, 0b11, 0b111, 0xf, 0b11111, 0xff, 0xfff, 0xffff}
+
+	for _, mask := range masks {
+		for pos := 0; uint64(mask)<<uint(pos) <= 0xffffffff; pos++ {
+			field := uint32(mask) << uint(pos)
+
+			r := uint32(0)
+			SetN(&r, pos, mask, uint32(mask))
+
+			if r != field {
+				t.Errorf("SetN(0, %d, %#x, %#x) = %#x, want %#x", pos, mask, mask, r, field)
+			}
+
+			r = 0xffffffff
+			ClearN(&r, pos, mask)
+
+			if r != ^field {
+				t.Errorf("ClearN(%#x, %d, %#x) = %#x, want %#x", uint32(0xffffffff), pos, mask, r, ^field)
+			}
+
+			// SetN followed by ClearN on the same field restores
+			// the original value
+			r = 0xa5a5a5a5 &^ field
+			SetN(&r, pos, mask, uint32(mask))
+			ClearN(&r, pos, mask)
+
+			if want := uint32(0xa5a5a5a5) &^ field; r != want {
+				t.Errorf("ClearN(SetN(%#x, %d, %#x)) = %#x, want %#x", want, pos, mask, r, want)
+			}
+
+			// a pre-shifted mask is equivalent only with pos 0
+			s := uint32(0xffffffff)
+			ClearN(&s, 0, int(field))
+
+			if s != ^field {
+				t.Errorf("ClearN(%#x, 0, %#x) = %#x, want %#x", uint32(0xffffffff), field, s, ^field)
+			}
+		}
+	}
+}
+
+func TestZeroWidth(t *testing.T) {
+	vals := []uint32{0x00000000, 0xffffffff, 0xa5a5a5a5}
+
+	for _, val := range vals {
+		for _, pos := range []int{0, 7, 31} {
+			r := val
+
+			if got := GetN(&r, pos, 0); got != 0 {
+				t.Errorf("GetN(%#x, %d, 0) = %#x, want 0", val, pos, got)
+			}
+
+			if got := Get(&r, pos, 0); got != 0 {
+				t.Errorf("Get(%#x, %d, 0) = %#x, want 0", val, pos, got)
+			}
+
+			if Any(&r, pos, 0) {
+				t.Errorf("Any(%#x, %d, 0) = true, want false", val, pos)
+			}
+
+			SetN(&r, pos, 0, 0)
+			ClearN(&r, pos, 0)
+			// the value is masked out of the (empty) field
+			SetTo(&r, pos, 0, 0xffffffff)
+			SetToN(&r, FieldValue{Pos: pos, Mask: 0, Val: 0xffffffff})
+
+			if r != val {
+				t.Errorf("zero width field write at %d modified register %#x to %#x", pos, val, r)
+			}
+		}
+	}
+}
+
+// fullWidth is converted at run time, as the untyped 0xffffffff constant
+// overflows int on 32-bit targets.
+var fullWidth = ^uint32(0)
+
+func TestFullWidth(t *testing.T) {
+	mask := int(fullWidth)
+
+	// On 32-bit targets (GOARCH=arm) the all-ones mask is represented as
+	// -1, which must still select all register bits.
+	if strconv.IntSize == 32 && mask != -1 {
+		t.Fatalf("int(%#x) = %d, want -1", fullWidth, mask)
+	}
+
+	if uint32(mask) != 0xffffffff {
+		t.Fatalf("uint32(int(%#x)) = %#x", fullWidth, uint32(mask))
+	}
+
+	vals := []uint32{0x00000000, 0x00000001, 0x80000000, 0xa5a5a5a5, 0xffffffff}
+
+	for _, val := range vals {
+		r := uint32(0x12345678)
+		SetN(&r, 0, mask, val)
+
+		if r != val {
+			t.Errorf("SetN(0x12345678, 0, %#x, %#x) = %#x, want %#x", uint32(mask), val, r, val)
+		}
+
+		if got := Get(&r, 0, mask); got != val {
+			t.Errorf("Get(%#x, 0, %#x) = %#x, want %#x", val, uint32(mask), got, val)
+		}
+
+		if got := GetN(&r, 0, 32); got != val {
+			t.Errorf("GetN(%#x, 0, 32) = %#x, want %#x", val, got, val)
+		}
+
+		if got := All(&r, 0, mask); got != (val == 0xffffffff) {
+			t.Errorf("All(%#x, 0, %#x) = %v", val, uint32(mask), got)
+		}
+
+		r = 0x12345678
+		SetTo(&r, 0, mask, val)
+
+		if r != val {
+			t.Errorf("SetTo(0x12345678, 0, %#x, %#x) = %#x, want %#x", uint32(mask), val, r, val)
+		}
+
+		r = 0x12345678
+		SetToN(&r, FieldValue{Pos: 0, Mask: mask, Val: val})
+
+		if r != val {
+			t.Errorf("SetToN(0x12345678, {0, %#x, %#x}) = %#x, want %#x", uint32(mask), val, r, val)
+		}
+
+		r = val
+		ClearN(&r, 0, mask)
+
+		if r != 0 {
+			t.Errorf("ClearN(%#x, 0, %#x) = %#x, want 0", val, uint32(mask), r)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// NXP IOMUX Controller (IOMUXC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package iomuxc implements helpers for pad configuration on the NXP i.MX6
+// IOMUX Controller (IOMUXC), all pin based peripheral drivers require their
+// pads to be configured with this package.
+//
+// Pad mux, control and input select register addresses are found in the IOMUXC
+// Memory Map/Register Definition section of the SoC reference manual, for
+// example UART1_TX_DATA on the i.MX6ULL:
+//
+//	pad := iomuxc.NewPad(0x020e0084, 0x020e0310)
+//	pad.Mode(0)
+//	pad.Ctl(iomuxc.SW_PAD_CTL_PKE | iomuxc.SW_PAD_CTL_DSE_R0_6 | iomuxc.SW_PAD_CTL_SPEED_100MHZ)
+package iomuxc
+
+import (
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const IOMUXC_BASE uint32 = 0x020e0000
+
+// SW_MUX_CTL_PAD_* fields (IOMUXC Memory Map/Register Definition, IMX6ULLRM)
+const (
+	SW_MUX_CTL_SION     = 4
+	SW_MUX_CTL_MUX_MODE = 0
+)
+
+// SW_PAD_CTL_PAD_* fields (IOMUXC Memory Map/Register Definition, IMX6ULLRM)
+const (
+	SW_PAD_CTL_HYS   = 16
+	SW_PAD_CTL_PUS   = 14
+	SW_PAD_CTL_PUE   = 13
+	SW_PAD_CTL_PKE   = 12
+	SW_PAD_CTL_ODE   = 11
+	SW_PAD_CTL_SPEED = 6
+	SW_PAD_CTL_DSE   = 3
+	SW_PAD_CTL_SRE   = 0
+)
+
+// Pad control flags, to be combined for use with Pad.Ctl().
+const (
+	// Hysteresis enable
+	SW_PAD_CTL_HYS_ENABLED = 1 << SW_PAD_CTL_HYS
+
+	// Pull up/down configuration (effective with pull selected)
+	SW_PAD_CTL_PUS_PULL_DOWN_100K = 0b00 << SW_PAD_CTL_PUS
+	SW_PAD_CTL_PUS_PULL_UP_47K    = 0b01 << SW_PAD_CTL_PUS
+	SW_PAD_CTL_PUS_PULL_UP_100K   = 0b10 << SW_PAD_CTL_PUS
+	SW_PAD_CTL_PUS_PULL_UP_22K    = 0b11 << SW_PAD_CTL_PUS
+
+	// Pull (set) or keeper (unset) selection
+	SW_PAD_CTL_PUE_PULL = 1 << SW_PAD_CTL_PUE
+	// Pull/keeper enable
+	SW_PAD_CTL_PKE_ENABLED = 1 << SW_PAD_CTL_PKE
+	// Open drain enable
+	SW_PAD_CTL_ODE_ENABLED = 1 << SW_PAD_CTL_ODE
+
+	// Speed
+	SW_PAD_CTL_SPEED_50MHZ  = 0b00 << SW_PAD_CTL_SPEED
+	SW_PAD_CTL_SPEED_100MHZ = 0b10 << SW_PAD_CTL_SPEED
+	SW_PAD_CTL_SPEED_200MHZ = 0b11 << SW_PAD_CTL_SPEED
+
+	// Drive strength (R0 is 260 Ohm at 3.3V)
+	SW_PAD_CTL_DSE_DISABLED = 0b000 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0       = 0b001 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_2     = 0b010 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_3     = 0b011 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_4     = 0b100 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_5     = 0b101 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_6     = 0b110 << SW_PAD_CTL_DSE
+	SW_PAD_CTL_DSE_R0_7     = 0b111 << SW_PAD_CTL_DSE
+
+	// Fast slew rate
+	SW_PAD_CTL_SRE_FAST = 1 << SW_PAD_CTL_SRE
+)
+
+// Pad represents an IOMUXC pad, identified by its mux and pad control
+// registers.
+type Pad struct {
+	mux *uint32
+	pad *uint32
+}
+
+// NewPad returns a Pad instance for the argument SW_MUX_CTL_PAD_* and
+// SW_PAD_CTL_PAD_* register addresses.
+func NewPad(mux uint32, pad uint32) *Pad {
+	return &Pad{
+		mux: (*uint32)(unsafe.Pointer(uintptr(mux))),
+		pad: (*uint32)(unsafe.Pointer(uintptr(pad))),
+	}
+}
+
+// Mode selects the pad alternate function (ALT0-ALT8).
+func (p *Pad) Mode(mode uint32) {
+	reg.SetN(p.mux, SW_MUX_CTL_MUX_MODE, 0b1111, mode)
+}
+
+// SoftwareInput forces (or releases) the pad input path regardless of the
+// selected alternate function (SION).
+func (p *Pad) SoftwareInput(enable bool) {
+	if enable {
+		reg.Set(p.mux, SW_MUX_CTL_SION)
+	} else {
+		reg.Clear(p.mux, SW_MUX_CTL_SION)
+	}
+}
+
+// Ctl sets the pad control register, the value is composed with the
+// SW_PAD_CTL_* flags.
+func (p *Pad) Ctl(ctl uint32) {
+	reg.Write(p.pad, ctl)
+}
+
+// SelectInput configures the daisy chain input select register for
+// peripheral inputs which can be routed to multiple pads.
+func (p *Pad) SelectInput(daisy uint32, val uint32) {
+	reg.Write((*uint32)(unsafe.Pointer(uintptr(daisy))), val)
+}
@@ -0,0 +1,45 @@
+// ARM processor interrupt masking
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+// B1.3.3 Program Status Registers (PSRs), ARMv7-AR ARM
+const CPSR_I = 7
+
+// defined in irq.s
+func read_cpsr() uint32
+func cpsid()
+func cpsie()
+
+// DisableInterrupts masks IRQs at the processor (CPSR.I), returning whether
+// they were previously enabled.
+func DisableInterrupts() (enabled bool) {
+	enabled = read_cpsr()&(1<<CPSR_I) == 0
+	cpsid()
+	return
+}
+
+// EnableInterrupts unmasks IRQs at the processor (CPSR.I).
+func EnableInterrupts() {
+	cpsie()
+}
+
+// WithInterruptsDisabled executes the argument function with IRQs masked at
+// the processor, making register read-modify-write sequences atomic with
+// respect to interrupt handlers. The previous mask is restored on return,
+// therefore nested invocations do not re-enable IRQs prematurely.
+func WithInterruptsDisabled(fn func()) {
+	if DisableInterrupts() {
+		defer cpsie()
+	}
+
+	fn()
+}
@@ -0,0 +1,25 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func read_cpsr() uint32
+TEXT ·read_cpsr(SB),$0
+	WORD	$0xe10f0000 // mrs r0, cpsr
+
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func cpsid()
+TEXT ·cpsid(SB),$0
+	WORD	$0xf10c0080 // cpsid i
+	RET
+
+// func cpsie()
+TEXT ·cpsie(SB),$0
+	WORD	$0xf1080080 // cpsie i
+	RET
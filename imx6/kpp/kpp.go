@@ -0,0 +1,307 @@
+// NXP Keypad Port (KPP) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package kpp implements a driver for the NXP i.MX6 Keypad Port (KPP),
+// scanning a key matrix of up to 8 rows and 8 columns.
+//
+// The KPP row and column pads must be configured (see package iomuxc) before
+// use, with pull-ups enabled on rows, for example for a 4x4 keypad:
+//
+//	kpp.KPP.Init(4, 4)
+//	events := kpp.KPP.Start(20 * time.Millisecond)
+//
+//	for ev := range events {
+//		// handle ev.Key, ev.Pressed
+//	}
+package kpp
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// KPP Memory Map/Register Definition, IMX6ULLRM
+const (
+	KPP_BASE uint32 = 0x020b8000
+
+	KPP_KPCR     = 0x00
+	KPP_KPCR_KCO = 8
+	KPP_KPCR_KRE = 0
+
+	KPP_KPSR      = 0x02
+	KPP_KPSR_KRIE = 9
+	KPP_KPSR_KDIE = 8
+	KPP_KPSR_KRSS = 3
+	KPP_KPSR_KDSC = 2
+	KPP_KPSR_KPKR = 1
+	KPP_KPSR_KPKD = 0
+
+	KPP_KDDR      = 0x04
+	KPP_KDDR_KCDD = 8
+	KPP_KDDR_KRDD = 0
+
+	KPP_KPDR     = 0x06
+	KPP_KPDR_KCD = 8
+	KPP_KPDR_KRD = 0
+
+	MAX_ROWS = 8
+	MAX_COLS = 8
+
+	// column settling time during scans
+	SCAN_DELAY_US = 5
+)
+
+// Key represents a key matrix position.
+type Key struct {
+	Row int
+	Col int
+}
+
+// KeyEvent represents a debounced key press or release.
+type KeyEvent struct {
+	Key     Key
+	Pressed bool
+}
+
+type kpp struct {
+	sync.Mutex
+
+	kpcr *uint16
+	kpsr *uint16
+	kddr *uint16
+	kpdr *uint16
+
+	rows int
+	cols int
+
+	// event request identifier
+	events uint64
+}
+
+var KPP = &kpp{
+	kpcr: (*uint16)(unsafe.Pointer(uintptr(KPP_BASE + KPP_KPCR))),
+	kpsr: (*uint16)(unsafe.Pointer(uintptr(KPP_BASE + KPP_KPSR))),
+	kddr: (*uint16)(unsafe.Pointer(uintptr(KPP_BASE + KPP_KDDR))),
+	kpdr: (*uint16)(unsafe.Pointer(uintptr(KPP_BASE + KPP_KPDR))),
+}
+
+// Init configures the KPP for a matrix of the argument number of rows
+// (inputs) and columns (open-drain outputs), starting from KPP_ROW0 and
+// KPP_COL0.
+func (hw *kpp) Init(rows int, cols int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if rows <= 0 || rows > MAX_ROWS || cols <= 0 || cols > MAX_COLS {
+		return errors.New("invalid matrix size")
+	}
+
+	if err = imx6.EnableClock(5, imx6.CCM_CCGR5_KPP, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	hw.rows = rows
+	hw.cols = cols
+
+	rowMask := uint16(1<<uint(rows) - 1)
+	colMask := uint16(1<<uint(cols) - 1)
+
+	// enable rows, columns as open-drain
+	reg.Write16(hw.kpcr, colMask<<KPP_KPCR_KCO|rowMask<<KPP_KPCR_KRE)
+
+	// drive all columns low, to detect any key press
+	reg.Write16(hw.kpdr, 0)
+
+	// columns as outputs, rows as inputs
+	reg.Write16(hw.kddr, colMask<<KPP_KDDR_KCDD)
+
+	hw.clearStatus()
+
+	return
+}
+
+// clearStatus clears the key press and release flags and synchronizers.
+func (hw *kpp) clearStatus() {
+	reg.Write16(hw.kpsr, 1<<KPP_KPSR_KPKD|1<<KPP_KPSR_KPKR|1<<KPP_KPSR_KDSC|1<<KPP_KPSR_KRSS)
+}
+
+func (hw *kpp) scan() (keys []Key) {
+	colMask := uint16(1<<uint(hw.cols) - 1)
+
+	for c := 0; c < hw.cols; c++ {
+		// drive only the scanned column low
+		reg.Write16(hw.kpdr, (colMask&^(1<<uint(c)))<<KPP_KPDR_KCD)
+		imx6.DelayUS(SCAN_DELAY_US)
+
+		rows := reg.Read16(hw.kpdr)
+
+		for r := 0; r < hw.rows; r++ {
+			if rows&(1<<uint(r)) == 0 {
+				keys = append(keys, Key{Row: r, Col: c})
+			}
+		}
+	}
+
+	// restore all columns low, to detect further key presses
+	reg.Write16(hw.kpdr, 0)
+
+	return
+}
+
+// Scan returns the currently pressed keys, without debouncing nor ghosting
+// detection.
+func (hw *kpp) Scan() (keys []Key) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.scan()
+}
+
+// ghosted returns whether the argument set of pressed keys can include
+// ghost keys, which is the case when a key shares both its row and column
+// with other pressed keys (matrices without diodes).
+func ghosted(keys []Key) bool {
+	var rows [MAX_ROWS]int
+	var cols [MAX_COLS]int
+
+	for _, k := range keys {
+		rows[k.Row]++
+		cols[k.Col]++
+	}
+
+	for _, k := range keys {
+		if rows[k.Row] > 1 && cols[k.Col] > 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start begins monitoring the key matrix, returning a channel which receives
+// key press and release events, a key state change is reported only once
+// stable for the argument debounce duration. Key combinations which can
+// include ghost keys are ignored until resolved. Any previous monitoring is
+// stopped, and its channel closed.
+//
+// The matrix is scanned from a goroutine, which only polls the KPP key press
+// status flag (KPSR KPKD) while no key is pressed.
+func (hw *kpp) Start(debounce time.Duration) <-chan KeyEvent {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.events += 1
+	id := hw.events
+
+	ch := make(chan KeyEvent, 16)
+
+	hw.clearStatus()
+
+	go func() {
+		defer close(ch)
+
+		state := make(map[Key]bool)
+		var pending map[Key]bool
+		var since time.Time
+
+		for {
+			runtime.Gosched()
+
+			hw.Lock()
+
+			if hw.events != id {
+				hw.Unlock()
+				return
+			}
+
+			if len(state) == 0 && pending == nil && reg.Get16(hw.kpsr, KPP_KPSR_KPKD, 0b1) == 0 {
+				hw.Unlock()
+				continue
+			}
+
+			keys := hw.scan()
+
+			if len(keys) == 0 {
+				hw.clearStatus()
+			}
+
+			hw.Unlock()
+
+			if ghosted(keys) {
+				continue
+			}
+
+			cur := make(map[Key]bool)
+
+			for _, k := range keys {
+				cur[k] = true
+			}
+
+			if !sameKeys(cur, pending) {
+				pending = cur
+				since = time.Now()
+				continue
+			}
+
+			if time.Since(since) < debounce {
+				continue
+			}
+
+			for k := range state {
+				if !cur[k] {
+					ch <- KeyEvent{Key: k, Pressed: false}
+				}
+			}
+
+			for k := range cur {
+				if !state[k] {
+					ch <- KeyEvent{Key: k, Pressed: true}
+				}
+			}
+
+			state = cur
+
+			if len(state) == 0 {
+				pending = nil
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Stop ends monitoring started with Start(), closing its channel.
+func (hw *kpp) Stop() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.events += 1
+}
+
+func sameKeys(a map[Key]bool, b map[Key]bool) bool {
+	if b == nil || len(a) != len(b) {
+		return false
+	}
+
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,127 @@
+// NXP Enhanced LCD Interface (eLCDIF) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package lcdif
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// Framebuffer represents a framebuffer, implementing draw.Image, for use with
+// the standard image/draw package:
+//
+//	fb, _ := lcdif.LCDIF1.NewFramebuffer(buf)
+//	draw.Draw(fb, fb.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+//	fb.Flush()
+type Framebuffer struct {
+	// pixel memory
+	Pix []byte
+
+	hw     *lcdif
+	width  int
+	height int
+	format int
+}
+
+// NewFramebuffer returns a Framebuffer, over the argument buffer, matching
+// the video mode and pixel format set with Init(). The buffer must be
+// allocated outside the Go runtime heap (see package dma) and is set as the
+// scanned out buffer (see SetFramebuffer()) on each Flush().
+func (hw *lcdif) NewFramebuffer(buf []byte) (fb *Framebuffer, err error) {
+	mode, format := hw.Mode()
+
+	size := mode.Width * mode.Height * BytesPerPixel(format)
+
+	if size == 0 {
+		return nil, errors.New("controller not initialized")
+	}
+
+	if len(buf) < size {
+		return nil, errors.New("invalid framebuffer size")
+	}
+
+	return &Framebuffer{
+		Pix:    buf[0:size],
+		hw:     hw,
+		width:  mode.Width,
+		height: mode.Height,
+		format: format,
+	}, nil
+}
+
+// ColorModel returns the framebuffer color model.
+func (fb *Framebuffer) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the framebuffer dimensions.
+func (fb *Framebuffer) Bounds() image.Rectangle {
+	return image.Rect(0, 0, fb.width, fb.height)
+}
+
+// At returns the color of the pixel at (x, y).
+func (fb *Framebuffer) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(fb.Bounds())) {
+		return color.RGBA{}
+	}
+
+	if fb.format == RGB565 {
+		i := (y*fb.width + x) * 2
+		p := uint16(fb.Pix[i]) | uint16(fb.Pix[i+1])<<8
+
+		r := uint8(p>>11) & 0x1f
+		g := uint8(p>>5) & 0x3f
+		b := uint8(p) & 0x1f
+
+		// expand to 8 bits, replicating the most significant bits
+		return color.RGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 0xff}
+	}
+
+	i := (y*fb.width + x) * 4
+
+	// XRGB, little-endian
+	return color.RGBA{fb.Pix[i+2], fb.Pix[i+1], fb.Pix[i], 0xff}
+}
+
+// Set sets the color of the pixel at (x, y), alpha is ignored.
+func (fb *Framebuffer) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(fb.Bounds())) {
+		return
+	}
+
+	p := color.RGBAModel.Convert(c).(color.RGBA)
+
+	if fb.format == RGB565 {
+		i := (y*fb.width + x) * 2
+		v := uint16(p.R>>3)<<11 | uint16(p.G>>2)<<5 | uint16(p.B>>3)
+
+		fb.Pix[i] = uint8(v)
+		fb.Pix[i+1] = uint8(v >> 8)
+
+		return
+	}
+
+	i := (y*fb.width + x) * 4
+
+	fb.Pix[i] = p.B
+	fb.Pix[i+1] = p.G
+	fb.Pix[i+2] = p.R
+	fb.Pix[i+3] = 0
+}
+
+// Flush cleans the data cache for the framebuffer memory, making drawn pixels
+// visible to the display controller DMA, and sets it as the scanned out
+// buffer.
+func (fb *Framebuffer) Flush() error {
+	return fb.hw.SetFramebuffer(fb.Pix)
+}
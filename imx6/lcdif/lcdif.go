@@ -0,0 +1,310 @@
+// NXP Enhanced LCD Interface (eLCDIF) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package lcdif implements a driver for the NXP i.MX6 Enhanced LCD Interface
+// (eLCDIF) controller, in DOTCLK (RGB) mode, scanning out a single
+// framebuffer with RGB565 or RGB888 pixels.
+//
+// The LCD pads must be configured (see package iomuxc) before use, the
+// framebuffer must be allocated outside the Go runtime heap (see package
+// dma), for example:
+//
+//	mode := lcdif.VideoMode{
+//		Width: 480, Height: 272, PixelClock: 9000000,
+//		HSync: 41, HBackPorch: 2, HFrontPorch: 2,
+//		VSync: 10, VBackPorch: 2, VFrontPorch: 2,
+//	}
+//
+//	lcdif.LCDIF1.Init(mode, lcdif.RGB565)
+//	fb, _, _ := dma.Alloc(mode.Width*mode.Height*2, 64)
+//	lcdif.LCDIF1.SetFramebuffer(fb)
+package lcdif
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// eLCDIF Memory Map/Register Definition, IMX6ULLRM
+const (
+	LCDIF1_BASE uint32 = 0x021c8000
+
+	LCDIF_CTRL                   = 0x00
+	LCDIF_CTRL_SFTRST            = 31
+	LCDIF_CTRL_CLKGATE           = 30
+	LCDIF_CTRL_BYPASS_COUNT      = 19
+	LCDIF_CTRL_DOTCLK_MODE       = 17
+	LCDIF_CTRL_LCD_DATABUS_WIDTH = 10
+	LCDIF_CTRL_WORD_LENGTH       = 8
+	LCDIF_CTRL_MASTER            = 5
+	LCDIF_CTRL_RUN               = 0
+
+	LCDIF_CTRL1                     = 0x10
+	LCDIF_CTRL1_BYTE_PACKING_FORMAT = 16
+
+	LCDIF_TRANSFER_COUNT         = 0x30
+	LCDIF_TRANSFER_COUNT_V_COUNT = 16
+	LCDIF_TRANSFER_COUNT_H_COUNT = 0
+
+	LCDIF_CUR_BUF  = 0x40
+	LCDIF_NEXT_BUF = 0x50
+
+	LCDIF_VDCTRL0                        = 0x70
+	LCDIF_VDCTRL0_ENABLE_PRESENT         = 28
+	LCDIF_VDCTRL0_VSYNC_POL              = 27
+	LCDIF_VDCTRL0_HSYNC_POL              = 26
+	LCDIF_VDCTRL0_DOTCLK_POL             = 25
+	LCDIF_VDCTRL0_ENABLE_POL             = 24
+	LCDIF_VDCTRL0_VSYNC_PERIOD_UNIT      = 21
+	LCDIF_VDCTRL0_VSYNC_PULSE_WIDTH_UNIT = 20
+	LCDIF_VDCTRL0_VSYNC_PULSE_WIDTH      = 0
+
+	LCDIF_VDCTRL1 = 0x80
+
+	LCDIF_VDCTRL2                   = 0x90
+	LCDIF_VDCTRL2_HSYNC_PULSE_WIDTH = 18
+	LCDIF_VDCTRL2_HSYNC_PERIOD      = 0
+
+	LCDIF_VDCTRL3                     = 0xa0
+	LCDIF_VDCTRL3_HORIZONTAL_WAIT_CNT = 16
+	LCDIF_VDCTRL3_VERTICAL_WAIT_CNT   = 0
+
+	LCDIF_VDCTRL4                         = 0xb0
+	LCDIF_VDCTRL4_SYNC_SIGNALS_ON         = 18
+	LCDIF_VDCTRL4_DOTCLK_H_VALID_DATA_CNT = 0
+)
+
+// Pixel formats
+const (
+	// 16 bits per pixel, 16-bit data bus
+	RGB565 = iota
+	// 24 bits per pixel (unpacked, in 32-bit words), 24-bit data bus
+	RGB888
+)
+
+// Timeout is the maximum duration for controller reset.
+var Timeout = 10 * time.Millisecond
+
+// VideoMode represents the display timings, horizontal values are expressed
+// in pixel clock cycles and vertical values in lines.
+type VideoMode struct {
+	Width  int
+	Height int
+
+	HSync       int
+	HBackPorch  int
+	HFrontPorch int
+
+	VSync       int
+	VBackPorch  int
+	VFrontPorch int
+
+	// pixel clock (Hz)
+	PixelClock uint32
+
+	// signal polarities (active high when true)
+	HSyncHigh  bool
+	VSyncHigh  bool
+	EnableHigh bool
+	// data driven on the pixel clock rising edge when true
+	ClockRising bool
+}
+
+// BytesPerPixel returns the framebuffer pixel size for the argument format.
+func BytesPerPixel(format int) int {
+	if format == RGB565 {
+		return 2
+	}
+
+	return 4
+}
+
+type lcdif struct {
+	sync.Mutex
+
+	ctrl    *uint32
+	ctrl1   *uint32
+	count   *uint32
+	curBuf  *uint32
+	nextBuf *uint32
+	vdctrl0 *uint32
+	vdctrl1 *uint32
+	vdctrl2 *uint32
+	vdctrl3 *uint32
+	vdctrl4 *uint32
+
+	mode   VideoMode
+	format int
+}
+
+func newLCDIF(base uint32) *lcdif {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &lcdif{
+		ctrl:    r(LCDIF_CTRL),
+		ctrl1:   r(LCDIF_CTRL1),
+		count:   r(LCDIF_TRANSFER_COUNT),
+		curBuf:  r(LCDIF_CUR_BUF),
+		nextBuf: r(LCDIF_NEXT_BUF),
+		vdctrl0: r(LCDIF_VDCTRL0),
+		vdctrl1: r(LCDIF_VDCTRL1),
+		vdctrl2: r(LCDIF_VDCTRL2),
+		vdctrl3: r(LCDIF_VDCTRL3),
+		vdctrl4: r(LCDIF_VDCTRL4),
+	}
+}
+
+var LCDIF1 = newLCDIF(LCDIF1_BASE)
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Init configures the controller, and its pixel clock (see
+// imx6.SetLCDIFClock()), for the argument video mode and pixel format. The
+// display is started by SetFramebuffer().
+func (hw *lcdif) Init(mode VideoMode, format int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if mode.Width <= 0 || mode.Width > 0xffff || mode.Height <= 0 || mode.Height > 0xffff {
+		return errors.New("invalid resolution")
+	}
+
+	if mode.HSync <= 0 || mode.VSync <= 0 || mode.HBackPorch < 0 || mode.HFrontPorch < 0 ||
+		mode.VBackPorch < 0 || mode.VFrontPorch < 0 {
+		return errors.New("invalid timings")
+	}
+
+	var wordLength, busWidth, packing uint32
+
+	switch format {
+	case RGB565:
+		wordLength, busWidth, packing = 0b00, 0b00, 0xf
+	case RGB888:
+		wordLength, busWidth, packing = 0b11, 0b11, 0x7
+	default:
+		return errors.New("invalid pixel format")
+	}
+
+	imx6.EnableClock(2, imx6.CCM_CCGR2_LCD, imx6.CCGR_ON)
+	imx6.EnableClock(3, imx6.CCM_CCGR3_LCDIF1_PIX, imx6.CCGR_ON)
+
+	if _, err = imx6.SetLCDIFClock(mode.PixelClock); err != nil {
+		return
+	}
+
+	// soft reset
+	reg.Set(hw.ctrl, LCDIF_CTRL_SFTRST)
+
+	if !reg.WaitFor(Timeout, hw.ctrl, LCDIF_CTRL_CLKGATE, 0b1, 1) {
+		return errors.New("reset timeout")
+	}
+
+	reg.Clear(hw.ctrl, LCDIF_CTRL_SFTRST)
+	reg.Clear(hw.ctrl, LCDIF_CTRL_CLKGATE)
+
+	reg.Write(hw.ctrl, 1<<LCDIF_CTRL_BYPASS_COUNT|
+		1<<LCDIF_CTRL_DOTCLK_MODE|
+		busWidth<<LCDIF_CTRL_LCD_DATABUS_WIDTH|
+		wordLength<<LCDIF_CTRL_WORD_LENGTH|
+		1<<LCDIF_CTRL_MASTER)
+
+	reg.SetN(hw.ctrl1, LCDIF_CTRL1_BYTE_PACKING_FORMAT, 0xf, packing)
+
+	reg.Write(hw.count, uint32(mode.Height)<<LCDIF_TRANSFER_COUNT_V_COUNT|
+		uint32(mode.Width)<<LCDIF_TRANSFER_COUNT_H_COUNT)
+
+	// vertical timings in lines, data enable signal present
+	reg.Write(hw.vdctrl0, 1<<LCDIF_VDCTRL0_ENABLE_PRESENT|
+		b2u(mode.VSyncHigh)<<LCDIF_VDCTRL0_VSYNC_POL|
+		b2u(mode.HSyncHigh)<<LCDIF_VDCTRL0_HSYNC_POL|
+		b2u(mode.ClockRising)<<LCDIF_VDCTRL0_DOTCLK_POL|
+		b2u(mode.EnableHigh)<<LCDIF_VDCTRL0_ENABLE_POL|
+		1<<LCDIF_VDCTRL0_VSYNC_PERIOD_UNIT|
+		1<<LCDIF_VDCTRL0_VSYNC_PULSE_WIDTH_UNIT|
+		uint32(mode.VSync)<<LCDIF_VDCTRL0_VSYNC_PULSE_WIDTH)
+
+	reg.Write(hw.vdctrl1, uint32(mode.VSync+mode.VBackPorch+mode.Height+mode.VFrontPorch))
+
+	reg.Write(hw.vdctrl2, uint32(mode.HSync)<<LCDIF_VDCTRL2_HSYNC_PULSE_WIDTH|
+		uint32(mode.HSync+mode.HBackPorch+mode.Width+mode.HFrontPorch)<<LCDIF_VDCTRL2_HSYNC_PERIOD)
+
+	reg.Write(hw.vdctrl3, uint32(mode.HSync+mode.HBackPorch)<<LCDIF_VDCTRL3_HORIZONTAL_WAIT_CNT|
+		uint32(mode.VSync+mode.VBackPorch)<<LCDIF_VDCTRL3_VERTICAL_WAIT_CNT)
+
+	reg.Write(hw.vdctrl4, 1<<LCDIF_VDCTRL4_SYNC_SIGNALS_ON|
+		uint32(mode.Width)<<LCDIF_VDCTRL4_DOTCLK_H_VALID_DATA_CNT)
+
+	hw.mode = mode
+	hw.format = format
+
+	return
+}
+
+// Mode returns the video mode and pixel format set with Init().
+func (hw *lcdif) Mode() (mode VideoMode, format int) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.mode, hw.format
+}
+
+// SetFramebuffer cleans the data cache for the argument framebuffer, which
+// must hold at least one full frame, and sets it as the scanned out buffer,
+// starting the display if not already running. Changes are applied from the
+// next frame.
+func (hw *lcdif) SetFramebuffer(fb []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	size := hw.mode.Width * hw.mode.Height * BytesPerPixel(hw.format)
+
+	if size == 0 {
+		return errors.New("controller not initialized")
+	}
+
+	if len(fb) < size {
+		return errors.New("invalid framebuffer size")
+	}
+
+	addr := uintptr(unsafe.Pointer(&fb[0]))
+	cache.CleanRange(addr, uintptr(size))
+
+	reg.Write(hw.nextBuf, uint32(addr))
+
+	if reg.Get(hw.ctrl, LCDIF_CTRL_RUN, 0b1) == 0 {
+		reg.Write(hw.curBuf, uint32(addr))
+		reg.Set(hw.ctrl, LCDIF_CTRL_RUN)
+	}
+
+	return
+}
+
+// Disable stops the display.
+func (hw *lcdif) Disable() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	reg.Clear(hw.ctrl, LCDIF_CTRL_RUN)
+}
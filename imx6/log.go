@@ -0,0 +1,47 @@
+// NXP i.MX6 package logging
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"log"
+	"sync"
+)
+
+var (
+	logMutex sync.Mutex
+	logger   *log.Logger
+	logSet   bool
+)
+
+// SetLogger sets the logger used for package messages (e.g. ARM core
+// frequency and operating point changes), which by default are written to
+// the standard logger. A nil logger suppresses all package messages.
+func SetLogger(l *log.Logger) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	logger = l
+	logSet = true
+}
+
+func logf(format string, v ...interface{}) {
+	logMutex.Lock()
+	l, set := logger, logSet
+	logMutex.Unlock()
+
+	switch {
+	case !set:
+		log.Printf(format, v...)
+	case l != nil:
+		l.Printf(format, v...)
+	}
+}
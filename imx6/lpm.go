@@ -0,0 +1,282 @@
+// NXP i.MX6 low power modes
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inversepath/tamago/imx6/gic"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// CCM Low Power Control Register, IMX6ULLRM
+	CCM_CLPCR                    uint32 = 0x020c4054
+	CCM_CLPCR_ARM_CLK_DIS_ON_LPM        = 5
+	CCM_CLPCR_LPM                       = 0
+
+	// low power modes entered on WFI (CLPCR LPM field)
+	LPM_RUN  = 0b00
+	LPM_WAIT = 0b01
+	LPM_STOP = 0b10
+
+	// GPC Interrupt Mask Registers (GPC_IMR1-4), IMX6ULLRM
+	GPC_IMR1  uint32 = 0x020dc008
+	GPC_IMR_N        = 4
+
+	// first GPC maskable interrupt (GIC shared peripheral interrupt 0)
+	GPC_IRQ_BASE = 32
+
+	// idle wake-up timer interrupt priority
+	IDLE_PRIORITY = 0x80
+)
+
+// A Gosched() call returning within idleThreshold is taken as an indication
+// that no other goroutine was runnable (see Idle()).
+const idleThreshold = 10 * time.Microsecond
+
+var (
+	idleMutex   sync.Mutex
+	idleRunning bool
+)
+
+var clpcr = reg.New(CCM_CLPCR)
+
+// defined in lpm.s
+func wfi()
+
+// SetLowPowerMode selects the low power mode (LPM_RUN, LPM_WAIT) entered by
+// the SoC on Wait().
+//
+// In LPM_RUN only the ARM core clock is gated while waiting, in LPM_WAIT the
+// ARM core clock is disabled and the CCM can further gate clocks of idle
+// peripherals.
+func SetLowPowerMode(mode uint32) (err error) {
+	switch mode {
+	case LPM_RUN:
+		clpcr.Clear(CCM_CLPCR_ARM_CLK_DIS_ON_LPM)
+	case LPM_WAIT:
+		clpcr.Set(CCM_CLPCR_ARM_CLK_DIS_ON_LPM)
+	default:
+		return errors.New("unsupported low power mode")
+	}
+
+	clpcr.SetN(CCM_CLPCR_LPM, 0b11, mode)
+
+	return
+}
+
+// Wait suspends the ARM core, with a Wait For Interrupt (WFI) instruction,
+// until an interrupt or debug event is signaled. The SoC enters the low power
+// mode set with SetLowPowerMode() (LPM_RUN by default) while waiting.
+//
+// Wake-up happens on any pending interrupt regardless of the CPSR interrupt
+// mask, as IRQs are masked under tamago an event asserted just before the WFI
+// instruction remains pending and wakes the core immediately, therefore no
+// wake-up event can be lost between checking for work and calling Wait. The
+// interrupt source must however be enabled at the interrupt controller (and,
+// for LPM_WAIT, unmasked in the GPC) for it to be signaled to the core.
+//
+// The tamago runtime scheduler does not provide an idle hook, Idle() provides
+// an idle goroutine calling Wait, alternatively applications can call it
+// from their own idle loop having armed a wake-up source beforehand.
+func Wait() {
+	wfi()
+}
+
+// Idle starts a goroutine which suspends the ARM core, with Wait(), whenever
+// no other goroutine ran since its previous scheduling, until an interrupt is
+// signaled or the argument period elapses. Subsequent calls have no effect.
+//
+// The tamago runtime scheduler, which lacks an idle hook, never suspends the
+// core by itself: the idle goroutine takes its place by detecting that it is
+// immediately rescheduled, in which case the secure physical timer
+// (CNTP_PPI) is armed as wake-up source and the core waits. The period
+// therefore bounds the latency of goroutines which become runnable without
+// an interrupt (e.g. sleeping or polling ones).
+//
+// The GIC must be initialized beforehand (see package gic), the wake-up timer
+// interrupt is enabled by Idle. The ARM generic timer is required, therefore
+// the i.MX6Q is not supported.
+func Idle(period time.Duration) (err error) {
+	freq := uint64(Frequency())
+
+	if freq == 0 || Family == IMX6Q {
+		return errors.New("generic timer not available")
+	}
+
+	ticks := uint64(period) * freq / uint64(time.Second)
+
+	if period <= 0 || ticks == 0 || ticks > math.MaxInt32 {
+		return errors.New("invalid idle period")
+	}
+
+	if !gic.Enabled() {
+		return errors.New("GIC not initialized")
+	}
+
+	idleMutex.Lock()
+	defer idleMutex.Unlock()
+
+	if idleRunning {
+		return
+	}
+
+	if err = gic.EnableInterrupt(CNTP_PPI, IDLE_PRIORITY); err != nil {
+		return
+	}
+
+	idleRunning = true
+
+	go idle(int32(ticks))
+
+	return
+}
+
+func idle(ticks int32) {
+	for {
+		start := nanotime()
+		runtime.Gosched()
+
+		if time.Duration(nanotime()-start) > idleThreshold {
+			// other goroutines ran
+			continue
+		}
+
+		// Interrupts are masked in the CPSR, an interrupt asserted
+		// after the check above remains pending and causes WFI to
+		// return immediately (see Wait()).
+		write_cntp_ctl(0)
+		write_cntp_tval(ticks)
+		// CNTP_CTL shares the CNTV_CTL layout
+		write_cntp_ctl(CNTV_CTL_ENABLE)
+
+		Wait()
+
+		// disable the timer to deassert its interrupt
+		write_cntp_ctl(0)
+	}
+}
+
+// EnterStop suspends the SoC in STOP mode (Deep Sleep Mode), with all clocks
+// gated and the analog PLLs powered down, until one of the argument interrupts
+// (GIC interrupt IDs of shared peripheral interrupts, e.g. SNVS RTC or GPIO)
+// is asserted. At least one wake-up source must be given, all other
+// interrupts are masked in the GPC while stopped.
+//
+// The wake-up sources must also be enabled at the GIC distributor (see
+// package gic) for the core to exit WFI, and their peripheral must remain
+// clocked in STOP mode (CCGR_ON rather than CCGR_RUN).
+//
+// On wake-up the PLLs, CCGR registers, GPC interrupt masks and low power
+// mode configuration active before the call are restored, PLLs which were
+// locked are waited for (see PLLLockTimeout) before peripheral clocks are
+// re-enabled.
+func EnterStop(wakeSources ...int) (err error) {
+	if len(wakeSources) == 0 {
+		return errors.New("no wake-up source")
+	}
+
+	var imr [GPC_IMR_N]uint32
+
+	for i := range imr {
+		imr[i] = 0xffffffff
+	}
+
+	for _, id := range wakeSources {
+		n := id - GPC_IRQ_BASE
+
+		if n < 0 || n >= GPC_IMR_N*32 {
+			return errors.New("invalid wake-up source")
+		}
+
+		imr[n/32] &^= 1 << uint(n%32)
+	}
+
+	powerMutex.Lock()
+	defer powerMutex.Unlock()
+
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	var prevIMR [GPC_IMR_N]uint32
+	var ccgr [7]uint32
+
+	plls := []*reg.Register{pllARM}
+
+	for pll := PLL2_SYS; pll <= PLL7_USB2; pll++ {
+		r, _ := pllRegister(pll)
+		plls = append(plls, r)
+	}
+
+	pllState := make([]uint32, len(plls))
+
+	for i, r := range plls {
+		pllState[i] = r.Read()
+	}
+
+	for i := range ccgr {
+		r, _ := ccgrRegister(i, 0)
+		ccgr[i] = reg.Read(r)
+	}
+
+	prevCLPCR := clpcr.Read()
+
+	for i := range imr {
+		r := reg.New(GPC_IMR1 + uint32(4*i))
+		prevIMR[i] = r.Read()
+		r.Write(imr[i])
+	}
+
+	clpcr.Set(CCM_CLPCR_ARM_CLK_DIS_ON_LPM)
+	clpcr.SetN(CCM_CLPCR_LPM, 0b11, LPM_STOP)
+
+	wfi()
+
+	// restore PLLs before re-enabling peripheral clocks
+	for i, r := range plls {
+		if r.Read() != pllState[i] {
+			r.Write(pllState[i])
+		}
+
+		if (pllState[i]>>CCM_ANALOG_PLL_LOCK)&1 == 0 {
+			continue
+		}
+
+		if PLLLockTimeout == 0 {
+			r.Wait(CCM_ANALOG_PLL_LOCK, 0b1, 1)
+		} else if !r.WaitFor(PLLLockTimeout, CCM_ANALOG_PLL_LOCK, 0b1, 1) {
+			err = ErrPLLLockTimeout
+		}
+	}
+
+	clpcr.Write(prevCLPCR)
+
+	for i := range ccgr {
+		r, _ := ccgrRegister(i, 0)
+		reg.Write(r, ccgr[i])
+	}
+
+	for i := range prevIMR {
+		reg.New(GPC_IMR1 + uint32(4*i)).Write(prevIMR[i])
+	}
+
+	atomic.StoreUint32(&armFreq, ARMFreq())
+	calibrateDelay()
+
+	return
+}
@@ -0,0 +1,189 @@
+// ARM Memory Management Unit (MMU) configuration
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package mmu implements ARMv7-A short-descriptor translation table
+// configuration, with 1 MB section granularity, to define memory attributes
+// for normal (cacheable) and device memory regions.
+//
+// Init() enables the MMU with an identity (flat) mapping where the argument
+// RAM region is Normal memory while the rest of the address space, including
+// all peripheral registers (e.g. the CCM/PMU region at 0x020c0000), is
+// Device memory:
+//
+//	mmu.Init(0x80000000, 0x20000000)
+//
+// Peripheral register accesses must never be mapped as Normal memory, when
+// data caches are enabled, as writes would otherwise be cached and reordered.
+package mmu
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/mem"
+)
+
+// Memory attributes
+const (
+	// Normal memory, outer and inner write-back write-allocate cacheable
+	NORMAL = iota
+	// Normal memory, non-cacheable
+	NORMAL_NON_CACHEABLE
+	// Shareable device memory
+	DEVICE
+	// Strongly-ordered memory
+	STRONGLY_ORDERED
+)
+
+// Short-descriptor section format (Translation tables, ARM DDI 0406C)
+const (
+	SECTION      = 0b10
+	SECTION_B    = 2
+	SECTION_C    = 3
+	SECTION_XN   = 4
+	SECTION_AP   = 10
+	SECTION_TEX  = 12
+	SECTION_S    = 16
+	SECTION_BASE = 20
+
+	// read/write, any privilege level
+	AP_FULL_ACCESS = 0b11
+
+	SECTION_SIZE = 1 << 20
+	TABLE_SIZE   = 4096 * 4
+	// L1 translation table alignment
+	TABLE_ALIGN = 16 * 1024
+)
+
+var (
+	mutex   sync.Mutex
+	table   *mem.AlignmentBuffer
+	enabled bool
+)
+
+// defined in mmu.s
+func setTTBR0(addr uint32)
+func invalidateTLB()
+func enable()
+
+func descriptor(phys uint32, attr int) (d uint32, err error) {
+	d = phys&^(SECTION_SIZE-1) | SECTION | AP_FULL_ACCESS<<SECTION_AP
+
+	switch attr {
+	case NORMAL:
+		// TEX = 0b001, C = 1, B = 1
+		d |= 0b001<<SECTION_TEX | 1<<SECTION_C | 1<<SECTION_B | 1<<SECTION_S
+	case NORMAL_NON_CACHEABLE:
+		// TEX = 0b001, C = 0, B = 0
+		d |= 0b001<<SECTION_TEX | 1<<SECTION_S
+	case DEVICE:
+		// TEX = 0b000, C = 0, B = 1
+		d |= 1<<SECTION_B | 1<<SECTION_XN
+	case STRONGLY_ORDERED:
+		// TEX = 0b000, C = 0, B = 0
+		d |= 1 << SECTION_XN
+	default:
+		err = errors.New("invalid memory attribute")
+	}
+
+	return
+}
+
+func entries() *[4096]uint32 {
+	return (*[4096]uint32)(unsafe.Pointer(table.Addr))
+}
+
+func set(virt uint32, phys uint32, size uint32, attr int) (err error) {
+	if virt%SECTION_SIZE != 0 || phys%SECTION_SIZE != 0 || size%SECTION_SIZE != 0 {
+		return errors.New("addresses and size must be 1 MB aligned")
+	}
+
+	if size == 0 || uint64(virt)+uint64(size) > 1<<32 || uint64(phys)+uint64(size) > 1<<32 {
+		return errors.New("invalid memory range")
+	}
+
+	t := entries()
+
+	for off := uint64(0); off < uint64(size); off += SECTION_SIZE {
+		d, err := descriptor(phys+uint32(off), attr)
+
+		if err != nil {
+			return err
+		}
+
+		t[(uint64(virt)+off)>>SECTION_BASE] = d
+	}
+
+	return
+}
+
+// Init builds an identity mapped translation table, with the argument RAM
+// region mapped as Normal memory and all the remaining address space as
+// Device memory, and enables the MMU.
+func Init(ramStart uint32, ramSize uint32) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if enabled {
+		return errors.New("MMU already enabled")
+	}
+
+	table = mem.NewAlignmentBuffer(TABLE_SIZE, TABLE_ALIGN)
+
+	// 4096 sections cover the whole 4 GB address space
+	if err = set(0, 0, SECTION_SIZE*2048, DEVICE); err != nil {
+		return
+	}
+
+	if err = set(SECTION_SIZE*2048, SECTION_SIZE*2048, SECTION_SIZE*2048, DEVICE); err != nil {
+		return
+	}
+
+	if err = set(ramStart, ramStart, ramSize, NORMAL); err != nil {
+		return
+	}
+
+	// translation table walks are non-cacheable
+	cache.FlushData()
+
+	setTTBR0(uint32(table.Addr))
+	invalidateTLB()
+	enable()
+
+	enabled = true
+
+	return
+}
+
+// Map sets the memory attributes for a virtual address range, mapped to the
+// argument physical address range, the arguments must be aligned to 1 MB
+// sections. Init() must have been previously called.
+func Map(virt uintptr, phys uintptr, size uintptr, attr int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !enabled {
+		return errors.New("MMU not initialized")
+	}
+
+	if err = set(uint32(virt), uint32(phys), uint32(size), attr); err != nil {
+		return
+	}
+
+	start := table.Addr + (virt>>SECTION_BASE)*4
+	cache.CleanRange(start, (size>>SECTION_BASE)*4)
+
+	invalidateTLB()
+
+	return
+}
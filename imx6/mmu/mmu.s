@@ -0,0 +1,35 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func setTTBR0(addr uint32)
+TEXT ·setTTBR0(SB),$0-4
+	MOVW	addr+0(FP), R0
+	MCR	15, 0, R0, C2, C0, 0		// TTBR0
+	MOVW	$0, R0
+	MCR	15, 0, R0, C2, C0, 2		// TTBCR, use TTBR0 only
+	MOVW	$0x1, R0
+	MCR	15, 0, R0, C3, C0, 0		// DACR, domain 0 client access
+	WORD	$0xf57ff06f			// ISB SY
+	RET
+
+// func invalidateTLB()
+TEXT ·invalidateTLB(SB),$0
+	MOVW	$0, R0
+	MCR	15, 0, R0, C8, C7, 0		// TLBIALL
+	MCR	15, 0, R0, C7, C5, 6		// BPIALL
+	WORD	$0xf57ff04f			// DSB SY
+	WORD	$0xf57ff06f			// ISB SY
+	RET
+
+// func enable()
+TEXT ·enable(SB),$0
+	MRC	15, 0, R0, C1, C0, 0		// SCTLR
+	ORR	$0x1, R0			// enable MMU
+	MCR	15, 0, R0, C1, C0, 0
+	WORD	$0xf57ff06f			// ISB SY
+	RET
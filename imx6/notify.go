@@ -0,0 +1,50 @@
+// NXP i.MX6 clock change notification
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"sync"
+)
+
+var (
+	listenerMutex  sync.Mutex
+	clockListeners []func(oldHz, newHz uint32)
+)
+
+// RegisterClockListener registers a function invoked after each successful
+// ARM core frequency change (see SetARMFreq(), PowerSave() and Resume()),
+// with the previous and new frequencies (in hertz), allowing drivers which
+// depend on it to update their configuration.
+//
+// Listeners are invoked, in registration order, outside of any clock lock
+// and can therefore call clock functions, except functions changing the ARM
+// core frequency from within a listener would notify listeners recursively.
+func RegisterClockListener(fn func(oldHz, newHz uint32)) {
+	listenerMutex.Lock()
+	defer listenerMutex.Unlock()
+
+	clockListeners = append(clockListeners, fn)
+}
+
+func notifyClockListeners(oldHz uint32, newHz uint32) {
+	if oldHz == newHz {
+		return
+	}
+
+	listenerMutex.Lock()
+	listeners := append([]func(oldHz, newHz uint32){}, clockListeners...)
+	listenerMutex.Unlock()
+
+	for _, fn := range listeners {
+		fn(oldHz, newHz)
+	}
+}
@@ -0,0 +1,150 @@
+// NXP On-Chip OTP Controller (OCOTP_CTRL) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package ocotp implements a driver for the NXP i.MX6 On-Chip OTP Controller
+// (OCOTP_CTRL), allowing fuse reads.
+package ocotp
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// The OCOTP clock gate is accessed directly, rather than with
+// imx6.EnableClock(), as package imx6 reads its speed grade fuses through
+// this driver.
+const (
+	// CCM Clock Gating Register 2 (CCM_CCGR2), IMX6ULLRM
+	CCM_CCGR2       uint32 = 0x020c4070
+	CCM_CCGR2_OCOTP        = 12
+)
+
+const (
+	// On-Chip OTP Controller (OCOTP_CTRL) Memory Map, IMX6ULLRM
+	OCOTP_BASE uint32 = 0x021bc000
+
+	HW_OCOTP_CTRL       = OCOTP_BASE + 0x00
+	HW_OCOTP_CTRL_CLR   = OCOTP_BASE + 0x08
+	HW_OCOTP_CTRL_ERROR = 9
+	HW_OCOTP_CTRL_BUSY  = 8
+	HW_OCOTP_CTRL_ADDR  = 0
+
+	HW_OCOTP_READ_CTRL           = OCOTP_BASE + 0x30
+	HW_OCOTP_READ_CTRL_READ_FUSE = 0
+
+	HW_OCOTP_READ_FUSE_DATA = OCOTP_BASE + 0x40
+
+	// Shadow registers start at this offset, each fuse word is mapped
+	// every 0x10 bytes (bank * 8 + word).
+	HW_OCOTP_SHADOW = OCOTP_BASE + 0x400
+
+	WORDS_PER_BANK = 8
+	BANKS          = 16
+)
+
+var mutex sync.Mutex
+
+var (
+	ccgr2    = reg.New(CCM_CCGR2)
+	ctrl     = reg.New(HW_OCOTP_CTRL)
+	ctrlClr  = reg.New(HW_OCOTP_CTRL_CLR)
+	readCtrl = reg.New(HW_OCOTP_READ_CTRL)
+	fuseData = reg.New(HW_OCOTP_READ_FUSE_DATA)
+)
+
+// Shadow returns the shadow register address for a fuse word.
+func Shadow(bank int, word int) uint32 {
+	return HW_OCOTP_SHADOW + uint32((bank*WORDS_PER_BANK+word)*0x10)
+}
+
+// Read returns a fuse word value, read directly from the fuse array (rather
+// than its shadow register), following the OCOTP read protocol
+// (Fuse and Shadow Register Read, IMX6ULLRM).
+//
+// This function cannot be used before runtime initialization with
+// `GOOS=tamago`.
+func Read(bank int, word int) (val uint32, err error) {
+	if bank < 0 || bank >= BANKS || word < 0 || word >= WORDS_PER_BANK {
+		return 0, errors.New("invalid fuse address")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// clock gate always on (CCGR_ON)
+	ccgr2.SetN(CCM_CCGR2_OCOTP, 0b11, 0b11)
+
+	// wait for controller to be idle and clear any previous error
+	ctrl.WaitWhile(HW_OCOTP_CTRL_BUSY, 0b1, 1)
+	ctrlClr.Write(1 << HW_OCOTP_CTRL_ERROR)
+
+	// set fuse address and initiate read
+	ctrl.SetN(HW_OCOTP_CTRL_ADDR, 0x7f, uint32(bank*WORDS_PER_BANK+word))
+	readCtrl.Set(HW_OCOTP_READ_CTRL_READ_FUSE)
+
+	ctrl.WaitWhile(HW_OCOTP_CTRL_BUSY, 0b1, 1)
+
+	if ctrl.Get(HW_OCOTP_CTRL_ERROR, 0b1) == 1 {
+		ctrlClr.Write(1 << HW_OCOTP_CTRL_ERROR)
+		return 0, errors.New("fuse read error")
+	}
+
+	return fuseData.Read(), nil
+}
+
+// UID returns the 64-bit SoC unique ID, stored in fuse bank 0 words 1 and 2
+// (OCOTP_CFG0 and OCOTP_CFG1, IMX6ULLRM).
+func UID() (uid [8]byte, err error) {
+	lo, err := Read(0, 1)
+
+	if err != nil {
+		return
+	}
+
+	hi, err := Read(0, 2)
+
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < 4; i++ {
+		uid[i] = byte(hi >> (24 - 8*i))
+		uid[4+i] = byte(lo >> (24 - 8*i))
+	}
+
+	return
+}
+
+// MAC returns the ENET MAC address, stored in fuse bank 4 words 2 and 3
+// (OCOTP_MAC0 and OCOTP_MAC1, IMX6ULLRM).
+func MAC() (mac net.HardwareAddr, err error) {
+	lo, err := Read(4, 2)
+
+	if err != nil {
+		return
+	}
+
+	hi, err := Read(4, 3)
+
+	if err != nil {
+		return
+	}
+
+	mac = net.HardwareAddr{
+		byte(hi >> 8), byte(hi),
+		byte(lo >> 24), byte(lo >> 16), byte(lo >> 8), byte(lo),
+	}
+
+	return
+}
@@ -0,0 +1,133 @@
+// NXP i.MX6UL ARM core operating points
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// Absolute ARM core voltage limits for custom operating points,
+	// Table 10. Operating Ranges, IMX6ULLCEC.
+	MIN_ARM_UV = 925000
+	MAX_ARM_UV = 1300000
+)
+
+// OperatingPoint represents an ARM core frequency (in Hz), its ARM PLL
+// (DIV_SELECT) and core (ARM_PODF) dividers and the ARM core voltage (in
+// microvolts) used when running at it.
+type OperatingPoint struct {
+	Hz        uint32
+	DivSelect uint32
+	ARMPodf   uint32
+	UV        uint32
+}
+
+// customOperatingPoints, when set, replaces the built-in ARM clock solver.
+var customOperatingPoints []OperatingPoint
+
+// SetOperatingPoints registers a custom operating point table, which
+// SetARMFreq consults instead of computing dividers and voltages from the
+// built-in SoC family operating ranges. The requested frequency is matched to
+// the highest table entry not exceeding it. A nil table restores the built-in
+// behavior.
+//
+// Each entry is validated: its frequency must match its dividers and not
+// exceed the SoC maximum (see MaxARMFreq()), and its voltage must be within
+// MIN_ARM_UV and MAX_ARM_UV, not below the SoC family datasheet minimum for
+// its frequency and a multiple of the 25 mV regulator step.
+func SetOperatingPoints(table []OperatingPoint) (err error) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	if table == nil {
+		customOperatingPoints = nil
+		return
+	}
+
+	ops, err := familyOperatingPoints()
+
+	if err != nil {
+		return
+	}
+
+	max := MaxARMFreq()
+
+	for _, op := range table {
+		if err = validateOperatingPoint(op, ops, max); err != nil {
+			return fmt.Errorf("invalid operating point (%d Hz): %w", op.Hz, err)
+		}
+	}
+
+	t := append([]OperatingPoint{}, table...)
+
+	sort.Slice(t, func(i, j int) bool {
+		return t[i].Hz < t[j].Hz
+	})
+
+	customOperatingPoints = t
+
+	return
+}
+
+// validateOperatingPoint checks a custom operating point against the
+// argument SoC family operating points (see familyOperatingPoints()) and
+// maximum frequency.
+func validateOperatingPoint(op OperatingPoint, ops []operatingPoint, max uint32) error {
+	// p714, 18.7.1 Analog ARM PLL control Register, IMX6ULLRM
+	if op.DivSelect < 54 || op.DivSelect > 108 {
+		return errors.New("invalid DIV_SELECT")
+	}
+
+	if op.ARMPodf > 7 {
+		return errors.New("invalid ARM_PODF")
+	}
+
+	if hz := armDivFreq(op.DivSelect, op.ARMPodf); hz != op.Hz {
+		return fmt.Errorf("dividers result in %d Hz", hz)
+	}
+
+	if op.Hz > max {
+		return fmt.Errorf("%w, exceeds %s speed grade maximum", ErrUnsupportedFrequency, Model())
+	}
+
+	if op.UV < MIN_ARM_UV || op.UV > MAX_ARM_UV {
+		return fmt.Errorf("%w, %d uV out of range", ErrUnsupportedVoltage, op.UV)
+	}
+
+	// p2456, 39.6.4 Digital Regulator Core Register, IMX6ULLRM
+	if op.UV%25000 != 0 {
+		return fmt.Errorf("%w, %d uV is not a multiple of 25 mV", ErrUnsupportedVoltage, op.UV)
+	}
+
+	if min := minVoltage(ops, op.Hz); op.UV < min {
+		return fmt.Errorf("%w, %d uV is below the %s minimum (%d uV)", ErrUnsupportedVoltage, op.UV, Model(), min)
+	}
+
+	return nil
+}
+
+// customOperatingPoint returns the highest custom operating point not
+// exceeding the argument frequency.
+func customOperatingPoint(hz uint32) (op OperatingPoint, err error) {
+	for i := len(customOperatingPoints) - 1; i >= 0; i-- {
+		if customOperatingPoints[i].Hz <= hz {
+			return customOperatingPoints[i], nil
+		}
+	}
+
+	err = fmt.Errorf("%w, %d MHz is below the lowest custom operating point", ErrUnsupportedFrequency, hz/1000000)
+
+	return
+}
@@ -0,0 +1,106 @@
+// NXP i.MX6 peripheral base addresses
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+// I2C and GPIO base addresses, Memory Map, IMX6ULLRM and IMX6DQRM
+const (
+	I2C1_BASE uint32 = 0x021a0000
+	I2C2_BASE uint32 = 0x021a4000
+	I2C3_BASE uint32 = 0x021a8000
+
+	GPIO1_BASE uint32 = 0x0209c000
+	GPIO2_BASE uint32 = 0x020a0000
+	GPIO3_BASE uint32 = 0x020a4000
+	GPIO4_BASE uint32 = 0x020a8000
+	GPIO5_BASE uint32 = 0x020ac000
+)
+
+// PeripheralMap holds the base addresses of peripherals present on the SoC,
+// a zero value indicates a peripheral which is not available on the detected
+// family.
+type PeripheralMap struct {
+	UART1Base uint32
+	UART2Base uint32
+	UART3Base uint32
+	UART4Base uint32
+	UART5Base uint32
+	UART6Base uint32
+	UART7Base uint32
+	UART8Base uint32
+
+	I2C1Base uint32
+	I2C2Base uint32
+	I2C3Base uint32
+
+	GPIO1Base uint32
+	GPIO2Base uint32
+	GPIO3Base uint32
+	GPIO4Base uint32
+	GPIO5Base uint32
+}
+
+// Peripherals holds the peripheral base addresses for the detected SoC
+// family, populated at early runtime initialization so that drivers can
+// consult it when creating their instances.
+var Peripherals PeripheralMap
+
+// Memory Map, IMX6ULLRM
+var peripheralsIMX6UL = PeripheralMap{
+	UART1Base: UART1_BASE,
+	UART2Base: UART2_BASE,
+	UART3Base: UART3_BASE,
+	UART4Base: UART4_BASE,
+	UART5Base: UART5_BASE,
+	UART6Base: UART6_BASE,
+	UART7Base: UART7_BASE,
+	UART8Base: UART8_BASE,
+
+	I2C1Base: I2C1_BASE,
+	I2C2Base: I2C2_BASE,
+	I2C3Base: I2C3_BASE,
+
+	GPIO1Base: GPIO1_BASE,
+	GPIO2Base: GPIO2_BASE,
+	GPIO3Base: GPIO3_BASE,
+	GPIO4Base: GPIO4_BASE,
+	GPIO5Base: GPIO5_BASE,
+}
+
+// Memory Map, IMX6DQRM
+var peripheralsIMX6Q = PeripheralMap{
+	UART1Base: UART1_BASE,
+	UART2Base: UART2_BASE,
+	UART3Base: UART3_BASE,
+	UART4Base: UART4_BASE,
+	UART5Base: UART5_BASE,
+
+	I2C1Base: I2C1_BASE,
+	I2C2Base: I2C2_BASE,
+	I2C3Base: I2C3_BASE,
+
+	GPIO1Base: GPIO1_BASE,
+	GPIO2Base: GPIO2_BASE,
+	GPIO3Base: GPIO3_BASE,
+	GPIO4Base: GPIO4_BASE,
+	GPIO5Base: GPIO5_BASE,
+}
+
+// initPeripherals is invoked by hwinit, therefore it must not perform any
+// heap allocation.
+func initPeripherals() {
+	switch Family {
+	case IMX6UL, IMX6ULL, IMX6ULZ:
+		Peripherals = peripheralsIMX6UL
+	default:
+		Peripherals = peripheralsIMX6Q
+	}
+}
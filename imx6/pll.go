@@ -0,0 +1,300 @@
+// NXP i.MX6UL analog PLL control
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// Analog PLLs
+const (
+	PLL2_SYS = 2 + iota
+	PLL3_USB1
+	PLL4_AUDIO
+	PLL5_VIDEO
+	PLL6_ENET
+	PLL7_USB2
+)
+
+// CCM Analog Memory Map/Register Definition, IMX6ULLRM
+const (
+	CCM_ANALOG_PLL_USB2  uint32 = 0x020c8020
+	CCM_ANALOG_PLL_AUDIO uint32 = 0x020c8070
+	CCM_ANALOG_PLL_VIDEO uint32 = 0x020c80a0
+	CCM_ANALOG_PLL_ENET  uint32 = 0x020c80e0
+
+	// common PLL control fields
+	CCM_ANALOG_PLL_LOCK           = 31
+	CCM_ANALOG_PLL_BYPASS         = 16
+	CCM_ANALOG_PLL_BYPASS_CLK_SRC = 14
+	CCM_ANALOG_PLL_ENABLE         = 13
+	// PLL2, PLL4, PLL5, PLL6 (active high power down)
+	CCM_ANALOG_PLL_POWERDOWN = 12
+	// PLL3, PLL7 (active high power up)
+	CCM_ANALOG_PLL_USB_POWER       = 12
+	CCM_ANALOG_PLL_USB_EN_USB_CLKS = 6
+
+	// PLL4, PLL5 fractional loop divider
+	CCM_ANALOG_PLL_AV_POST_DIV_SELECT = 19
+	CCM_ANALOG_PLL_AV_DIV_SELECT      = 0
+	CCM_ANALOG_PLL_AV_NUM             = 0x10
+	CCM_ANALOG_PLL_AV_DENOM           = 0x20
+
+	// PLL6 fields
+	CCM_ANALOG_PLL_ENET_ENET2_125M_EN    = 20
+	CCM_ANALOG_PLL_ENET_ENET1_125M_EN    = 13
+	CCM_ANALOG_PLL_ENET_ENET2_DIV_SELECT = 2
+	CCM_ANALOG_PLL_ENET_ENET1_DIV_SELECT = 0
+)
+
+func pllRegister(pll int) (r *reg.Register, err error) {
+	switch pll {
+	case PLL2_SYS:
+		r = pllSys
+	case PLL3_USB1:
+		r = pllUSB
+	case PLL4_AUDIO:
+		r = reg.New(CCM_ANALOG_PLL_AUDIO)
+	case PLL5_VIDEO:
+		r = reg.New(CCM_ANALOG_PLL_VIDEO)
+	case PLL6_ENET:
+		r = reg.New(CCM_ANALOG_PLL_ENET)
+	case PLL7_USB2:
+		r = reg.New(CCM_ANALOG_PLL_USB2)
+	default:
+		err = errors.New("invalid PLL")
+	}
+
+	return
+}
+
+// configurePLL follows the same sequence used for the ARM PLL: the PLL is
+// bypassed, configured through the argument function, powered up and
+// unbypassed once locked.
+func configurePLL(pll int, fn func(r *reg.Register)) (err error) {
+	r, err := pllRegister(pll)
+
+	if err != nil {
+		return
+	}
+
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	if fn == nil && r.All(CCM_ANALOG_PLL_LOCK, 0b1) && !r.Any(CCM_ANALOG_PLL_BYPASS, 0b1) {
+		// already running, avoid glitching derived clocks
+		enableOutputs(pll, r)
+		return
+	}
+
+	// bypass from the main oscillator
+	r.SetN(CCM_ANALOG_PLL_BYPASS_CLK_SRC, 0b11, 0)
+	r.Set(CCM_ANALOG_PLL_BYPASS)
+
+	if fn != nil {
+		fn(r)
+	}
+
+	switch pll {
+	case PLL3_USB1, PLL7_USB2:
+		r.Set(CCM_ANALOG_PLL_USB_POWER)
+	default:
+		r.Clear(CCM_ANALOG_PLL_POWERDOWN)
+	}
+
+	if PLLLockTimeout == 0 {
+		r.Wait(CCM_ANALOG_PLL_LOCK, 0b1, 1)
+	} else if !r.WaitFor(PLLLockTimeout, CCM_ANALOG_PLL_LOCK, 0b1, 1) {
+		return fmt.Errorf("%w, PLL left in bypass", ErrPLLLockTimeout)
+	}
+
+	r.Clear(CCM_ANALOG_PLL_BYPASS)
+	enableOutputs(pll, r)
+
+	return
+}
+
+func enableOutputs(pll int, r *reg.Register) {
+	switch pll {
+	case PLL3_USB1, PLL7_USB2:
+		r.Set(CCM_ANALOG_PLL_ENABLE)
+		r.Set(CCM_ANALOG_PLL_USB_EN_USB_CLKS)
+	case PLL6_ENET:
+		r.Set(CCM_ANALOG_PLL_ENET_ENET1_125M_EN)
+		r.Set(CCM_ANALOG_PLL_ENET_ENET2_125M_EN)
+	default:
+		r.Set(CCM_ANALOG_PLL_ENABLE)
+	}
+}
+
+// EnablePLL powers up an analog PLL (PLL2_SYS to PLL7_USB2), with its
+// current configuration (e.g. after PowerDownPLL()), and enables its outputs.
+// PLLs which are already running are left untouched.
+//
+// The remaining PLL configuration functions bypass the PLL while it is
+// reconfigured, affecting all clock roots derived from it.
+func EnablePLL(pll int) (err error) {
+	return configurePLL(pll, nil)
+}
+
+// PowerUpPLL reverses PowerDownPLL(), powering up the analog PLL with its
+// retained configuration, waiting for lock and enabling its outputs.
+func PowerUpPLL(pll int) (err error) {
+	return configurePLL(pll, nil)
+}
+
+// PowerDownPLL disables the outputs of an analog PLL (PLL3_USB1 to
+// PLL7_USB2), bypasses and powers it down to save power, its configuration is
+// retained for PowerUpPLL(). All clock roots and peripherals derived from the
+// PLL must be unused beforehand.
+//
+// The System PLL (PLL2_SYS) cannot be powered down, as it feeds the AHB, IPG
+// and MMDC clock roots.
+func PowerDownPLL(pll int) (err error) {
+	if pll == PLL2_SYS {
+		return errors.New("System PLL cannot be powered down")
+	}
+
+	r, err := pllRegister(pll)
+
+	if err != nil {
+		return
+	}
+
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	switch pll {
+	case PLL6_ENET:
+		r.Clear(CCM_ANALOG_PLL_ENET_ENET1_125M_EN)
+		r.Clear(CCM_ANALOG_PLL_ENET_ENET2_125M_EN)
+	case PLL3_USB1, PLL7_USB2:
+		r.Clear(CCM_ANALOG_PLL_USB_EN_USB_CLKS)
+		r.Clear(CCM_ANALOG_PLL_ENABLE)
+	default:
+		r.Clear(CCM_ANALOG_PLL_ENABLE)
+	}
+
+	r.SetN(CCM_ANALOG_PLL_BYPASS_CLK_SRC, 0b11, 0)
+	r.Set(CCM_ANALOG_PLL_BYPASS)
+
+	switch pll {
+	case PLL3_USB1, PLL7_USB2:
+		r.Clear(CCM_ANALOG_PLL_USB_POWER)
+	default:
+		r.Set(CCM_ANALOG_PLL_POWERDOWN)
+	}
+
+	return
+}
+
+// SetPLLDiv configures the loop divider (DIV_SELECT) of the USB PLLs (0: 20 *
+// OSC_FREQ, 1: 22 * OSC_FREQ), and enables them.
+//
+// The System PLL (PLL2_SYS) cannot be reconfigured, as bypassing it affects
+// the AHB, IPG and MMDC clock roots while executing from DDR.
+func SetPLLDiv(pll int, div uint32) (err error) {
+	if pll == PLL2_SYS {
+		return errors.New("System PLL cannot be reconfigured")
+	}
+
+	if pll != PLL3_USB1 && pll != PLL7_USB2 {
+		return errors.New("invalid PLL")
+	}
+
+	if div > 1 {
+		return errors.New("invalid divider")
+	}
+
+	return configurePLL(pll, func(r *reg.Register) {
+		r.SetN(0, 0b1, div)
+	})
+}
+
+// SetAudioVideoPLL configures the Audio or Video PLL output frequency as:
+//
+//	OSC_FREQ * (div + num / denom) / post
+//
+// where div ranges 27-54 and post is 1, 2 or 4, and enables it.
+func SetAudioVideoPLL(pll int, div uint32, num uint32, denom uint32, post uint32) (err error) {
+	var base uint32
+
+	switch pll {
+	case PLL4_AUDIO:
+		base = CCM_ANALOG_PLL_AUDIO
+	case PLL5_VIDEO:
+		base = CCM_ANALOG_PLL_VIDEO
+	default:
+		return errors.New("invalid PLL")
+	}
+
+	if div < 27 || div > 54 || denom == 0 || num >= denom || num > 0x3fffffff || denom > 0x3fffffff {
+		return errors.New("invalid divider")
+	}
+
+	var postDiv uint32
+
+	switch post {
+	case 1:
+		postDiv = 0b10
+	case 2:
+		postDiv = 0b01
+	case 4:
+		postDiv = 0b00
+	default:
+		return errors.New("invalid post divider")
+	}
+
+	return configurePLL(pll, func(r *reg.Register) {
+		r.SetN(CCM_ANALOG_PLL_AV_DIV_SELECT, 0b1111111, div)
+		r.SetN(CCM_ANALOG_PLL_AV_POST_DIV_SELECT, 0b11, postDiv)
+		reg.New(base + CCM_ANALOG_PLL_AV_NUM).Write(num)
+		reg.New(base + CCM_ANALOG_PLL_AV_DENOM).Write(denom)
+	})
+}
+
+// SetENETPLL configures the ENET PLL reference clock frequency (25, 50, 100
+// or 125 MHz) for the argument ENET controller (1 or 2), and enables it.
+func SetENETPLL(enet int, hz uint32) (err error) {
+	var div uint32
+
+	switch hz {
+	case 25000000:
+		div = 0b00
+	case 50000000:
+		div = 0b01
+	case 100000000:
+		div = 0b10
+	case 125000000:
+		div = 0b11
+	default:
+		return errors.New("unsupported ENET reference frequency")
+	}
+
+	var pos int
+
+	switch enet {
+	case 1:
+		pos = CCM_ANALOG_PLL_ENET_ENET1_DIV_SELECT
+	case 2:
+		pos = CCM_ANALOG_PLL_ENET_ENET2_DIV_SELECT
+	default:
+		return errors.New("invalid ENET controller")
+	}
+
+	return configurePLL(PLL6_ENET, func(r *reg.Register) {
+		r.SetN(pos, 0b11, div)
+	})
+}
@@ -0,0 +1,196 @@
+// NXP PFUZE3000 Power Management IC driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package pmic implements a driver for the NXP PFUZE3000 Power Management IC,
+// used on i.MX6UL/i.MX6ULL reference boards to supply the VDD_ARM_SOC_IN and
+// DDR rails, connected over I2C (see package i2c):
+//
+//	i2c.I2C1.Init(100000)
+//	pfuze := pmic.NewPFUZE3000(i2c.I2C1)
+//
+//	if err := pfuze.Init(); err != nil {
+//		// not found
+//	}
+//
+//	pfuze.SetVoltage(pmic.SW1A, 1375)
+//
+// Boards supplying the ARM core from the PMIC should register it for
+// operating point changes:
+//
+//	imx6.SetRegulator(pfuze.Regulator(pmic.SW1A))
+package pmic
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/i2c"
+)
+
+// PFUZE3000 Register Map, PFUZE3000DS
+const (
+	PFUZE3000_ADDR = 0x08
+
+	PFUZE3000_DEVICEID = 0x00
+	PFUZE3000_ID       = 0x30
+
+	PFUZE3000_SW1AVOLT = 0x20
+	PFUZE3000_SW1BVOLT = 0x2e
+	PFUZE3000_SW3VOLT  = 0x3c
+
+	// output settling time after voltage changes, covering the default
+	// dynamic voltage scaling ramp rate
+	PFUZE3000_SETTLE_US = 200
+)
+
+// Switching regulators
+const (
+	SW1A = iota
+	SW1B
+	SW3
+)
+
+// rail voltage encoding, as min + n*step (mV)
+type rail struct {
+	reg  uint8
+	mask uint8
+	min  int
+	step int
+}
+
+var rails = map[int]rail{
+	SW1A: {PFUZE3000_SW1AVOLT, 0x1f, 700, 25},
+	SW1B: {PFUZE3000_SW1BVOLT, 0x1f, 700, 25},
+	SW3:  {PFUZE3000_SW3VOLT, 0x0f, 900, 50},
+}
+
+// PFUZE3000 represents a PFUZE3000 PMIC instance.
+type PFUZE3000 struct {
+	sync.Mutex
+
+	// I2C bus and device address
+	Bus  i2c.Bus
+	Addr uint8
+}
+
+// NewPFUZE3000 returns a PFUZE3000 instance, at its default address, on the
+// argument I2C bus.
+func NewPFUZE3000(bus i2c.Bus) *PFUZE3000 {
+	return &PFUZE3000{
+		Bus:  bus,
+		Addr: PFUZE3000_ADDR,
+	}
+}
+
+func (hw *PFUZE3000) read(r uint8) (val uint8, err error) {
+	buf := make([]byte, 1)
+
+	if err = hw.Bus.Read(hw.Addr, r, buf); err != nil {
+		return
+	}
+
+	return buf[0], nil
+}
+
+// Init verifies the PMIC device identifier.
+func (hw *PFUZE3000) Init() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	id, err := hw.read(PFUZE3000_DEVICEID)
+
+	if err != nil {
+		return
+	}
+
+	if id != PFUZE3000_ID {
+		return errors.New("invalid device identifier")
+	}
+
+	return
+}
+
+// SetVoltage sets the argument regulator output voltage (in mV), which is
+// rounded down to the closest supported value.
+func (hw *PFUZE3000) SetVoltage(r int, mV int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	rl, ok := rails[r]
+
+	if !ok {
+		return errors.New("invalid regulator")
+	}
+
+	n := (mV - rl.min) / rl.step
+
+	if mV < rl.min || n > int(rl.mask) {
+		return errors.New("voltage out of range")
+	}
+
+	val, err := hw.read(rl.reg)
+
+	if err != nil {
+		return
+	}
+
+	val = (val &^ rl.mask) | uint8(n)
+
+	return hw.Bus.Write(hw.Addr, rl.reg, []byte{val})
+}
+
+// GetVoltage returns the argument regulator output voltage (in mV).
+func (hw *PFUZE3000) GetVoltage(r int) (mV int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	rl, ok := rails[r]
+
+	if !ok {
+		return 0, errors.New("invalid regulator")
+	}
+
+	val, err := hw.read(rl.reg)
+
+	if err != nil {
+		return
+	}
+
+	return rl.min + int(val&rl.mask)*rl.step, nil
+}
+
+// Regulator returns a function, suitable for imx6.SetRegulator(), which sets
+// the argument regulator output voltage (in uV) rounded up to the closest
+// supported value, and waits for the output to settle.
+func (hw *PFUZE3000) Regulator(r int) func(uV uint32) error {
+	return func(uV uint32) error {
+		rl, ok := rails[r]
+
+		if !ok {
+			return errors.New("invalid regulator")
+		}
+
+		mV := (int(uV) + 999) / 1000
+
+		if mV > rl.min {
+			mV = rl.min + (mV-rl.min+rl.step-1)/rl.step*rl.step
+		}
+
+		if err := hw.SetVoltage(r, mV); err != nil {
+			return err
+		}
+
+		imx6.Delay(PFUZE3000_SETTLE_US)
+
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+// ARM Performance Monitors cycle counter
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"sync"
+)
+
+// defined in pmu.s
+func enable_ccnt()
+func read_ccnt() uint32
+
+var (
+	ccntMutex sync.Mutex
+	ccntLast  uint32
+	ccntHigh  uint64
+	ccntInit  bool
+)
+
+// EnableCycleCounter resets and enables the ARM Performance Monitors cycle
+// counter (PMCCNTR), which increments on every ARM core clock cycle.
+func EnableCycleCounter() {
+	ccntMutex.Lock()
+	defer ccntMutex.Unlock()
+
+	enableCycleCounter()
+}
+
+func enableCycleCounter() {
+	enable_ccnt()
+
+	ccntLast = 0
+	ccntHigh = 0
+	ccntInit = true
+}
+
+// Cycles returns the number of ARM core clock cycles elapsed since
+// EnableCycleCounter(), which is invoked on first use if necessary, for
+// profiling purposes:
+//
+//	start := imx6.Cycles()
+//	// code section to measure
+//	cycles := imx6.Cycles() - start
+//
+// The elapsed cycles can be converted to time by dividing them by ARMFreq()
+// (e.g. `ns := cycles * 1000 / uint64(imx6.ARMFreq()/1000000)`), which is only
+// valid if the ARM core frequency was not changed (see SetARMFreq()) between
+// both readings.
+//
+// The 32-bit counter rollover (every ~4.8 seconds at 900 MHz) is tracked in
+// software, therefore Cycles must be invoked at least once per rollover
+// period.
+func Cycles() uint64 {
+	ccntMutex.Lock()
+	defer ccntMutex.Unlock()
+
+	if !ccntInit {
+		enableCycleCounter()
+	}
+
+	cnt := read_ccnt()
+
+	if cnt < ccntLast {
+		ccntHigh += 1 << 32
+	}
+
+	ccntLast = cnt
+
+	return ccntHigh | uint64(cnt)
+}
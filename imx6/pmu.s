@@ -0,0 +1,33 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func enable_ccnt()
+TEXT ·enable_ccnt(SB),$0
+	// ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition
+	//
+	// B4.1.117 PMCR, Performance Monitors Control Register, VMSA
+	WORD	$0xee190f1c // mrc p15, 0, r0, c9, c12, 0
+	ORR	$(1<<2), R0	// C, cycle counter reset
+	ORR	$1, R0		// E, enable
+	WORD	$0xee090f1c // mcr p15, 0, r0, c9, c12, 0
+
+	// B4.1.116 PMCNTENSET, Performance Monitors Count Enable Set register, VMSA
+	MOVW	$(1<<31), R0	// C, cycle counter enable
+	WORD	$0xee090f3c // mcr p15, 0, r0, c9, c12, 1
+
+	WORD	$0xf57ff06f // isb sy
+	RET
+
+// func read_ccnt() uint32
+TEXT ·read_ccnt(SB),$0
+	// B4.1.113 PMCCNTR, Performance Monitors Cycle Count Register, VMSA
+	WORD	$0xee190f1d // mrc p15, 0, r0, c9, c13, 0
+
+	MOVW	R0, ret+0(FP)
+
+	RET
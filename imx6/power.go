@@ -0,0 +1,231 @@
+// NXP i.MX6UL power saving support
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// powerState holds the clock and regulator configuration saved by PowerSave
+// for restoration by Resume.
+type powerState struct {
+	hz        uint32
+	bypass    uint32
+	divSelect uint32
+	armPodf   uint32
+	reg0Targ  uint32
+	reg2Targ  uint32
+	uV        uint32
+	ccgr      [7]uint32
+	clpcr     uint32
+}
+
+var (
+	powerMutex sync.Mutex
+	savedPower *powerState
+)
+
+// PowerSave switches the ARM core to its lowest operating point (of the
+// custom table set with SetOperatingPoints(), if any), gates the argument
+// peripheral clocks and, when wait is true, suspends the ARM core in LPM_WAIT
+// mode (see Wait()) until the next interrupt. The ARM core frequency active
+// before the call is returned.
+//
+// The complete clock and regulator state (ARM PLL and core dividers, core
+// regulator targets or external regulator voltage, CCGR registers and low
+// power mode) is saved before any change and is restored exactly by Resume(),
+// which must be called before the next PowerSave(). Clock gate changes
+// performed in between are therefore discarded on Resume().
+//
+// ErrVoltageNotLowered is returned, with power save active, when the core
+// voltage could not be reduced for the lowest operating point.
+func PowerSave(wait bool, gates ...ClockGate) (prev uint32, err error) {
+	var oldHz, newHz uint32
+
+	// notify listeners once all locks are released
+	defer func() { notifyClockListeners(oldHz, newHz) }()
+
+	powerMutex.Lock()
+	defer powerMutex.Unlock()
+
+	if savedPower != nil {
+		return 0, errors.New("power save already active")
+	}
+
+	for _, g := range gates {
+		if _, err = ccgrRegister(g.CCGR, g.Pos); err != nil {
+			return
+		}
+	}
+
+	s := &powerState{}
+
+	clockMutex.Lock()
+
+	// the lowest point of the custom table, if any, as SetARMFreq only
+	// accepts frequencies within it
+	ops, err := activeOperatingPoints()
+
+	if err != nil {
+		clockMutex.Unlock()
+		return
+	}
+
+	s.hz = ARMFreq()
+	s.bypass = pllARM.Get(CCM_ANALOG_PLL_ARM_BYPASS, 0b1)
+	s.divSelect = pllARM.Get(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111)
+	s.armPodf = cacrr.Get(CCM_CACRR_ARM_PODF, 0b111)
+	s.reg0Targ = regCore.Get(PMU_REG_CORE_REG0_TARG, 0b11111)
+	s.reg2Targ = regCore.Get(PMU_REG_CORE_REG2_TARG, 0b11111)
+	s.uV = regulatorUV
+
+	err = setARMFreqIMX6UL(ops[0].hz)
+	oldHz, newHz = s.hz, ARMFreq()
+
+	clockMutex.Unlock()
+
+	// the lower frequency is applied even if the voltage could not be
+	// reduced, which is reported on return
+	if err != nil && !errors.Is(err, ErrVoltageNotLowered) {
+		return
+	}
+
+	for i := range s.ccgr {
+		r, _ := ccgrRegister(i, 0)
+		s.ccgr[i] = reg.Read(r)
+	}
+
+	s.clpcr = clpcr.Read()
+	savedPower = s
+
+	for _, g := range gates {
+		DisableClock(g.CCGR, g.Pos)
+	}
+
+	if wait {
+		SetLowPowerMode(LPM_WAIT)
+		Wait()
+	}
+
+	return s.hz, err
+}
+
+// Resume restores the clock and regulator state saved by PowerSave(). An
+// error restoring the operating point voltage is returned, if it occurs
+// before the frequency is raised the saved state is retained and Resume() can
+// be invoked again.
+func Resume() (err error) {
+	var oldHz, newHz uint32
+
+	// notify listeners once all locks are released
+	defer func() { notifyClockListeners(oldHz, newHz) }()
+
+	powerMutex.Lock()
+	defer powerMutex.Unlock()
+
+	s := savedPower
+
+	if s == nil {
+		return errors.New("power save not active")
+	}
+
+	for i := range s.ccgr {
+		r, _ := ccgrRegister(i, 0)
+		reg.Write(r, s.ccgr[i])
+	}
+
+	clpcr.Write(s.clpcr)
+
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	curHz := ARMFreq()
+
+	if s.hz > curHz {
+		// never raise the frequency without the required voltage,
+		// the saved state is retained for a further attempt
+		if err = restoreOperatingPoint(s); err != nil {
+			return
+		}
+	}
+
+	// bypass from the main oscillator while changing the PLL divider
+	pllARM.SetN(CCM_ANALOG_PLL_ARM_BYPASS_CLK_SRC, 0b11, 0)
+	pllARM.Set(CCM_ANALOG_PLL_ARM_BYPASS)
+	pllARM.SetN(CCM_ANALOG_PLL_ARM_DIV_SELECT, 0b1111111, s.divSelect)
+
+	if PLLLockTimeout == 0 {
+		pllARM.Wait(CCM_ANALOG_PLL_ARM_LOCK, 0b1, 1)
+	} else if !pllARM.WaitFor(PLLLockTimeout, CCM_ANALOG_PLL_ARM_LOCK, 0b1, 1) {
+		err = fmt.Errorf("%w, PLL left in bypass", ErrPLLLockTimeout)
+	}
+
+	// set core divisor before removing the bypass (see setARMClock())
+	cacrr.SetN(CCM_CACRR_ARM_PODF, 0b111, s.armPodf)
+	cdhipr.Wait(CCM_CDHIPR_ARM_PODF_BUSY, 0b1, 0)
+
+	if err == nil && s.bypass == 0 {
+		pllARM.Clear(CCM_ANALOG_PLL_ARM_BYPASS)
+	}
+
+	if s.hz <= curHz {
+		if verr := restoreOperatingPoint(s); verr != nil && err == nil {
+			err = verr
+		}
+	}
+
+	atomic.StoreUint32(&armFreq, ARMFreq())
+	calibrateDelay()
+	savedPower = nil
+
+	oldHz, newHz = curHz, ARMFreq()
+
+	logf("imx6_clk: resumed %d MHz -> %d MHz\n", oldHz/1000000, newHz/1000000)
+
+	return
+}
+
+func restoreOperatingPoint(s *powerState) (err error) {
+	if regulator != nil {
+		uV := s.uV
+
+		if uV == 0 {
+			// no voltage applied yet, use the one required by
+			// the restored frequency
+			ops, err := activeOperatingPoints()
+
+			if err != nil {
+				return err
+			}
+
+			uV = minVoltage(ops, s.hz)
+		}
+
+		return setOperatingPoint(uV)
+	}
+
+	WithInterruptsDisabled(func() {
+		regCore.SetToN(
+			reg.FieldValue{Pos: PMU_REG_CORE_REG0_TARG, Mask: 0b11111, Val: s.reg0Targ},
+			reg.FieldValue{Pos: PMU_REG_CORE_REG2_TARG, Mask: 0b11111, Val: s.reg2Targ},
+		)
+	})
+
+	Delay(PMU_REG_CORE_SETTLE_US)
+
+	return
+}
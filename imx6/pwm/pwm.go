@@ -0,0 +1,233 @@
+// NXP Pulse Width Modulation (PWM) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package pwm implements a driver for the NXP i.MX6 Pulse Width Modulation
+// (PWM) controllers, clocked from PERCLK.
+//
+// The PWM output pad must be configured (see package iomuxc) before use, for
+// example to drive a buzzer at 2 kHz with a 50% duty cycle:
+//
+//	pwm.PWM1.Init(2000)
+//	pwm.PWM1.SetDutyCycle(50)
+package pwm
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// PWM Memory Map/Register Definition, IMX6ULLRM
+const (
+	PWM1_BASE uint32 = 0x02080000
+	PWM2_BASE uint32 = 0x02084000
+	PWM3_BASE uint32 = 0x02088000
+	PWM4_BASE uint32 = 0x0208c000
+
+	PWM_PWMCR           = 0x00
+	PWM_PWMCR_FWM       = 26
+	PWM_PWMCR_STOPEN    = 25
+	PWM_PWMCR_DOZEN     = 24
+	PWM_PWMCR_WAITEN    = 23
+	PWM_PWMCR_DBGEN     = 22
+	PWM_PWMCR_POUTC     = 18
+	PWM_PWMCR_CLKSRC    = 16
+	PWM_PWMCR_PRESCALER = 4
+	PWM_PWMCR_SWR       = 3
+	PWM_PWMCR_REPEAT    = 1
+	PWM_PWMCR_EN        = 0
+
+	PWM_PWMSR        = 0x04
+	PWM_PWMSR_FWE    = 6
+	PWM_PWMSR_CMP    = 5
+	PWM_PWMSR_ROV    = 4
+	PWM_PWMSR_FE     = 3
+	PWM_PWMSR_FIFOAV = 0
+
+	PWM_PWMIR  = 0x08
+	PWM_PWMSAR = 0x0c
+	PWM_PWMPR  = 0x10
+	PWM_PWMCNR = 0x14
+
+	// ipg_clk_highfreq (PERCLK)
+	CLKSRC_HIGHFREQ = 0b10
+
+	// sample FIFO depth
+	FIFO_SIZE = 4
+	// maximum prescaler value (PRESCALER + 1)
+	MAX_PRESCALER = 4096
+	// maximum period in counter cycles (PWMPR + 2)
+	MAX_PERIOD = 0x10000
+	// minimum period in counter cycles (PWMPR + 2)
+	MIN_PERIOD = 2
+)
+
+type pwm struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	cr  *uint32
+	sr  *uint32
+	ir  *uint32
+	sar *uint32
+	pr  *uint32
+	cnr *uint32
+
+	// period in counter cycles
+	period uint32
+}
+
+func newPWM(base uint32, cg int) *pwm {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &pwm{
+		cg:  cg,
+		cr:  r(PWM_PWMCR),
+		sr:  r(PWM_PWMSR),
+		ir:  r(PWM_PWMIR),
+		sar: r(PWM_PWMSAR),
+		pr:  r(PWM_PWMPR),
+		cnr: r(PWM_PWMCNR),
+	}
+}
+
+var PWM1 = newPWM(PWM1_BASE, imx6.CCM_CCGR4_PWM1)
+var PWM2 = newPWM(PWM2_BASE, imx6.CCM_CCGR4_PWM2)
+var PWM3 = newPWM(PWM3_BASE, imx6.CCM_CCGR4_PWM3)
+var PWM4 = newPWM(PWM4_BASE, imx6.CCM_CCGR4_PWM4)
+
+// Init initializes the PWM controller with the argument output frequency (in
+// Hz), approximated with the closest period achievable from the PWM module
+// clock (PERCLK). The output starts with a 0% duty cycle.
+func (hw *pwm) Init(freq int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if freq <= 0 {
+		return errors.New("invalid frequency")
+	}
+
+	prescaler, period, err := divider(imx6.PerclkFreq(), uint32(freq))
+
+	if err != nil {
+		return
+	}
+
+	if err = imx6.EnableClock(4, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	// software reset
+	reg.Write(hw.cr, 1<<PWM_PWMCR_SWR)
+	reg.Wait(hw.cr, PWM_PWMCR_SWR, 0b1, 0)
+
+	// disable interrupts and clear status flags
+	reg.Write(hw.ir, 0)
+	reg.Write(hw.sr, 1<<PWM_PWMSR_FWE|1<<PWM_PWMSR_CMP|1<<PWM_PWMSR_ROV|1<<PWM_PWMSR_FE)
+
+	// PWMO (Hz) = PCLK (Hz) / (PWMPR + 2)
+	reg.Write(hw.pr, period-2)
+	hw.period = period
+
+	// load the initial sample before enabling
+	reg.Write(hw.sar, 0)
+
+	// output set at rollover and cleared at comparison, each sample used
+	// once, keep running in low power modes
+	reg.Write(hw.cr, (prescaler-1)<<PWM_PWMCR_PRESCALER|
+		CLKSRC_HIGHFREQ<<PWM_PWMCR_CLKSRC|
+		1<<PWM_PWMCR_WAITEN|
+		1<<PWM_PWMCR_DBGEN)
+
+	reg.Set(hw.cr, PWM_PWMCR_EN)
+
+	return
+}
+
+// divider returns the prescaler and period (in counter cycles) which are
+// closest to the requested output frequency, preferring the smallest
+// prescaler for the best duty cycle resolution.
+func divider(clk uint32, freq uint32) (prescaler uint32, period uint32, err error) {
+	cycles := uint64(clk) / uint64(freq)
+
+	prescaler = uint32((cycles + MAX_PERIOD - 1) / MAX_PERIOD)
+
+	if prescaler == 0 {
+		prescaler = 1
+	}
+
+	if prescaler > MAX_PRESCALER {
+		return 0, 0, errors.New("frequency too low")
+	}
+
+	period = uint32(cycles / uint64(prescaler))
+
+	if period < MIN_PERIOD {
+		return 0, 0, errors.New("frequency too high")
+	}
+
+	return
+}
+
+// SetDutyCycle sets the output duty cycle percentage, clamped to 0-100.
+//
+// The new sample is queued in the sample FIFO and therefore takes effect at
+// the next period boundary, the call waits for FIFO space if necessary. When
+// the FIFO becomes empty the last sample remains in use, so the duty cycle is
+// retained until the next call.
+func (hw *pwm) SetDutyCycle(percent int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.period == 0 {
+		return errors.New("controller is not initialized")
+	}
+
+	if percent < 0 {
+		percent = 0
+	}
+
+	if percent > 100 {
+		percent = 100
+	}
+
+	sample := hw.period * uint32(percent) / 100
+
+	// the FIFO is only consumed while the controller is enabled
+	for reg.Get(hw.sr, PWM_PWMSR_FIFOAV, 0b111) >= FIFO_SIZE && reg.Get(hw.cr, PWM_PWMCR_EN, 0b1) == 1 {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+
+	reg.Write(hw.sar, sample)
+
+	// clear FIFO empty, rollover and write error flags
+	reg.Write(hw.sr, 1<<PWM_PWMSR_FWE|1<<PWM_PWMSR_ROV|1<<PWM_PWMSR_FE)
+
+	return
+}
+
+// Disable stops the PWM output.
+func (hw *pwm) Disable() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	reg.Clear(hw.cr, PWM_PWMCR_EN)
+}
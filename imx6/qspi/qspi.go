@@ -0,0 +1,455 @@
+// NXP Quad Serial Peripheral Interface (QuadSPI) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package qspi implements a driver for serial NOR flash memories attached to
+// the NXP i.MX6 QuadSPI controller, supporting memory-mapped (AHB) reads and
+// IP command mode page program and sector erase.
+//
+// Standard SPI commands are used (0x03 read, 0x02 page program, 0x20 4KB
+// sector erase, 24-bit addressing), quad output fast read (0x6b) can be
+// selected for memory-mapped reads when the flash Quad Enable bit is set. The
+// QuadSPI pads must be configured (see package iomuxc) before use:
+//
+//	qspi.QSPI1.Init(16*1024*1024, false)
+//	buf := make([]byte, 256)
+//	qspi.QSPI1.ReadAt(buf, 0)
+package qspi
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// QuadSPI Memory Map/Register Definition, IMX6ULLRM
+const (
+	QSPI1_BASE uint32 = 0x021e0000
+	// AHB memory-mapped flash region
+	QSPI1_AHB_BASE uint32 = 0x60000000
+
+	QUADSPI_MCR          = 0x000
+	QUADSPI_MCR_RESERVED = 16
+	QUADSPI_MCR_MDIS     = 14
+	QUADSPI_MCR_CLR_TXF  = 11
+	QUADSPI_MCR_CLR_RXF  = 10
+	QUADSPI_MCR_END_CFG  = 2
+	QUADSPI_MCR_SWRSTHD  = 1
+	QUADSPI_MCR_SWRSTSD  = 0
+
+	QUADSPI_IPCR        = 0x008
+	QUADSPI_IPCR_SEQID  = 24
+	QUADSPI_IPCR_IDATSZ = 0
+
+	QUADSPI_BUF0CR        = 0x010
+	QUADSPI_BUF1CR        = 0x014
+	QUADSPI_BUF2CR        = 0x018
+	QUADSPI_BUF3CR        = 0x01c
+	QUADSPI_BUF3CR_ALLMST = 31
+	QUADSPI_BUFXCR_ADATSZ = 8
+
+	QUADSPI_BFGENCR       = 0x020
+	QUADSPI_BFGENCR_SEQID = 12
+
+	QUADSPI_BUF0IND = 0x030
+	QUADSPI_BUF1IND = 0x034
+	QUADSPI_BUF2IND = 0x038
+
+	QUADSPI_SFAR = 0x100
+
+	QUADSPI_RBCT       = 0x110
+	QUADSPI_RBCT_RXBRD = 8
+
+	QUADSPI_TBDR = 0x154
+
+	QUADSPI_SR      = 0x15c
+	QUADSPI_SR_BUSY = 0
+
+	QUADSPI_SFA1AD = 0x180
+	QUADSPI_SFA2AD = 0x184
+	QUADSPI_SFB1AD = 0x188
+	QUADSPI_SFB2AD = 0x18c
+
+	QUADSPI_RBDR = 0x200
+
+	QUADSPI_LUTKEY       = 0x300
+	QUADSPI_LCKCR        = 0x304
+	QUADSPI_LCKCR_LOCK   = 0
+	QUADSPI_LCKCR_UNLOCK = 1
+	QUADSPI_LUT          = 0x310
+
+	// LUT unlock/lock key
+	LUTKEY_VALUE = 0x5af05af0
+	// 64-bit little endian (MCR END_CFG field)
+	END_CFG_64_LE = 0b11
+
+	// invalid master ID for unused AHB buffers
+	BUFXCR_INVALID_MSTRID = 0xe
+
+	// AHB buffer size, in bytes
+	AHB_BUFFER_SIZE = 1024
+	// IP command RX buffer size, in bytes
+	RX_BUFFER_SIZE = 128
+)
+
+// LUT instruction opcodes and pad numbers
+const (
+	LUT_STOP  = 0
+	LUT_CMD   = 1
+	LUT_ADDR  = 2
+	LUT_DUMMY = 3
+	LUT_READ  = 7
+	LUT_WRITE = 8
+
+	PAD_1 = 0
+	PAD_4 = 2
+)
+
+// LUT sequences
+const (
+	SEQ_READ = iota
+	SEQ_WRITE_ENABLE
+	SEQ_READ_STATUS
+	SEQ_PAGE_PROGRAM
+	SEQ_SECTOR_ERASE
+)
+
+// Serial NOR flash commands and geometry
+const (
+	CMD_READ         = 0x03
+	CMD_QUAD_READ    = 0x6b
+	CMD_WRITE_ENABLE = 0x06
+	CMD_READ_STATUS  = 0x05
+	CMD_PAGE_PROGRAM = 0x02
+	CMD_SECTOR_ERASE = 0x20
+	STATUS_WIP       = 0
+	ADDRESS_BITS     = 24
+	QUAD_READ_DUMMY  = 8
+	PAGE_SIZE        = 256
+	SECTOR_SIZE      = 4096
+	MAX_FLASH_SIZE   = 1 << ADDRESS_BITS
+)
+
+// Timeout is the maximum duration for IP commands and for flash write in
+// progress polling.
+var Timeout = 1 * time.Second
+
+type qspi struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	base uint32
+	ahb  uint32
+	size uint32
+
+	mcr  *uint32
+	ipcr *uint32
+	sfar *uint32
+	rbct *uint32
+	tbdr *uint32
+	sr   *uint32
+	rbdr *uint32
+}
+
+func newQSPI(base uint32, ahb uint32, cg int) *qspi {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &qspi{
+		cg:   cg,
+		base: base,
+		ahb:  ahb,
+		mcr:  r(QUADSPI_MCR),
+		ipcr: r(QUADSPI_IPCR),
+		sfar: r(QUADSPI_SFAR),
+		rbct: r(QUADSPI_RBCT),
+		tbdr: r(QUADSPI_TBDR),
+		sr:   r(QUADSPI_SR),
+		rbdr: r(QUADSPI_RBDR),
+	}
+}
+
+var QSPI1 = newQSPI(QSPI1_BASE, QSPI1_AHB_BASE, imx6.CCM_CCGR3_QSPI)
+
+func (hw *qspi) register(off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(hw.base + off)))
+}
+
+// lut encodes a pair of LUT instructions.
+func lut(op0, pad0, opr0, op1, pad1, opr1 uint32) uint32 {
+	return (op1<<10|pad1<<8|opr1)<<16 | (op0<<10 | pad0<<8 | opr0)
+}
+
+// Init initializes the QuadSPI controller for a serial NOR flash of the
+// argument size (in bytes, up to 16MB) on port A1, selecting quad output
+// fast read for memory-mapped access when quad is true.
+func (hw *qspi) Init(size uint32, quad bool) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if size == 0 || size > MAX_FLASH_SIZE {
+		return errors.New("invalid flash size")
+	}
+
+	if err = imx6.EnableClock(3, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	hw.size = size
+
+	// disable the module during configuration
+	reg.Write(hw.mcr, 0xf<<QUADSPI_MCR_RESERVED|
+		END_CFG_64_LE<<QUADSPI_MCR_END_CFG|
+		1<<QUADSPI_MCR_MDIS)
+
+	read := lut(LUT_CMD, PAD_1, CMD_READ, LUT_ADDR, PAD_1, ADDRESS_BITS)
+	data := lut(LUT_READ, PAD_1, 0, LUT_STOP, 0, 0)
+
+	if quad {
+		read = lut(LUT_CMD, PAD_1, CMD_QUAD_READ, LUT_ADDR, PAD_1, ADDRESS_BITS)
+		data = lut(LUT_DUMMY, PAD_1, QUAD_READ_DUMMY, LUT_READ, PAD_4, 0)
+	}
+
+	hw.setLUT(SEQ_READ, read, data)
+	hw.setLUT(SEQ_WRITE_ENABLE, lut(LUT_CMD, PAD_1, CMD_WRITE_ENABLE, LUT_STOP, 0, 0))
+	hw.setLUT(SEQ_READ_STATUS, lut(LUT_CMD, PAD_1, CMD_READ_STATUS, LUT_READ, PAD_1, 1))
+	hw.setLUT(SEQ_PAGE_PROGRAM,
+		lut(LUT_CMD, PAD_1, CMD_PAGE_PROGRAM, LUT_ADDR, PAD_1, ADDRESS_BITS),
+		lut(LUT_WRITE, PAD_1, 0, LUT_STOP, 0, 0))
+	hw.setLUT(SEQ_SECTOR_ERASE, lut(LUT_CMD, PAD_1, CMD_SECTOR_ERASE, LUT_ADDR, PAD_1, ADDRESS_BITS))
+
+	// flash A1 only, top addresses are inclusive of all previous ports
+	top := hw.ahb + size
+	reg.Write(hw.register(QUADSPI_SFA1AD), top)
+	reg.Write(hw.register(QUADSPI_SFA2AD), top)
+	reg.Write(hw.register(QUADSPI_SFB1AD), top)
+	reg.Write(hw.register(QUADSPI_SFB2AD), top)
+
+	// a single AHB buffer, for all masters, serves memory-mapped reads
+	reg.Write(hw.register(QUADSPI_BUF0CR), BUFXCR_INVALID_MSTRID)
+	reg.Write(hw.register(QUADSPI_BUF1CR), BUFXCR_INVALID_MSTRID)
+	reg.Write(hw.register(QUADSPI_BUF2CR), BUFXCR_INVALID_MSTRID)
+	reg.Write(hw.register(QUADSPI_BUF3CR), 1<<QUADSPI_BUF3CR_ALLMST|
+		(AHB_BUFFER_SIZE/8)<<QUADSPI_BUFXCR_ADATSZ)
+	reg.Write(hw.register(QUADSPI_BUF0IND), 0)
+	reg.Write(hw.register(QUADSPI_BUF1IND), 0)
+	reg.Write(hw.register(QUADSPI_BUF2IND), 0)
+
+	reg.SetN(hw.register(QUADSPI_BFGENCR), QUADSPI_BFGENCR_SEQID, 0b1111, SEQ_READ)
+
+	// IP command reads through RBDR
+	reg.Set(hw.rbct, QUADSPI_RBCT_RXBRD)
+
+	reg.Clear(hw.mcr, QUADSPI_MCR_MDIS)
+
+	return
+}
+
+// setLUT programs a LUT sequence, each sequence holds up to 4 words.
+func (hw *qspi) setLUT(seq int, words ...uint32) {
+	key := hw.register(QUADSPI_LUTKEY)
+	lckcr := hw.register(QUADSPI_LCKCR)
+
+	reg.Write(key, LUTKEY_VALUE)
+	reg.Write(lckcr, 1<<QUADSPI_LCKCR_UNLOCK)
+
+	for i := 0; i < 4; i++ {
+		var w uint32
+
+		if i < len(words) {
+			w = words[i]
+		}
+
+		reg.Write(hw.register(QUADSPI_LUT+uint32(seq*16+i*4)), w)
+	}
+
+	reg.Write(key, LUTKEY_VALUE)
+	reg.Write(lckcr, 1<<QUADSPI_LCKCR_LOCK)
+}
+
+// cmd executes a LUT sequence in IP command mode, at the argument flash
+// address, transmitting (tx) or receiving (rx) data.
+func (hw *qspi) cmd(seq int, addr uint32, tx []byte, rx []byte) (err error) {
+	size := len(tx) + len(rx)
+
+	reg.Write(hw.sfar, hw.ahb+addr)
+
+	// clear FIFOs
+	reg.Set(hw.mcr, QUADSPI_MCR_CLR_TXF)
+	reg.Set(hw.mcr, QUADSPI_MCR_CLR_RXF)
+
+	for i := 0; i < len(tx); i += 4 {
+		var w [4]byte
+		copy(w[:], tx[i:])
+		reg.Write(hw.tbdr, binary.LittleEndian.Uint32(w[:]))
+	}
+
+	reg.Write(hw.ipcr, uint32(seq)<<QUADSPI_IPCR_SEQID|uint32(size)<<QUADSPI_IPCR_IDATSZ)
+
+	if !reg.WaitWhileFor(Timeout, hw.sr, QUADSPI_SR_BUSY, 0b1, 1) {
+		return errors.New("command timeout")
+	}
+
+	for i := 0; i < len(rx); i += 4 {
+		var w [4]byte
+		binary.LittleEndian.PutUint32(w[:], reg.Read(hw.register(QUADSPI_RBDR+uint32(i))))
+		copy(rx[i:], w[:])
+	}
+
+	return
+}
+
+// invalidate discards the AHB buffer content, to observe flash changes
+// through memory-mapped reads.
+func (hw *qspi) invalidate() {
+	reg.SetN(hw.mcr, QUADSPI_MCR_SWRSTSD, 0b11, 0b11)
+	imx6.Delay(1)
+	reg.ClearN(hw.mcr, QUADSPI_MCR_SWRSTSD, 0b11)
+
+	cache.InvalidateRange(uintptr(hw.ahb), uintptr(hw.size))
+}
+
+// Status returns the flash status register.
+func (hw *qspi) Status() (status byte, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.status()
+}
+
+func (hw *qspi) status() (status byte, err error) {
+	buf := make([]byte, 1)
+	err = hw.cmd(SEQ_READ_STATUS, 0, nil, buf)
+	return buf[0], err
+}
+
+// wait polls the flash status register until no write is in progress.
+func (hw *qspi) wait() (err error) {
+	start := time.Now()
+
+	for {
+		status, err := hw.status()
+
+		if err != nil {
+			return err
+		}
+
+		if (status>>STATUS_WIP)&1 == 0 {
+			return nil
+		}
+
+		if time.Since(start) >= Timeout {
+			return errors.New("write in progress timeout")
+		}
+
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+}
+
+// ReadAt reads from the flash at the argument offset through the AHB
+// memory-mapped region, it implements io.ReaderAt.
+func (hw *qspi) ReadAt(p []byte, off int64) (n int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.size == 0 {
+		return 0, errors.New("controller is not initialized")
+	}
+
+	if off < 0 || off >= int64(hw.size) {
+		return 0, io.EOF
+	}
+
+	n = len(p)
+
+	if off+int64(n) > int64(hw.size) {
+		n = int(int64(hw.size) - off)
+		err = io.EOF
+	}
+
+	addr := hw.ahb + uint32(off)
+	copy(p, (*[1 << 30]byte)(unsafe.Pointer(uintptr(addr)))[:n:n])
+
+	return
+}
+
+// Program writes data at the argument flash address, which must have been
+// previously erased, splitting it in page program operations.
+func (hw *qspi) Program(addr uint32, data []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if uint64(addr)+uint64(len(data)) > uint64(hw.size) {
+		return errors.New("invalid flash range")
+	}
+
+	defer hw.invalidate()
+
+	for len(data) > 0 {
+		// page program operations cannot cross page boundaries
+		n := PAGE_SIZE - int(addr%PAGE_SIZE)
+
+		if n > len(data) {
+			n = len(data)
+		}
+
+		if err = hw.cmd(SEQ_WRITE_ENABLE, addr, nil, nil); err != nil {
+			return
+		}
+
+		if err = hw.cmd(SEQ_PAGE_PROGRAM, addr, data[0:n], nil); err != nil {
+			return
+		}
+
+		if err = hw.wait(); err != nil {
+			return
+		}
+
+		addr += uint32(n)
+		data = data[n:]
+	}
+
+	return
+}
+
+// Erase erases the 4KB flash sector containing the argument address.
+func (hw *qspi) Erase(addr uint32) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if addr >= hw.size {
+		return errors.New("invalid flash address")
+	}
+
+	defer hw.invalidate()
+
+	if err = hw.cmd(SEQ_WRITE_ENABLE, addr, nil, nil); err != nil {
+		return
+	}
+
+	if err = hw.cmd(SEQ_SECTOR_ERASE, addr&^(SECTOR_SIZE-1), nil, nil); err != nil {
+		return
+	}
+
+	return hw.wait()
+}
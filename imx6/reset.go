@@ -0,0 +1,47 @@
+// NXP i.MX6 system reset
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// Watchdog Control Register (WDOG1_WCR), IMX6ULLRM
+	WDOG1_WCR     uint32 = 0x020bc000
+	WDOG1_WCR_WDE        = 2
+)
+
+// Reset asserts a full SoC reset, through the WDOG1 software reset signal
+// (SRS), the function never returns.
+//
+// The data cache is flushed before the reset is triggered, however any
+// pending write to external devices (e.g. storage controller buffers) must be
+// completed by the caller beforehand.
+func Reset() {
+	wcr := (*uint16)(unsafe.Pointer(uintptr(WDOG1_WCR)))
+
+	EnableClock(3, CCM_CCGR3_WDOG1, CCGR_ON)
+
+	cache.FlushData()
+	reg.Barrier()
+
+	// clearing SRS (and WDA) asserts the software reset (and WDOG_B)
+	reg.Write16(wcr, 1<<WDOG1_WCR_WDE)
+
+	for {
+		// wait for reset
+	}
+}
@@ -12,6 +12,10 @@
 package imx6
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
 	"sync"
 	"unsafe"
 
@@ -51,6 +55,11 @@ type rngb struct {
 	fifo   *uint32
 }
 
+// RNGBInitLoops represents the maximum number of status register polling
+// iterations, for each of the self-test and seeding phases, during RNGB
+// initialization. A zero value (default) waits indefinitely.
+var RNGBInitLoops int
+
 var RNGB = &rngb{
 	cmd:    (*uint32)(unsafe.Pointer(uintptr(HW_RNG_CMD))),
 	ctrl:   (*uint32)(unsafe.Pointer(uintptr(HW_RNG_CR))),
@@ -100,11 +109,12 @@ func (hw *rngb) Init() {
 	reg.Set(hw.cmd, HW_RNG_CMD_ST)
 
 	print("imx6_rng: self-test\n")
-	for reg.Get(hw.status, HW_RNG_SR_STDN, 0b1) != 1 {
-		// reg.Wait cannot be used before runtime initialization
+	// reg.Wait cannot be used before runtime initialization
+	if !reg.WaitLoops(hw.status, HW_RNG_SR_STDN, 0b1, 1, RNGBInitLoops) {
+		panic("imx6_rng: self-test timeout\n")
 	}
 
-	if reg.Get(hw.status, HW_RNG_SR_ERR, 0b1) != 0 || reg.Get(hw.status, HW_RNG_SR_ST_PF, 0b1) != 0 {
+	if reg.Any(hw.status, HW_RNG_SR_ERR, 0b1) || reg.Any(hw.status, HW_RNG_SR_ST_PF, 0b1) {
 		panic("imx6_rng: self-test FAIL\n")
 	}
 
@@ -112,8 +122,8 @@ func (hw *rngb) Init() {
 	reg.Set(hw.ctrl, HW_RNG_CR_AR)
 
 	print("imx6_rng: seeding\n")
-	for reg.Get(hw.status, HW_RNG_SR_SDN, 0b1) != 1 {
-		// reg.Wait cannot be used before runtime initialization
+	if !reg.WaitLoops(hw.status, HW_RNG_SR_SDN, 0b1, 1, RNGBInitLoops) {
+		panic("imx6_rng: seeding timeout\n")
 	}
 
 	hw.Unlock()
@@ -124,7 +134,7 @@ func (hw *rngb) getRandomData(b []byte) {
 	need := len(b)
 
 	for read < need {
-		if reg.Get(hw.status, HW_RNG_SR_ERR, 0b1) != 0 {
+		if reg.Any(hw.status, HW_RNG_SR_ERR, 0b1) {
 			panic("imx6_rng: error during getRandomData\n")
 		}
 
@@ -135,6 +145,52 @@ func (hw *rngb) getRandomData(b []byte) {
 	}
 }
 
+// Read fills the argument buffer with random data, waiting for the RNGB FIFO
+// to be refilled when necessary. It implements io.Reader and it is therefore
+// suitable for use as a crypto/rand Reader (note that crypto/rand.Reader is
+// already backed by the RNGB, when available, after runtime initialization).
+//
+// This function cannot be used before runtime initialization with
+// `GOOS=tamago`.
+func (hw *rngb) Read(b []byte) (n int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	// wait for seeding completion
+	reg.Wait(hw.status, HW_RNG_SR_SDN, 0b1, 1)
+
+	for n < len(b) {
+		if reg.Any(hw.status, HW_RNG_SR_ST_PF, 0b1) {
+			return n, errors.New("self-test failure")
+		}
+
+		if reg.Any(hw.status, HW_RNG_SR_ERR, 0b1) {
+			return n, fmt.Errorf("RNGB error (ESR %#x)", *hw.err)
+		}
+
+		if reg.Get(hw.status, HW_RNG_SR_FIFO_LVL, 0b1111) == 0 {
+			// wait for FIFO refill
+			runtime.Gosched()
+			continue
+		}
+
+		n = fill(b, n, *hw.fifo)
+	}
+
+	return
+}
+
+// GetUint32 returns a random 32-bit value, see Read().
+func (hw *rngb) GetUint32() (val uint32, err error) {
+	b := make([]byte, 4)
+
+	if _, err = hw.Read(b); err != nil {
+		return
+	}
+
+	return binary.LittleEndian.Uint32(b), nil
+}
+
 func fill(b []byte, index int, val uint32) int {
 	shift := 0
 	limit := len(b)
@@ -0,0 +1,348 @@
+// NXP Synchronous Audio Interface (SAI) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package sai implements a driver for the NXP i.MX6 Synchronous Audio
+// Interface (SAI) transmitter, as I2S master with the master clock sourced
+// from the Audio PLL (PLL4), using polled FIFO writes.
+//
+// The SAI pads must be configured (see package iomuxc) before use, for
+// example:
+//
+//	sai.SAI2.Init(48000, 16, 2)
+//	sai.SAI2.Write(pcm)
+//
+// The Audio PLL is shared among all SAI instances, therefore only one sample
+// rate family can be in use at any given time.
+package sai
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// SAI Memory Map/Register Definition, IMX6ULLRM
+const (
+	SAI1_BASE uint32 = 0x02028000
+	SAI2_BASE uint32 = 0x0202c000
+	SAI3_BASE uint32 = 0x02030000
+
+	SAI_TCSR      = 0x00
+	SAI_TCSR_TE   = 31
+	SAI_TCSR_BCE  = 28
+	SAI_TCSR_FR   = 25
+	SAI_TCSR_SR   = 24
+	SAI_TCSR_FEF  = 18
+	SAI_TCSR_FRF  = 16
+	SAI_TCSR_FEIE = 10
+
+	SAI_TCR1     = 0x04
+	SAI_TCR1_TFW = 0
+
+	SAI_TCR2      = 0x08
+	SAI_TCR2_SYNC = 30
+	SAI_TCR2_MSEL = 26
+	SAI_TCR2_BCP  = 25
+	SAI_TCR2_BCD  = 24
+	SAI_TCR2_DIV  = 0
+
+	SAI_TCR3     = 0x0c
+	SAI_TCR3_TCE = 16
+
+	SAI_TCR4      = 0x10
+	SAI_TCR4_FRSZ = 16
+	SAI_TCR4_SYWD = 8
+	SAI_TCR4_MF   = 4
+	SAI_TCR4_FSE  = 3
+	SAI_TCR4_FSP  = 1
+	SAI_TCR4_FSD  = 0
+
+	SAI_TCR5     = 0x14
+	SAI_TCR5_WNW = 24
+	SAI_TCR5_W0W = 16
+	SAI_TCR5_FBT = 8
+
+	SAI_TDR0 = 0x20
+	SAI_TMR  = 0x60
+
+	// MSEL: master clock 1 (SAI clock root)
+	MSEL_MCLK1 = 0b01
+
+	// transmit FIFO size (words)
+	FIFO_DEPTH = 32
+	// transmit FIFO request watermark (words)
+	FIFO_WATERMARK = 16
+)
+
+// Sample rate and master clock configuration
+const (
+	// I2S slot width (bits), each frame carries two slots
+	SLOT_WIDTH = 32
+	// master clock oversampling ratio (MCLK = 256 * sample rate)
+	MCLK_RATIO = 256
+	// bit clock divider: MCLK / ((DIV + 1) * 2) = 2 * SLOT_WIDTH * rate
+	BCLK_DIV = MCLK_RATIO/(2*2*SLOT_WIDTH) - 1
+
+	// supported sample rate range (Hz)
+	MIN_SAMPLE_RATE = 8000
+	MAX_SAMPLE_RATE = 192000
+)
+
+// Timeout is the maximum duration for transmit FIFO space to become
+// available.
+var Timeout = 100 * time.Millisecond
+
+type sai struct {
+	sync.Mutex
+
+	n  int
+	cg int
+
+	tcsr *uint32
+	tcr1 *uint32
+	tcr2 *uint32
+	tcr3 *uint32
+	tcr4 *uint32
+	tcr5 *uint32
+	tdr  *uint32
+	tmr  *uint32
+
+	rate      uint32
+	bits      int
+	channels  int
+	underruns uint64
+}
+
+func newSAI(n int, base uint32, cg int) *sai {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &sai{
+		n:    n,
+		cg:   cg,
+		tcsr: r(SAI_TCSR),
+		tcr1: r(SAI_TCR1),
+		tcr2: r(SAI_TCR2),
+		tcr3: r(SAI_TCR3),
+		tcr4: r(SAI_TCR4),
+		tcr5: r(SAI_TCR5),
+		tdr:  r(SAI_TDR0),
+		tmr:  r(SAI_TMR),
+	}
+}
+
+var (
+	SAI1 = newSAI(1, SAI1_BASE, imx6.CCM_CCGR5_SAI1)
+	SAI2 = newSAI(2, SAI2_BASE, imx6.CCM_CCGR5_SAI2)
+	SAI3 = newSAI(3, SAI3_BASE, imx6.CCM_CCGR5_SAI3)
+)
+
+// Init configures the transmitter as I2S master for the argument sample rate
+// (Hz), sample size (16, 24 or 32 bits) and number of channels (1 or 2),
+// programming the Audio PLL and SAI clock root (see imx6.SetSAIClock()).
+//
+// The Audio PLL fractional divider allows any rate within MIN_SAMPLE_RATE
+// and MAX_SAMPLE_RATE to be approximated, the rate actually achieved is
+// returned by SampleRate(). The transmitter is started by the first Write().
+func (hw *sai) Init(sampleRate int, bits int, channels int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if sampleRate < MIN_SAMPLE_RATE || sampleRate > MAX_SAMPLE_RATE {
+		return errors.New("unsupported sample rate")
+	}
+
+	if bits != 16 && bits != 24 && bits != 32 {
+		return errors.New("unsupported sample size")
+	}
+
+	if channels != 1 && channels != 2 {
+		return errors.New("unsupported number of channels")
+	}
+
+	mclk, err := imx6.SetSAIClock(hw.n, uint32(sampleRate)*MCLK_RATIO)
+
+	if err != nil {
+		return
+	}
+
+	if err = imx6.EnableClock(5, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	// disable transmitter and perform software reset
+	reg.Write(hw.tcsr, 0)
+	reg.Set(hw.tcsr, SAI_TCSR_SR)
+	reg.Clear(hw.tcsr, SAI_TCSR_SR)
+
+	reg.SetN(hw.tcr1, SAI_TCR1_TFW, 0b11111, FIFO_WATERMARK)
+
+	// asynchronous mode, internal bit clock driven on the falling edge
+	reg.Write(hw.tcr2, 0)
+	reg.SetN(hw.tcr2, SAI_TCR2_MSEL, 0b11, MSEL_MCLK1)
+	reg.Set(hw.tcr2, SAI_TCR2_BCP)
+	reg.Set(hw.tcr2, SAI_TCR2_BCD)
+	reg.SetN(hw.tcr2, SAI_TCR2_DIV, 0xff, BCLK_DIV)
+
+	// transmit data channel 0
+	reg.Write(hw.tcr3, 1<<SAI_TCR3_TCE)
+
+	// I2S: two words per frame, MSB first, active low frame sync
+	// asserted one bit early for the whole left channel slot
+	reg.Write(hw.tcr4, 0)
+	reg.SetN(hw.tcr4, SAI_TCR4_FRSZ, 0b11111, 2-1)
+	reg.SetN(hw.tcr4, SAI_TCR4_SYWD, 0b11111, SLOT_WIDTH-1)
+	reg.Set(hw.tcr4, SAI_TCR4_MF)
+	reg.Set(hw.tcr4, SAI_TCR4_FSE)
+	reg.Set(hw.tcr4, SAI_TCR4_FSP)
+	reg.Set(hw.tcr4, SAI_TCR4_FSD)
+
+	// samples are left aligned within their slot by Write()
+	reg.Write(hw.tcr5, 0)
+	reg.SetN(hw.tcr5, SAI_TCR5_WNW, 0b11111, SLOT_WIDTH-1)
+	reg.SetN(hw.tcr5, SAI_TCR5_W0W, 0b11111, SLOT_WIDTH-1)
+	reg.SetN(hw.tcr5, SAI_TCR5_FBT, 0b11111, SLOT_WIDTH-1)
+
+	if channels == 1 {
+		// mask the right channel slot, which is then neither
+		// fetched from the FIFO nor driven
+		reg.Write(hw.tmr, 0b10)
+	} else {
+		reg.Write(hw.tmr, 0)
+	}
+
+	hw.rate = mclk / MCLK_RATIO
+	hw.bits = bits
+	hw.channels = channels
+	hw.underruns = 0
+
+	return
+}
+
+// SampleRate returns the sample rate (Hz) achieved by the Audio PLL and clock
+// root dividers selected by Init().
+func (hw *sai) SampleRate() uint32 {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.rate
+}
+
+// Underruns returns the number of transmit FIFO underruns detected since
+// Init(), each of which results in silence on the I2S output until more data
+// is written.
+func (hw *sai) Underruns() uint64 {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.underruns
+}
+
+// Write transmits the argument buffer of interleaved little-endian PCM
+// samples (2 bytes each for 16-bit samples, 4 bytes each otherwise), blocking
+// until all samples are queued in the transmit FIFO. The transmitter is
+// started once the FIFO is filled for the first time.
+//
+// A FIFO underrun, caused by Write not being invoked again in time, is
+// recovered by resetting the FIFO to realign channels and counted (see
+// Underruns()).
+func (hw *sai) Write(buf []byte) (n int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.rate == 0 {
+		return 0, errors.New("controller not initialized")
+	}
+
+	size := 4
+
+	if hw.bits == 16 {
+		size = 2
+	}
+
+	if len(buf)%(size*hw.channels) != 0 {
+		return 0, errors.New("invalid buffer size")
+	}
+
+	shift := uint(SLOT_WIDTH - hw.bits)
+	free := FIFO_DEPTH
+
+	if reg.Get(hw.tcsr, SAI_TCSR_TE, 0b1) == 1 {
+		free = 0
+	}
+
+	for n < len(buf) {
+		if reg.Get(hw.tcsr, SAI_TCSR_FEF, 0b1) == 1 {
+			// reset FIFO and clear the error flag
+			reg.Set(hw.tcsr, SAI_TCSR_FR)
+			reg.Set(hw.tcsr, SAI_TCSR_FEF)
+			hw.underruns += 1
+			free = FIFO_DEPTH
+		}
+
+		if free == 0 {
+			if !reg.WaitFor(Timeout, hw.tcsr, SAI_TCSR_FRF, 0b1, 1) {
+				return n, errors.New("timeout waiting for FIFO space")
+			}
+
+			free = FIFO_DEPTH - FIFO_WATERMARK
+		}
+
+		var sample uint32
+
+		for i := 0; i < size; i++ {
+			sample |= uint32(buf[n+i]) << uint(8*i)
+		}
+
+		reg.Write(hw.tdr, sample<<shift)
+
+		n += size
+		free -= 1
+
+		if free == 0 && reg.Get(hw.tcsr, SAI_TCSR_TE, 0b1) == 0 {
+			hw.start()
+		}
+	}
+
+	if reg.Get(hw.tcsr, SAI_TCSR_TE, 0b1) == 0 {
+		hw.start()
+	}
+
+	return
+}
+
+func (hw *sai) start() {
+	reg.Set(hw.tcsr, SAI_TCSR_BCE)
+	reg.Set(hw.tcsr, SAI_TCSR_TE)
+}
+
+// Stop disables the transmitter, at the end of the current frame, and
+// discards any queued samples.
+func (hw *sai) Stop() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	reg.Clear(hw.tcsr, SAI_TCSR_TE)
+
+	if !reg.WaitFor(Timeout, hw.tcsr, SAI_TCSR_TE, 0b1, 0) {
+		return errors.New("timeout disabling transmitter")
+	}
+
+	reg.Clear(hw.tcsr, SAI_TCSR_BCE)
+	reg.Set(hw.tcsr, SAI_TCSR_FR)
+
+	return
+}
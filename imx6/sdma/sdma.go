@@ -0,0 +1,325 @@
+// NXP Smart Direct Memory Access (SDMA) controller driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package sdma implements a driver for the NXP i.MX6 Smart Direct Memory
+// Access (SDMA) controller, running the ROM memory to memory script on a
+// dedicated channel to offload bulk copies from the ARM core.
+//
+// The controller data structures are allocated from the DMA region (see
+// package dma), which must therefore be initialized before use:
+//
+//	dma.Init(dmaStart, dmaSize)
+//	sdma.Init()
+//	sdma.MemCopy(dst, src, n)
+//
+// Only memory to memory transfers are currently supported, channel 0 is
+// reserved for context loading and channel 1 for MemCopy().
+package sdma
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/dma"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// SDMA Memory Map/Register Definition, IMX6ULLRM
+const (
+	SDMA_BASE uint32 = 0x020ec000
+
+	SDMA_MC0PTR    = SDMA_BASE + 0x000
+	SDMA_INTR      = SDMA_BASE + 0x004
+	SDMA_STOP_STAT = SDMA_BASE + 0x008
+	SDMA_HSTART    = SDMA_BASE + 0x00c
+	SDMA_EVTOVR    = SDMA_BASE + 0x010
+	SDMA_DSPOVR    = SDMA_BASE + 0x014
+	SDMA_HOSTOVR   = SDMA_BASE + 0x018
+	SDMA_RESET     = SDMA_BASE + 0x024
+	SDMA_INTRMASK  = SDMA_BASE + 0x02c
+	SDMA_CONFIG    = SDMA_BASE + 0x038
+	SDMA_CHN0ADDR  = SDMA_BASE + 0x050
+	SDMA_CHNPRI0   = SDMA_BASE + 0x100
+	SDMA_CHNENBL0  = SDMA_BASE + 0x200
+
+	SDMA_RESET_RESET = 0
+
+	// dynamic context switching
+	SDMA_CONFIG_CSM = 0b11
+
+	// channel 0 boot script address, with 32-word context size (SMSZ)
+	CHN0ADDR = 1<<14 | 0x050
+
+	// number of channels and DMA request events
+	CHANNELS = 32
+	EVENTS   = 48
+)
+
+// Buffer descriptor fields, IMX6ULLRM
+const (
+	BD_COUNT   = 0
+	BD_STATUS  = 16
+	BD_COMMAND = 24
+
+	BD_DONE = 0x01
+	BD_WRAP = 0x02
+	BD_CONT = 0x04
+	BD_INTR = 0x08
+	BD_RROR = 0x10
+	BD_LAST = 0x20
+	BD_EXTD = 0x80
+
+	// channel 0 command: set data memory (context load)
+	C0_SETDM = 0x01
+
+	// maximum byte count per buffer descriptor
+	BD_MAX_COUNT = 0xfffc
+)
+
+// ROM script addresses (i.MX6Q/i.MX6UL SDMA ROM)
+const (
+	// memory to memory (AP to AP) transfer
+	AP_2_AP_ADDR = 642
+)
+
+const (
+	// channel control block size (bytes)
+	ccbSize = 16
+	// buffer descriptor size (bytes)
+	bdSize = 12
+	// channel context size (words), configured by CHN0ADDR SMSZ
+	contextWords = 32
+	// SDMA data memory address of channel contexts
+	contextBase = 2048
+
+	// memory to memory channel
+	memChannel = 1
+
+	// channel priorities
+	priorityHigh    = 7
+	priorityDefault = 1
+)
+
+// Timeout is the maximum duration for a channel 0 command or MemCopy()
+// transfer to complete.
+var Timeout = 100 * time.Millisecond
+
+var mutex sync.Mutex
+
+var (
+	mc0ptr  = reg.New(SDMA_MC0PTR)
+	intr    = reg.New(SDMA_INTR)
+	hstart  = reg.New(SDMA_HSTART)
+	evtovr  = reg.New(SDMA_EVTOVR)
+	dspovr  = reg.New(SDMA_DSPOVR)
+	hostovr = reg.New(SDMA_HOSTOVR)
+	reset   = reg.New(SDMA_RESET)
+	config  = reg.New(SDMA_CONFIG)
+)
+
+// controller data structures, allocated from the DMA region
+var (
+	ccb     []byte
+	ccbAddr uint32
+
+	// channel 0 and memory channel buffer descriptors, followed by the
+	// context load buffer
+	desc     []byte
+	descAddr uint32
+)
+
+func bd(ch int) (buf []byte, addr uint32) {
+	off := ch * bdSize
+	return desc[off : off+bdSize], descAddr + uint32(off)
+}
+
+func contextBuffer() (buf []byte, addr uint32) {
+	off := 2 * bdSize
+	return desc[off : off+contextWords*4], descAddr + uint32(off)
+}
+
+func setBD(buf []byte, command uint32, status uint32, count uint32, src uint32, dst uint32) {
+	binary.LittleEndian.PutUint32(buf[0:], command<<BD_COMMAND|status<<BD_STATUS|count<<BD_COUNT)
+	binary.LittleEndian.PutUint32(buf[4:], src)
+	binary.LittleEndian.PutUint32(buf[8:], dst)
+}
+
+func setCCB(ch int, addr uint32) {
+	off := ch * ccbSize
+	// current and base buffer descriptor pointers
+	binary.LittleEndian.PutUint32(ccb[off:], addr)
+	binary.LittleEndian.PutUint32(ccb[off+4:], addr)
+	cache.CleanRange(uintptr(ccbAddr)+uintptr(off), ccbSize)
+}
+
+func setPriority(ch int, pri uint32) {
+	reg.New(SDMA_CHNPRI0 + uint32(4*ch)).Write(pri)
+}
+
+// setOwnership configures the argument channel to be started by the host
+// only, ignoring DMA request events and the DSP.
+func setOwnership(ch int) {
+	evtovr.Set(ch)
+	dspovr.Set(ch)
+	hostovr.Clear(ch)
+}
+
+// run starts the argument channel and waits for completion of its buffer
+// descriptor.
+func run(ch int) (err error) {
+	intr.Write(1 << uint(ch))
+	hstart.Write(1 << uint(ch))
+
+	if !intr.WaitFor(Timeout, ch, 0b1, 1) {
+		hstart.Clear(ch)
+		return errors.New("SDMA channel timeout")
+	}
+
+	intr.Write(1 << uint(ch))
+
+	buf, addr := bd(ch)
+	cache.InvalidateRange(uintptr(addr), bdSize)
+
+	if status := buf[2]; status&BD_RROR != 0 {
+		return errors.New("SDMA transfer error")
+	}
+
+	return
+}
+
+// loadContext loads the argument script entry point as context of the
+// argument channel, through a channel 0 SETDM command.
+func loadContext(ch int, pc uint32) (err error) {
+	ctx, ctxAddr := contextBuffer()
+
+	for i := range ctx {
+		ctx[i] = 0
+	}
+
+	// channel state: program counter
+	binary.LittleEndian.PutUint32(ctx[0:], pc)
+	cache.CleanRange(uintptr(ctxAddr), uintptr(len(ctx)))
+
+	buf, addr := bd(0)
+	setBD(buf, C0_SETDM, BD_DONE|BD_WRAP|BD_EXTD|BD_INTR, contextWords, ctxAddr, contextBase+uint32(contextWords*ch))
+	cache.CleanRange(uintptr(addr), bdSize)
+
+	return run(0)
+}
+
+// Init resets and initializes the SDMA controller, allocating its data
+// structures from the DMA region and configuring the memory to memory
+// channel.
+func Init() (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if ccb == nil {
+		if ccb, ccbAddr, err = dma.Alloc(CHANNELS*ccbSize, 0); err != nil {
+			return
+		}
+
+		if desc, descAddr, err = dma.Alloc(2*bdSize+contextWords*4, 0); err != nil {
+			return
+		}
+	}
+
+	if err = imx6.EnableClock(5, imx6.CCM_CCGR5_SDMA, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	reset.Set(SDMA_RESET_RESET)
+	reset.Wait(SDMA_RESET_RESET, 0b1, 0)
+
+	// disable channel 0 until its control block is set
+	mc0ptr.Write(0)
+
+	for i := range ccb {
+		ccb[i] = 0
+	}
+
+	cache.CleanRange(uintptr(ccbAddr), uintptr(len(ccb)))
+
+	for i := 0; i < CHANNELS; i++ {
+		setPriority(i, 0)
+	}
+
+	for i := 0; i < EVENTS; i++ {
+		reg.New(SDMA_CHNENBL0 + uint32(4*i)).Write(0)
+	}
+
+	_, bd0 := bd(0)
+	setCCB(0, bd0)
+	setOwnership(0)
+
+	reg.New(SDMA_CHN0ADDR).Write(CHN0ADDR)
+
+	config.Write(0)
+	mc0ptr.Write(ccbAddr)
+	config.Write(SDMA_CONFIG_CSM)
+
+	setPriority(0, priorityHigh)
+
+	_, bd1 := bd(memChannel)
+	setCCB(memChannel, bd1)
+	setOwnership(memChannel)
+	setPriority(memChannel, priorityDefault)
+
+	return loadContext(memChannel, AP_2_AP_ADDR)
+}
+
+// MemCopy copies n bytes from the src to the dst physical address with the
+// SDMA, waiting for the transfer to complete. Addresses and size must be
+// 32-bit aligned, transfers larger than BD_MAX_COUNT are split.
+//
+// The source is cleaned and the destination invalidated from the data
+// cache, therefore neither range should share cache lines with data
+// concurrently accessed by the ARM core.
+func MemCopy(dst uintptr, src uintptr, n int) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if ccb == nil {
+		return errors.New("controller not initialized")
+	}
+
+	if n <= 0 || (dst|src|uintptr(n))&3 != 0 {
+		return errors.New("invalid alignment or size")
+	}
+
+	cache.CleanRange(src, uintptr(n))
+	cache.FlushRange(dst, uintptr(n))
+
+	defer cache.InvalidateRange(dst, uintptr(n))
+
+	buf, addr := bd(memChannel)
+
+	for off := 0; off < n; off += BD_MAX_COUNT {
+		count := n - off
+
+		if count > BD_MAX_COUNT {
+			count = BD_MAX_COUNT
+		}
+
+		setBD(buf, 0, BD_DONE|BD_WRAP|BD_EXTD|BD_INTR|BD_LAST, uint32(count), uint32(src)+uint32(off), uint32(dst)+uint32(off))
+		cache.CleanRange(uintptr(addr), bdSize)
+
+		if err = run(memChannel); err != nil {
+			return
+		}
+	}
+
+	return
+}
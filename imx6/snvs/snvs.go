@@ -0,0 +1,104 @@
+// NXP Secure Non-Volatile Storage (SNVS) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package snvs implements a driver for the NXP i.MX6 Secure Non-Volatile
+// Storage (SNVS) low power real time counter, which keeps time across low
+// power states and warm resets.
+package snvs
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// SNVS Memory Map/Register Definition, IMX6ULLRM
+const (
+	SNVS_BASE uint32 = 0x020cc000
+
+	SNVS_LPCR          = 0x38
+	SNVS_LPCR_SRTC_ENV = 0
+
+	SNVS_LPSRTCMR = 0x50
+	SNVS_LPSRTCLR = 0x54
+
+	// The 47-bit counter is clocked at 32768 Hz, its lower 15 bits
+	// therefore represent fractions of a second.
+	SNVS_RTC_FRAC_BITS = 15
+	SNVS_RTC_MR_MASK   = 0x7fff
+)
+
+type snvs struct {
+	sync.Mutex
+
+	lpcr     *uint32
+	lpsrtcmr *uint32
+	lpsrtclr *uint32
+}
+
+var RTC = &snvs{
+	lpcr:     (*uint32)(unsafe.Pointer(uintptr(SNVS_BASE + SNVS_LPCR))),
+	lpsrtcmr: (*uint32)(unsafe.Pointer(uintptr(SNVS_BASE + SNVS_LPSRTCMR))),
+	lpsrtclr: (*uint32)(unsafe.Pointer(uintptr(SNVS_BASE + SNVS_LPSRTCLR))),
+}
+
+func (hw *snvs) enableClock() {
+	imx6.EnableClock(5, imx6.CCM_CCGR5_SNVS_HP, imx6.CCGR_ON)
+	imx6.EnableClock(5, imx6.CCM_CCGR5_SNVS_LP, imx6.CCGR_ON)
+}
+
+// counter reads the 47-bit counter, as the MR and LR registers cannot be
+// read atomically, reads are repeated until consistent.
+func (hw *snvs) counter() uint64 {
+	return reg.Read64(hw.lpsrtcmr, hw.lpsrtclr) & (uint64(SNVS_RTC_MR_MASK)<<32 | 0xffffffff)
+}
+
+// Now returns the time reported by the real time counter, which is
+// interpreted as time elapsed since the Unix epoch.
+func (hw *snvs) Now() time.Time {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.enableClock()
+	cnt := hw.counter()
+
+	sec := int64(cnt >> SNVS_RTC_FRAC_BITS)
+	frac := int64(cnt & (1<<SNVS_RTC_FRAC_BITS - 1))
+	nsec := frac * int64(time.Second) >> SNVS_RTC_FRAC_BITS
+
+	return time.Unix(sec, nsec)
+}
+
+// Set programs the real time counter with the argument time, the counter is
+// stopped during programming and (re-)enabled afterwards.
+func (hw *snvs) Set(t time.Time) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.enableClock()
+
+	sec := uint64(t.Unix())
+	frac := uint64(t.Nanosecond()) << SNVS_RTC_FRAC_BITS / uint64(time.Second)
+	cnt := sec<<SNVS_RTC_FRAC_BITS | frac
+
+	// disable the counter, which can only be written when stopped
+	reg.Clear(hw.lpcr, SNVS_LPCR_SRTC_ENV)
+	reg.Wait(hw.lpcr, SNVS_LPCR_SRTC_ENV, 0b1, 0)
+
+	reg.Write(hw.lpsrtcmr, uint32(cnt>>32)&SNVS_RTC_MR_MASK)
+	reg.Write(hw.lpsrtclr, uint32(cnt))
+
+	reg.Set(hw.lpcr, SNVS_LPCR_SRTC_ENV)
+	reg.Wait(hw.lpcr, SNVS_LPCR_SRTC_ENV, 0b1, 1)
+}
@@ -0,0 +1,260 @@
+// NXP Enhanced Configurable SPI (ECSPI) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package spi implements a master mode driver for the NXP i.MX6 Enhanced
+// Configurable SPI (ECSPI) controllers.
+//
+// The driver uses channel 0 (SS0), with an active low chip select asserted
+// for the whole duration of each Transfer(). The SPI pads must be configured
+// (see package iomuxc) before use, for example:
+//
+//	spi.ECSPI1.Init(0, 1000000)
+//	rx := make([]byte, 4)
+//	err := spi.ECSPI1.Transfer([]byte{0x9f, 0, 0, 0}, rx)
+package spi
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// ECSPI Memory Map/Register Definition, IMX6ULLRM
+const (
+	ECSPI1_BASE uint32 = 0x02008000
+	ECSPI2_BASE uint32 = 0x0200c000
+	ECSPI3_BASE uint32 = 0x02010000
+	ECSPI4_BASE uint32 = 0x02014000
+
+	ECSPI_RXDATA = 0x00
+	ECSPI_TXDATA = 0x04
+
+	ECSPI_CONREG                = 0x08
+	ECSPI_CONREG_BURST_LENGTH   = 20
+	ECSPI_CONREG_CHANNEL_SELECT = 18
+	ECSPI_CONREG_PRE_DIVIDER    = 12
+	ECSPI_CONREG_POST_DIVIDER   = 8
+	ECSPI_CONREG_CHANNEL_MODE   = 4
+	ECSPI_CONREG_SMC            = 3
+	ECSPI_CONREG_XCH            = 2
+	ECSPI_CONREG_EN             = 0
+
+	ECSPI_CONFIGREG          = 0x0c
+	ECSPI_CONFIGREG_SS_POL   = 12
+	ECSPI_CONFIGREG_SS_CTL   = 8
+	ECSPI_CONFIGREG_SCLK_POL = 4
+	ECSPI_CONFIGREG_SCLK_PHA = 0
+
+	ECSPI_STATREG    = 0x18
+	ECSPI_STATREG_TC = 7
+	ECSPI_STATREG_RR = 3
+	ECSPI_STATREG_TF = 2
+
+	// maximum burst length, in bytes (4096 bits)
+	MAX_BURST = 512
+)
+
+// Timeout is the maximum duration for a Transfer().
+var Timeout = 100 * time.Millisecond
+
+// Bus represents an SPI master, allowing device drivers to be agnostic of the
+// bus implementation.
+type Bus interface {
+	// Transfer performs a full-duplex SPI transfer, within a single chip
+	// select assertion, transmitting tx while receiving in rx, which must
+	// have the same length.
+	Transfer(tx []byte, rx []byte) error
+}
+
+var _ Bus = (*ecspi)(nil)
+
+type ecspi struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	rxdata    *uint32
+	txdata    *uint32
+	conreg    *uint32
+	configreg *uint32
+	statreg   *uint32
+}
+
+func newECSPI(base uint32, cg int) *ecspi {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &ecspi{
+		cg:        cg,
+		rxdata:    r(ECSPI_RXDATA),
+		txdata:    r(ECSPI_TXDATA),
+		conreg:    r(ECSPI_CONREG),
+		configreg: r(ECSPI_CONFIGREG),
+		statreg:   r(ECSPI_STATREG),
+	}
+}
+
+var ECSPI1 = newECSPI(ECSPI1_BASE, imx6.CCM_CCGR1_ECSPI1)
+var ECSPI2 = newECSPI(ECSPI2_BASE, imx6.CCM_CCGR1_ECSPI2)
+var ECSPI3 = newECSPI(ECSPI3_BASE, imx6.CCM_CCGR1_ECSPI3)
+var ECSPI4 = newECSPI(ECSPI4_BASE, imx6.CCM_CCGR1_ECSPI4)
+
+// Init initializes the ECSPI controller for master mode operation with the
+// argument SPI mode (0-3, clock polarity in bit 1 and phase in bit 0) and
+// clock speed (in Hz), which is approximated with the closest lower or
+// equal frequency achievable from the ECSPI clock root.
+func (hw *ecspi) Init(mode int, speedHz int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if mode < 0 || mode > 3 {
+		return errors.New("invalid SPI mode")
+	}
+
+	if speedHz <= 0 {
+		return errors.New("invalid speed")
+	}
+
+	pre, post, err := divider(imx6.ECSPIFreq(), uint32(speedHz))
+
+	if err != nil {
+		return
+	}
+
+	if err = imx6.EnableClock(1, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	// reset
+	reg.Write(hw.conreg, 0)
+
+	conreg := uint32(1 << ECSPI_CONREG_EN)
+	// channel 0 in master mode
+	conreg |= 1 << ECSPI_CONREG_CHANNEL_MODE
+	// start transfers as soon as TXFIFO is written
+	conreg |= 1 << ECSPI_CONREG_SMC
+	conreg |= pre << ECSPI_CONREG_PRE_DIVIDER
+	conreg |= post << ECSPI_CONREG_POST_DIVIDER
+
+	reg.Write(hw.conreg, conreg)
+
+	// single burst per chip select assertion, active low chip select
+	var configreg uint32
+
+	if mode&0b10 != 0 {
+		configreg |= 1 << ECSPI_CONFIGREG_SCLK_POL
+	}
+
+	if mode&0b01 != 0 {
+		configreg |= 1 << ECSPI_CONFIGREG_SCLK_PHA
+	}
+
+	reg.Write(hw.configreg, configreg)
+
+	return
+}
+
+// divider returns the pre (0-15) and post (0-15) dividers for the highest
+// SPI clock lower or equal to the argument speed:
+//
+//	SCLK = ref / (PRE_DIVIDER + 1) / 2^POST_DIVIDER
+func divider(ref uint32, speed uint32) (pre uint32, post uint32, err error) {
+	for post = 0; post < 16; post++ {
+		for pre = 0; pre < 16; pre++ {
+			if (ref/(pre+1))>>post <= speed {
+				return
+			}
+		}
+	}
+
+	return 0, 0, errors.New("unsupported speed")
+}
+
+// Transfer performs a full-duplex SPI transfer, within a single chip select
+// assertion, transmitting tx while receiving in rx, which must have the same
+// length (up to MAX_BURST bytes).
+func (hw *ecspi) Transfer(tx []byte, rx []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	n := len(tx)
+
+	if n != len(rx) {
+		return errors.New("tx and rx buffer size mismatch")
+	}
+
+	if n == 0 {
+		return
+	}
+
+	if n > MAX_BURST {
+		return errors.New("transfer exceeds maximum burst length")
+	}
+
+	reg.SetN(hw.conreg, ECSPI_CONREG_BURST_LENGTH, 0xfff, uint32(n*8-1))
+
+	// The first word transmitted carries the burst remainder bits (if
+	// any), all other words are 32 bits, bytes are sent MSB first.
+	words := make([]int, 0, n/4+1)
+
+	if r := n % 4; r != 0 {
+		words = append(words, r)
+	}
+
+	for i := 0; i < n/4; i++ {
+		words = append(words, 4)
+	}
+
+	start := time.Now()
+	txOff, rxOff, txWord, rxWord := 0, 0, 0, 0
+
+	for rxWord < len(words) {
+		if txWord < len(words) && reg.Get(hw.statreg, ECSPI_STATREG_TF, 0b1) == 0 {
+			var w uint32
+
+			for _, b := range tx[txOff : txOff+words[txWord]] {
+				w = w<<8 | uint32(b)
+			}
+
+			reg.Write(hw.txdata, w)
+
+			txOff += words[txWord]
+			txWord++
+		}
+
+		if reg.Get(hw.statreg, ECSPI_STATREG_RR, 0b1) == 1 {
+			w := reg.Read(hw.rxdata)
+			size := words[rxWord]
+
+			for i := 0; i < size; i++ {
+				rx[rxOff+i] = byte(w >> uint(8*(size-1-i)))
+			}
+
+			rxOff += size
+			rxWord++
+		}
+
+		if time.Since(start) > Timeout {
+			return errors.New("timeout")
+		}
+	}
+
+	// clear transfer completed status
+	reg.Write(hw.statreg, 1<<ECSPI_STATREG_TC)
+
+	return
+}
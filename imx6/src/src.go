@@ -0,0 +1,129 @@
+// NXP System Reset Controller (SRC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package src implements a driver for the NXP i.MX6 System Reset Controller
+// (SRC), reporting the source of the last reset and releasing secondary
+// cores, on multi-core families, from reset:
+//
+//	if src.BootReason()&src.REASON_WATCHDOG != 0 {
+//		// recovery mode
+//	}
+//
+//	src.ClearBootReason()
+package src
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// SRC Memory Map/Register Definition, IMX6ULLRM
+const (
+	SRC_BASE uint32 = 0x020d8000
+
+	// p1283, 59.6.1 SRC Control Register (SRC_SCR), IMX6DQRM
+	SRC_SCR              = SRC_BASE + 0x00
+	SRC_SCR_CORE1_ENABLE = 22
+	SRC_SCR_CORE1_RST    = 14
+
+	SRC_SRSR                  = SRC_BASE + 0x08
+	SRC_SRSR_WARM_BOOT        = 16
+	SRC_SRSR_TEMPSENSE_RST_B  = 8
+	SRC_SRSR_WDOG3_RST_B      = 7
+	SRC_SRSR_JTAG_SW_RST      = 6
+	SRC_SRSR_JTAG_RST_B       = 5
+	SRC_SRSR_WDOG_RST_B       = 4
+	SRC_SRSR_IPP_USER_RESET_B = 3
+	SRC_SRSR_CSU_RESET_B      = 2
+	SRC_SRSR_IPP_RESET_B      = 0
+
+	// core n entry point (GPR1 + n*8) and argument (GPR2 + n*8)
+	SRC_GPR1 = SRC_BASE + 0x20
+	SRC_GPR2 = SRC_BASE + 0x24
+)
+
+// Reset sources, as returned by BootReason()
+const (
+	// power-on reset (POR_B)
+	REASON_POWER_ON = 1 << SRC_SRSR_IPP_RESET_B
+	// CSU alarm reset
+	REASON_CSU = 1 << SRC_SRSR_CSU_RESET_B
+	// cold reset button (ONOFF)
+	REASON_USER = 1 << SRC_SRSR_IPP_USER_RESET_B
+	// WDOG1/WDOG2 timeout or software reset (see package wdog)
+	REASON_WATCHDOG = 1 << SRC_SRSR_WDOG_RST_B
+	// HIGH-Z JTAG reset
+	REASON_JTAG = 1 << SRC_SRSR_JTAG_RST_B
+	// JTAG software reset
+	REASON_JTAG_SW = 1 << SRC_SRSR_JTAG_SW_RST
+	// WDOG3 timeout reset
+	REASON_WATCHDOG3 = 1 << SRC_SRSR_WDOG3_RST_B
+	// temperature sensor software reset
+	REASON_TEMPSENSE = 1 << SRC_SRSR_TEMPSENSE_RST_B
+	// warm boot, initiated by software
+	REASON_WARM_BOOT = 1 << SRC_SRSR_WARM_BOOT
+
+	REASON_MASK = REASON_POWER_ON | REASON_CSU | REASON_USER |
+		REASON_WATCHDOG | REASON_JTAG | REASON_JTAG_SW |
+		REASON_WATCHDOG3 | REASON_TEMPSENSE | REASON_WARM_BOOT
+)
+
+var mutex sync.Mutex
+
+var srsr = reg.New(SRC_SRSR)
+
+// BootReason returns the reset sources (any combination of REASON_*
+// constants) recorded since they were last cleared.
+func BootReason() uint32 {
+	return srsr.Read() & REASON_MASK
+}
+
+// ClearBootReason clears the recorded reset sources, so that only sources of
+// the following reset are reported by BootReason().
+func ClearBootReason() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// reset status bits are cleared by writing 1
+	srsr.Write(srsr.Read() & REASON_MASK)
+}
+
+// StartCore sets the entry point of a secondary ARM core (1 to imx6.Cores() -
+// 1) and releases it from reset, on multi-core families (i.MX6Q). An error is
+// returned on single-core families (i.MX6UL, i.MX6ULL).
+//
+// The entry point is executed with the MMU and caches disabled, the Go
+// runtime does not schedule goroutines on secondary cores and therefore the
+// entry point must be a function which does not depend on it (e.g. assembly).
+func StartCore(n int, entry uintptr) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if imx6.Family != imx6.IMX6Q || imx6.Cores() <= 1 {
+		return errors.New("secondary cores not available")
+	}
+
+	if n < 1 || n >= imx6.Cores() {
+		return errors.New("invalid core")
+	}
+
+	gpr := reg.New(SRC_GPR1 + uint32(n*8))
+	gpr.Write(uint32(entry))
+
+	scr := reg.New(SRC_SCR)
+	scr.Set(SRC_SCR_CORE1_RST + (n - 1))
+	scr.Set(SRC_SCR_CORE1_ENABLE + (n - 1))
+
+	return
+}
@@ -0,0 +1,257 @@
+// NXP i.MX6UL on-die temperature monitor (TEMPMON) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6/gic"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+const (
+	// Temperature Monitor (TEMPMON), IMX6ULLRM
+	TEMPMON_TEMPSENSE0              uint32 = 0x020c8180
+	TEMPMON_TEMPSENSE0_ALARM_VALUE         = 20
+	TEMPMON_TEMPSENSE0_TEMP_CNT            = 8
+	TEMPMON_TEMPSENSE0_FINISHED            = 2
+	TEMPMON_TEMPSENSE0_MEASURE_TEMP        = 1
+	TEMPMON_TEMPSENSE0_POWER_DOWN          = 0
+
+	TEMPMON_TEMPSENSE1              uint32 = 0x020c8190
+	TEMPMON_TEMPSENSE1_MEASURE_FREQ        = 0
+
+	// Miscellaneous Register 1 (ANADIG_MISC1), IMX6ULLRM
+	ANADIG_MISC1               uint32 = 0x020c8160
+	ANADIG_MISC1_IRQ_DIG_BO           = 31
+	ANADIG_MISC1_IRQ_ANA_BO           = 30
+	ANADIG_MISC1_IRQ_TEMPHIGH         = 29
+	ANADIG_MISC1_IRQ_TEMPLOW          = 28
+	ANADIG_MISC1_IRQ_TEMPPANIC        = 27
+
+	// TEMPMON high temperature alarm interrupt (GIC interrupt ID)
+	TEMPMON_IRQ      = 32 + 49
+	TEMPMON_PRIORITY = 0x80
+
+	// measurement interval for SetAlarm, in 32 kHz clock cycles (~100 ms)
+	TEMPMON_ALARM_FREQ = 3277
+
+	// Value of OTP Bank1 Word6 (Temperature Sensor Calibration), IMX6ULLRM
+	OCOTP_ANA1          uint32 = 0x021bc4e0
+	OCOTP_ANA1_ROOM_CNT        = 20
+	OCOTP_ANA1_HOT_CNT         = 8
+	OCOTP_ANA1_HOT_TEMP        = 0
+
+	// room temperature for OCOTP_ANA1_ROOM_CNT calibration
+	TEMPMON_ROOM_TEMP = 25
+
+	// highest ARM core frequency allowed above ThermalLimit
+	THERMAL_MAX_ARM_FREQ = 528000000
+)
+
+// ThermalLimit represents the die temperature (in Celsius) above which
+// SetARMFreq clamps the requested ARM core frequency to THERMAL_MAX_ARM_FREQ,
+// a zero value (default) disables the check.
+var ThermalLimit float32
+
+var tempmonMutex sync.Mutex
+
+// tempmonAlarm indicates an armed alarm (continuous measurement active)
+var tempmonAlarm bool
+
+// tempmonCalibration returns the TEMPMON calibration values programmed in
+// OCOTP fuses.
+func tempmonCalibration() (roomCnt float32, hotCnt float32, hotTemp float32, err error) {
+	ana1 := (*uint32)(unsafe.Pointer(uintptr(OCOTP_ANA1)))
+	cal := *ana1
+
+	if cal == 0 || cal == 0xffffffff {
+		err = errors.New("uncalibrated temperature sensor")
+		return
+	}
+
+	roomCnt = float32((cal >> OCOTP_ANA1_ROOM_CNT) & 0xfff)
+	hotCnt = float32((cal >> OCOTP_ANA1_HOT_CNT) & 0xfff)
+	hotTemp = float32((cal >> OCOTP_ANA1_HOT_TEMP) & 0xff)
+
+	if roomCnt == hotCnt {
+		err = errors.New("invalid temperature sensor calibration")
+	}
+
+	return
+}
+
+// ReadTemperature performs a single die temperature measurement, the result
+// is converted to Celsius using the calibration values programmed in OCOTP
+// fuses.
+func ReadTemperature() (celsius float32, err error) {
+	roomCnt, hotCnt, hotTemp, err := tempmonCalibration()
+
+	if err != nil {
+		return
+	}
+
+	tempmonMutex.Lock()
+	defer tempmonMutex.Unlock()
+
+	sense0 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE0)))
+	sense1 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE1)))
+
+	if tempmonAlarm {
+		// continuous measurement is active, use the latest result
+		cnt := float32(reg.Get(sense0, TEMPMON_TEMPSENSE0_TEMP_CNT, 0xfff))
+		celsius = hotTemp - (cnt-hotCnt)*(hotTemp-TEMPMON_ROOM_TEMP)/(roomCnt-hotCnt)
+		return
+	}
+
+	// single measurement
+	reg.SetN(sense1, TEMPMON_TEMPSENSE1_MEASURE_FREQ, 0xffff, 0)
+
+	// power up and start measurement
+	reg.Clear(sense0, TEMPMON_TEMPSENSE0_POWER_DOWN)
+	reg.Set(sense0, TEMPMON_TEMPSENSE0_MEASURE_TEMP)
+
+	reg.Wait(sense0, TEMPMON_TEMPSENSE0_FINISHED, 0b1, 1)
+	cnt := float32(reg.Get(sense0, TEMPMON_TEMPSENSE0_TEMP_CNT, 0xfff))
+
+	// stop measurement and power down
+	reg.Clear(sense0, TEMPMON_TEMPSENSE0_MEASURE_TEMP)
+	reg.Set(sense0, TEMPMON_TEMPSENSE0_POWER_DOWN)
+
+	// linear interpolation between the room and hot calibration points
+	celsius = hotTemp - (cnt-hotCnt)*(hotTemp-TEMPMON_ROOM_TEMP)/(roomCnt-hotCnt)
+
+	return
+}
+
+// SetAlarm enables continuous die temperature measurement and invokes the
+// argument function once the temperature exceeds the argument threshold (in
+// Celsius), converted to a TEMPMON count using the calibration values
+// programmed in OCOTP fuses. Any previously configured alarm is cancelled.
+//
+// The alarm is one-shot: once triggered, continuous measurement is stopped
+// and SetAlarm must be invoked again to re-arm it (e.g. after lowering the
+// ARM core frequency within the callback).
+//
+// The function is invoked from the TEMPMON_IRQ GIC interrupt handler,
+// therefore the GIC must be initialized and serviced by the application (see
+// package gic):
+//
+//	gic.Init()
+//	imx6.SetAlarm(90, throttle)
+//	gic.Start()
+func SetAlarm(celsius float32, fn func()) (err error) {
+	if fn == nil {
+		return errors.New("invalid alarm function")
+	}
+
+	roomCnt, hotCnt, hotTemp, err := tempmonCalibration()
+
+	if err != nil {
+		return
+	}
+
+	cnt := hotCnt + (hotTemp-celsius)*(roomCnt-hotCnt)/(hotTemp-TEMPMON_ROOM_TEMP)
+
+	if cnt < 0 || cnt > 0xfff {
+		return errors.New("alarm temperature out of range")
+	}
+
+	tempmonMutex.Lock()
+	defer tempmonMutex.Unlock()
+
+	if tempmonAlarm {
+		stopAlarm()
+	}
+
+	sense0 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE0)))
+	sense1 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE1)))
+
+	reg.Clear(sense0, TEMPMON_TEMPSENSE0_MEASURE_TEMP)
+
+	reg.SetN(sense0, TEMPMON_TEMPSENSE0_ALARM_VALUE, 0xfff, uint32(cnt))
+	reg.SetN(sense1, TEMPMON_TEMPSENSE1_MEASURE_FREQ, 0xffff, TEMPMON_ALARM_FREQ)
+
+	// clear any pending event before arming
+	clearTempHigh()
+
+	gic.SetHandler(TEMPMON_IRQ, func() {
+		tempmonMutex.Lock()
+
+		if !tempmonAlarm {
+			clearTempHigh()
+			tempmonMutex.Unlock()
+			return
+		}
+
+		clearTempHigh()
+		stopAlarm()
+		tempmonMutex.Unlock()
+
+		fn()
+	})
+
+	if err = gic.EnableInterrupt(TEMPMON_IRQ, TEMPMON_PRIORITY); err != nil {
+		gic.SetHandler(TEMPMON_IRQ, nil)
+		return
+	}
+
+	// power up and start continuous measurement
+	reg.Clear(sense0, TEMPMON_TEMPSENSE0_POWER_DOWN)
+	reg.Set(sense0, TEMPMON_TEMPSENSE0_MEASURE_TEMP)
+
+	tempmonAlarm = true
+
+	return
+}
+
+// ClearAlarm cancels any alarm configured with SetAlarm and stops continuous
+// measurement.
+func ClearAlarm() {
+	tempmonMutex.Lock()
+	defer tempmonMutex.Unlock()
+
+	if tempmonAlarm {
+		stopAlarm()
+	}
+}
+
+// clearTempHigh acknowledges the IRQ_TEMPHIGH event, which is write-1-to-clear
+// like the other ANADIG_MISC1 interrupt flags: these are written as zero, to
+// leave them pending, while the remaining configuration fields are preserved.
+func clearTempHigh() {
+	misc1 := (*uint32)(unsafe.Pointer(uintptr(ANADIG_MISC1)))
+
+	w1c := uint32(1<<ANADIG_MISC1_IRQ_DIG_BO |
+		1<<ANADIG_MISC1_IRQ_ANA_BO |
+		1<<ANADIG_MISC1_IRQ_TEMPHIGH |
+		1<<ANADIG_MISC1_IRQ_TEMPLOW |
+		1<<ANADIG_MISC1_IRQ_TEMPPANIC)
+
+	reg.Write(misc1, reg.Read(misc1)&^w1c|1<<ANADIG_MISC1_IRQ_TEMPHIGH)
+}
+
+func stopAlarm() {
+	sense0 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE0)))
+	sense1 := (*uint32)(unsafe.Pointer(uintptr(TEMPMON_TEMPSENSE1)))
+
+	gic.DisableInterrupt(TEMPMON_IRQ)
+	gic.SetHandler(TEMPMON_IRQ, nil)
+
+	reg.Clear(sense0, TEMPMON_TEMPSENSE0_MEASURE_TEMP)
+	reg.SetN(sense1, TEMPMON_TEMPSENSE1_MEASURE_FREQ, 0xffff, 0)
+	reg.Set(sense0, TEMPMON_TEMPSENSE0_POWER_DOWN)
+
+	tempmonAlarm = false
+}
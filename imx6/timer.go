@@ -12,19 +12,60 @@
 package imx6
 
 import (
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 	_ "unsafe"
 )
 
 // nanoseconds
 const refFreq int64 = 1000000000
 
+// estimated number of ARM core cycles for each busyloop iteration
+const busyloopCycles = 3
+
 var timerFn func() int64
+var timerOffset int64
+
+// Timer counter to nanoseconds conversion: timerMultiplier is used when the
+// counter frequency divides refFreq, otherwise timerHz holds the frequency
+// for an exact conversion (see timerNano).
 var timerMultiplier int64
+var timerHz int64
 
 // defined in timer_arm.s
 func read_gtc() int64
 func read_cntpct() int64
 func busyloop(int32)
+func read_cntvct() int64
+func read_cntfrq() uint32
+func write_cntv_tval(val int32)
+func read_cntv_ctl() uint32
+func write_cntv_ctl(val uint32)
+func write_cntp_tval(val int32)
+func write_cntp_ctl(val uint32)
+
+// Virtual Timer Control register (CNTV_CTL) bits
+const (
+	CNTV_CTL_ISTATUS = 1 << 2
+	CNTV_CTL_IMASK   = 1 << 1
+	CNTV_CTL_ENABLE  = 1 << 0
+
+	// virtual timer interrupt (PPI) ID
+	CNTV_PPI = 27
+	// secure physical timer interrupt (PPI) ID, tamago executes in Secure
+	// state, see Idle()
+	CNTP_PPI = 29
+)
+
+var (
+	// virtual timer timeout request identifier
+	timeoutMutex sync.Mutex
+	timeoutID    uint64
+)
 
 // initGlobalTimers initializes ARM Cortex-A9 timers
 func initGlobalTimers() {
@@ -52,5 +93,168 @@ func initGenericTimers() {
 
 //go:linkname nanotime runtime.nanotime
 func nanotime() int64 {
-	return int64(timerFn() * timerMultiplier)
+	return timerNano(timerFn()) + timerOffset
+}
+
+// timerNano converts a timer count to nanoseconds, frequencies which do not
+// divide refFreq (e.g. 24 MHz) are converted splitting whole seconds from the
+// remainder to avoid both drift and overflow.
+func timerNano(t int64) int64 {
+	if timerHz != 0 {
+		return (t/timerHz)*refFreq + (t%timerHz)*refFreq/timerHz
+	}
+
+	return t * timerMultiplier
+}
+
+// SetTimer replaces the counter used as runtime monotonic clock source
+// (affecting time.Now(), time.Sleep(), etc.), the argument function must
+// return a free-running counter incremented at the argument frequency (in
+// hertz), which is not required to divide 1 GHz.
+//
+// The counter is offset to ensure that the monotonic clock does not jump
+// when switching source. As the function is invoked by the runtime it must
+// not block, allocate or yield.
+func SetTimer(fn func() int64, hz int64) {
+	now := nanotime()
+
+	if refFreq%hz == 0 {
+		timerMultiplier = refFreq / hz
+		timerHz = 0
+	} else {
+		timerMultiplier = 0
+		timerHz = hz
+	}
+
+	timerFn = fn
+	timerOffset = now - timerNano(fn())
+}
+
+// number of busyloop iterations for 1 ms (see calibrateDelay)
+var delayLoops uint64
+
+// calibration busyloop iterations
+const calibrationLoops = 100000
+
+func init() {
+	calibrateDelay()
+}
+
+// calibrateDelay measures, against the runtime monotonic clock, the number of
+// busyloop iterations matching 1 ms at the current ARM core frequency. It is
+// invoked at initialization and on every ARM core frequency change.
+func calibrateDelay() {
+	start := nanotime()
+	busyloop(calibrationLoops)
+	elapsed := nanotime() - start
+
+	if elapsed <= 0 {
+		// clock source too coarse, fall back to the cycle estimate
+		atomic.StoreUint64(&delayLoops, uint64(CachedARMFreq())/1000/busyloopCycles)
+		return
+	}
+
+	atomic.StoreUint64(&delayLoops, uint64(calibrationLoops)*1000000/uint64(elapsed))
+}
+
+// Delay waits for the argument number of microseconds by spinning the ARM
+// core, as DelayUS().
+func Delay(us uint32) {
+	DelayUS(us)
+}
+
+// DelayUS waits for the argument number of microseconds by spinning the ARM
+// core, the number of busyloop iterations is derived from a calibration
+// performed at initialization and repeated on each ARM core frequency change
+// (see SetARMFreq()), therefore the delay is accurate at any operating point.
+//
+// Before runtime initialization, when no calibration is yet available, the
+// number of iterations is estimated from the current ARM core frequency.
+func DelayUS(us uint32) {
+	var n uint64
+
+	if loops := atomic.LoadUint64(&delayLoops); loops != 0 {
+		n = loops * uint64(us) / 1000
+	} else {
+		n = uint64(CachedARMFreq()) / 1000000 * uint64(us) / busyloopCycles
+	}
+
+	for n > 0 {
+		i := n
+
+		if i > math.MaxInt32 {
+			i = math.MaxInt32
+		}
+
+		busyloop(int32(i))
+		n -= i
+	}
+}
+
+// ReadCounter returns the ARM generic timer virtual count (CNTVCT), the
+// counter is clocked independently from the ARM core frequency.
+func ReadCounter() uint64 {
+	return uint64(read_cntvct())
+}
+
+// Frequency returns the ARM generic timer frequency, as programmed in CNTFRQ
+// by the boot loader.
+func Frequency() uint32 {
+	return read_cntfrq()
+}
+
+// SetTimeout programs the ARM generic virtual timer to execute the argument
+// function once the timeout elapses, any previously set timeout is
+// cancelled.
+//
+// The virtual timer condition raises its PPI (CNTV_PPI), which can be routed
+// through the GIC, however the function is invoked from a goroutine polling
+// the timer status.
+func SetTimeout(d time.Duration, fn func()) (err error) {
+	freq := uint64(Frequency())
+
+	if freq == 0 {
+		return errors.New("generic timer frequency not set")
+	}
+
+	ticks := uint64(d) * freq / uint64(time.Second)
+
+	if d < 0 || ticks > math.MaxInt32 {
+		return errors.New("invalid timeout")
+	}
+
+	timeoutMutex.Lock()
+	timeoutID += 1
+	id := timeoutID
+
+	write_cntv_ctl(0)
+	write_cntv_tval(int32(ticks))
+	write_cntv_ctl(CNTV_CTL_ENABLE)
+	timeoutMutex.Unlock()
+
+	go func() {
+		for {
+			runtime.Gosched()
+
+			timeoutMutex.Lock()
+
+			if timeoutID != id {
+				timeoutMutex.Unlock()
+				return
+			}
+
+			if read_cntv_ctl()&CNTV_CTL_ISTATUS != 0 {
+				write_cntv_ctl(0)
+				timeoutID += 1
+				timeoutMutex.Unlock()
+
+				fn()
+				return
+			}
+
+			timeoutMutex.Unlock()
+		}
+	}()
+
+	return
 }
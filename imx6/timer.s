@@ -49,3 +49,65 @@ loop:
 	BNE	loop
 
 	RET
+
+// func read_cntvct() int64
+TEXT ·read_cntvct(SB),$0
+	// B4.1.34 CNTVCT, Virtual Count register, VMSA
+	WORD	$0xf57ff06f // isb sy
+	WORD	$0xec510f1e // mrrc p15, 1, r0, r1, c14
+
+	MOVW	R0, ret_lo+0(FP)
+	MOVW	R1, ret_hi+4(FP)
+
+	RET
+
+// func read_cntfrq() uint32
+TEXT ·read_cntfrq(SB),$0
+	// B4.1.21 CNTFRQ, Counter Frequency register, VMSA
+	MRC	15, 0, R0, C14, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_cntv_tval(val int32)
+TEXT ·write_cntv_tval(SB),$0
+	// B4.1.36 CNTV_TVAL, Virtual TimerValue register, VMSA
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C14, C3, 0
+	WORD	$0xf57ff06f // isb sy
+
+	RET
+
+// func read_cntv_ctl() uint32
+TEXT ·read_cntv_ctl(SB),$0
+	// B4.1.35 CNTV_CTL, Virtual Timer Control register, VMSA
+	MRC	15, 0, R0, C14, C3, 1
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_cntv_ctl(val uint32)
+TEXT ·write_cntv_ctl(SB),$0
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C14, C3, 1
+	WORD	$0xf57ff06f // isb sy
+
+	RET
+
+// func write_cntp_tval(val int32)
+TEXT ·write_cntp_tval(SB),$0
+	// B4.1.29 CNTP_TVAL, PL1 Physical TimerValue register, VMSA
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C14, C2, 0
+	WORD	$0xf57ff06f // isb sy
+
+	RET
+
+// func write_cntp_ctl(val uint32)
+TEXT ·write_cntp_ctl(SB),$0
+	// B4.1.27 CNTP_CTL, PL1 Physical Timer Control register, VMSA
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C14, C2, 1
+	WORD	$0xf57ff06f // isb sy
+
+	RET
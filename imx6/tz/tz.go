@@ -0,0 +1,131 @@
+// ARM TrustZone monitor support
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package tz provides minimal ARM TrustZone monitor mode support, for the
+// Cortex-A7 Security Extensions, to execute a Non-secure payload and handle
+// its Secure Monitor Calls (SMC).
+//
+// The monitor vector table (MVBAR) is installed on first use. Monitor mode
+// has its own banked LR and SPSR (and SP, left unused by this package), while
+// the Secure and Non-secure worlds have separate banked copies of several CP15
+// registers (e.g. SCTLR, VBAR, TTBR0/1, DACR), the Non-secure payload must
+// therefore configure its own MMU, caches and exception vectors.
+//
+// Peripheral and memory access from the Non-secure world must be granted
+// beforehand (see package csu).
+package tz
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/mem"
+)
+
+const (
+	// B4.1.129 SCR, Secure Configuration Register, ARMv7-AR ARM
+	SCR_NS = 1 << 0
+
+	// vector table alignment (MVBAR)
+	VECTOR_ALIGN = 32
+
+	// monitor vector table entries
+	VECTORS    = 8
+	VECTOR_SMC = 2
+
+	// ldr pc, [pc, #24]
+	LDR_PC_PC_24 = 0xe59ff018
+)
+
+// defined in tz.s
+func set_mvbar(addr uint32)
+func handlers() (smc uint32, unexpected uint32)
+func smc_enter(entry uint32)
+
+var (
+	mutex   sync.Mutex
+	vectors *mem.AlignmentBuffer
+)
+
+// smcHandler holds the address of the Non-secure SMC handler, referenced by
+// monitor_smc in tz.s.
+var smcHandler uint32
+
+func installVectors() {
+	if vectors != nil {
+		return
+	}
+
+	smc, unexpected := handlers()
+
+	// each vector loads the PC from the address table which follows
+	vectors = mem.NewAlignmentBuffer(VECTORS*8, VECTOR_ALIGN)
+	buf := vectors.Data()
+
+	for i := 0; i < VECTORS; i++ {
+		addr := unexpected
+
+		if i == VECTOR_SMC {
+			addr = smc
+		}
+
+		binary.LittleEndian.PutUint32(buf[i*4:], LDR_PC_PC_24)
+		binary.LittleEndian.PutUint32(buf[VECTORS*4+i*4:], addr)
+	}
+
+	cache.FlushData()
+	cache.FlushInstruction()
+
+	set_mvbar(uint32(vectors.Addr))
+}
+
+// SetSMCHandler registers the address of the handler executed, in monitor
+// mode, on SMC exceptions raised by the Non-secure world. A zero address
+// removes the handler, unhandled calls return 0xffffffff in R0 (SMC Calling
+// Convention unknown function identifier).
+//
+// The tamago runtime cannot execute Go code in monitor mode, the handler must
+// therefore be an assembly function which preserves R1-R11 and SP or sets up
+// its own monitor mode stack, leaves LR and SPSR unmodified and returns to
+// the Non-secure world with `movs pc, lr`. Registers R0-R3 hold the call
+// arguments and results, R12 holds the handler address.
+func SetSMCHandler(addr uintptr) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	installVectors()
+	smcHandler = uint32(addr)
+
+	cache.FlushData()
+}
+
+// EnterNonSecure switches to the Non-secure world and branches to the argument
+// entry point, in SVC mode with interrupts masked. The switch is performed in
+// monitor mode, entered with an SMC, by setting SCR.NS before returning to
+// the entry point.
+//
+// The function does not return, the Secure world runtime is no longer
+// executed unless a Non-secure SMC handler (see SetSMCHandler) resumes it.
+func EnterNonSecure(entry uintptr) (err error) {
+	if entry == 0 {
+		return errors.New("invalid entry point")
+	}
+
+	mutex.Lock()
+	installVectors()
+	mutex.Unlock()
+
+	smc_enter(uint32(entry))
+
+	return
+}
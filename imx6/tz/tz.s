@@ -0,0 +1,71 @@
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func set_mvbar(addr uint32)
+TEXT ·set_mvbar(SB),$0-4
+	// ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition
+	//
+	// B4.1.107 MVBAR, Monitor Vector Base Address Register, Security Extensions
+	MOVW	addr+0(FP), R0
+	WORD	$0xee0c0f30 // mcr p15, 0, r0, c12, c0, 1
+	WORD	$0xf57ff06f // isb sy
+	RET
+
+// func handlers() (smc uint32, unexpected uint32)
+TEXT ·handlers(SB),$0-8
+	MOVW	$·monitor_smc(SB), R0
+	MOVW	R0, smc+0(FP)
+	MOVW	$·monitor_unexpected(SB), R0
+	MOVW	R0, unexpected+4(FP)
+	RET
+
+// func smc_enter(entry uint32)
+TEXT ·smc_enter(SB),$0-4
+	MOVW	entry+0(FP), R0
+	WORD	$0xe1600070 // smc #0
+
+	// not reached
+	B	0(PC)
+
+// monitor_smc is the monitor mode SMC exception handler, it uses only banked
+// (LR, SPSR) and scratch (R12) registers to preserve the caller state.
+TEXT ·monitor_smc(SB),NOSPLIT|NOFRAME,$0
+	// B4.1.129 SCR, Secure Configuration Register, Security Extensions
+	WORD	$0xee11cf11 // mrc p15, 0, r12, c1, c1, 0
+	TST	$1, R12
+	BNE	nonsecure
+
+	// Secure caller (smc_enter): switch to the Non-secure world and
+	// return to the entry point (R0) in SVC mode, with IRQ, FIQ and
+	// asynchronous aborts masked.
+	MOVW	R0, R14
+	ORR	$1, R12
+	WORD	$0xee01cf11 // mcr p15, 0, r12, c1, c1, 0
+	WORD	$0xf57ff06f // isb sy
+	MOVW	$0x1d3, R12
+	WORD	$0xe16ff00c // msr spsr_cxsf, r12
+	WORD	$0xe1b0f00e // movs pc, lr
+
+nonsecure:
+	// Non-secure caller: dispatch to the registered handler, if any
+	MOVW	$·smcHandler(SB), R12
+	MOVW	(R12), R12
+	CMP	$0, R12
+	BEQ	unknown
+	MOVW	R12, R15
+
+unknown:
+	// SMC Calling Convention unknown function identifier
+	MVN	$0, R0
+	WORD	$0xe1b0f00e // movs pc, lr
+
+// monitor_unexpected handles all other monitor mode exceptions.
+TEXT ·monitor_unexpected(SB),NOSPLIT|NOFRAME,$0
+	B	0(PC)
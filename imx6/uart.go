@@ -12,11 +12,37 @@
 package imx6
 
 import (
+	"errors"
+	"runtime"
+	"sync"
 	"unsafe"
 
 	"github.com/inversepath/tamago/imx6/internal/reg"
 )
 
+const UART1_BASE uint32 = 0x02020000
+const UART2_BASE uint32 = 0x021e8000
+const UART3_BASE uint32 = 0x021ec000
+const UART4_BASE uint32 = 0x021f0000
+const UART5_BASE uint32 = 0x021f4000
+const UART6_BASE uint32 = 0x021fc000
+const UART7_BASE uint32 = 0x02018000
+const UART8_BASE uint32 = 0x02024000
+
+// UART interrupts (GIC interrupt IDs), IMX6ULLRM
+const (
+	UART1_IRQ = 32 + 26
+	UART2_IRQ = 32 + 27
+	UART3_IRQ = 32 + 28
+	UART4_IRQ = 32 + 29
+	UART5_IRQ = 32 + 30
+	UART6_IRQ = 32 + 17
+	UART7_IRQ = 32 + 39
+	UART8_IRQ = 32 + 40
+
+	UART_PRIORITY = 0x80
+)
+
 const UART1_URXD uint32 = 0x02020000
 const UART1_UTXD uint32 = 0x02020040
 const UART1_UTS uint32 = 0x020200b4
@@ -27,46 +53,335 @@ const UART2_UTXD uint32 = 0x021e8040
 const UART2_UTS uint32 = 0x021e80b4
 const UART2_USR2 uint32 = 0x021e8098
 
+// UART Memory Map/Register Definition, IMX6ULLRM
+const (
+	UART_URXD = 0x00
+	UART_UTXD = 0x40
+
+	UART_UCR1        = 0x80
+	UART_UCR1_TRDYEN = 13
+	UART_UCR1_UARTEN = 0
+
+	UART_UCR2      = 0x84
+	UART_UCR2_IRTS = 14
+	UART_UCR2_PREN = 8
+	UART_UCR2_STPB = 6
+	UART_UCR2_WS   = 5
+	UART_UCR2_TXEN = 2
+	UART_UCR2_RXEN = 1
+	UART_UCR2_SRST = 0
+
+	UART_UCR3           = 0x88
+	UART_UCR3_RXDMUXSEL = 2
+
+	UART_UCR4 = 0x8c
+
+	UART_UFCR        = 0x90
+	UART_UFCR_TXTL   = 10
+	UART_UFCR_RFDIV  = 7
+	UART_UFCR_DCEDTE = 6
+	UART_UFCR_RXTL   = 0
+
+	UART_USR2  = 0x98
+	UART_UBIR  = 0xa4
+	UART_UBMR  = 0xa8
+	UART_ONEMS = 0xb0
+	UART_UTS   = 0xb4
+
+	// reference frequency divider (UFCR_RFDIV = 0b100)
+	UART_RFDIV     = 2
+	UART_RFDIV_VAL = 0b100
+)
+
+const (
+	// CCM Serial Clock Divider Register 1, IMX6ULLRM
+	CCM_CSCDR1               uint32 = 0x020c4024
+	CCM_CSCDR1_UART_CLK_SEL         = 6
+	CCM_CSCDR1_UART_CLK_PODF        = 0
+
+	// pll3_80m, PLL3 (480 MHz) / 6
+	UART_PLL3_FREQ = 80000000
+)
+
 const UART_URXD_PRERR = 10
 const UART_URXD_RX_DATA = 0
 const UART_UTS_TXEMPTY = 6
+const UART_UTS_RXEMPTY = 5
+const UART_UTS_TXFULL = 4
+const UART_UTS_SOFTRST = 0
 const UART_USR2_RDR = 0
 
 type uart struct {
-	urxd *uint32
-	utxd *byte
-	uts  *uint32
-	usr2 *uint32
+	sync.Mutex
+
+	// base address, zero when not present on the SoC
+	base uint32
+
+	// clock gate
+	ccgr int
+	cg   int
+
+	// interrupt ID
+	irq int
+
+	// configured baud rate
+	baud int
+
+	// buffered transmission ring (see InitAsync())
+	ring  []byte
+	head  int
+	count int
+
+	urxd  *uint32
+	utxd  *byte
+	ucr1  *uint32
+	ucr2  *uint32
+	ucr3  *uint32
+	ucr4  *uint32
+	ufcr  *uint32
+	ubir  *uint32
+	ubmr  *uint32
+	onems *uint32
+	uts   *uint32
+	usr2  *uint32
+}
+
+func newUART(base uint32, ccgr int, cg int, irq int) *uart {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &uart{
+		base:  base,
+		ccgr:  ccgr,
+		cg:    cg,
+		irq:   irq,
+		urxd:  r(UART_URXD),
+		utxd:  (*byte)(unsafe.Pointer(uintptr(base + UART_UTXD))),
+		ucr1:  r(UART_UCR1),
+		ucr2:  r(UART_UCR2),
+		ucr3:  r(UART_UCR3),
+		ucr4:  r(UART_UCR4),
+		ufcr:  r(UART_UFCR),
+		ubir:  r(UART_UBIR),
+		ubmr:  r(UART_UBMR),
+		onems: r(UART_ONEMS),
+		uts:   r(UART_UTS),
+		usr2:  r(UART_USR2),
+	}
 }
 
+// UART1 and UART2 are statically initialized, as their base addresses are
+// common to all SoC families, so that they can be used for runtime output
+// (e.g. printk) before package initialization.
 var UART1 = &uart{
-	urxd: (*uint32)(unsafe.Pointer(uintptr(UART1_URXD))),
-	utxd: (*byte)(unsafe.Pointer(uintptr(UART1_UTXD))),
-	uts:  (*uint32)(unsafe.Pointer(uintptr(UART1_UTS))),
-	usr2: (*uint32)(unsafe.Pointer(uintptr(UART1_USR2))),
+	base:  UART1_BASE,
+	ccgr:  5,
+	cg:    CCM_CCGR5_UART1,
+	irq:   UART1_IRQ,
+	urxd:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_URXD))),
+	utxd:  (*byte)(unsafe.Pointer(uintptr(UART1_BASE + UART_UTXD))),
+	ucr1:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UCR1))),
+	ucr2:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UCR2))),
+	ucr3:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UCR3))),
+	ucr4:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UCR4))),
+	ufcr:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UFCR))),
+	ubir:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UBIR))),
+	ubmr:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UBMR))),
+	onems: (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_ONEMS))),
+	uts:   (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_UTS))),
+	usr2:  (*uint32)(unsafe.Pointer(uintptr(UART1_BASE + UART_USR2))),
 }
 
 var UART2 = &uart{
-	urxd: (*uint32)(unsafe.Pointer(uintptr(UART2_URXD))),
-	utxd: (*byte)(unsafe.Pointer(uintptr(UART2_UTXD))),
-	uts:  (*uint32)(unsafe.Pointer(uintptr(UART2_UTS))),
-	usr2: (*uint32)(unsafe.Pointer(uintptr(UART2_USR2))),
+	base:  UART2_BASE,
+	ccgr:  0,
+	cg:    CCM_CCGR0_UART2,
+	irq:   UART2_IRQ,
+	urxd:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_URXD))),
+	utxd:  (*byte)(unsafe.Pointer(uintptr(UART2_BASE + UART_UTXD))),
+	ucr1:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UCR1))),
+	ucr2:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UCR2))),
+	ucr3:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UCR3))),
+	ucr4:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UCR4))),
+	ufcr:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UFCR))),
+	ubir:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UBIR))),
+	ubmr:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UBMR))),
+	onems: (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_ONEMS))),
+	uts:   (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_UTS))),
+	usr2:  (*uint32)(unsafe.Pointer(uintptr(UART2_BASE + UART_USR2))),
+}
+
+var UART3 = newUART(UART3_BASE, 1, CCM_CCGR1_UART3, UART3_IRQ)
+var UART4 = newUART(UART4_BASE, 1, CCM_CCGR1_UART4, UART4_IRQ)
+var UART5 = newUART(UART5_BASE, 3, CCM_CCGR3_UART5, UART5_IRQ)
+var UART6 = newUART(Peripherals.UART6Base, 3, CCM_CCGR3_UART6, UART6_IRQ)
+var UART7 = newUART(Peripherals.UART7Base, 5, CCM_CCGR5_UART7, UART7_IRQ)
+var UART8 = newUART(Peripherals.UART8Base, 6, CCM_CCGR6_UART8, UART8_IRQ)
+
+// UART returns the UART controller instance for the argument index (1-8),
+// each instance can be independently initialized and used (e.g. a console
+// and a peripheral link at different baud rates). An error is returned for
+// UARTs not present on the detected SoC family (see Peripherals).
+func UART(n int) (u *uart, err error) {
+	switch n {
+	case 1:
+		u = UART1
+	case 2:
+		u = UART2
+	case 3:
+		u = UART3
+	case 4:
+		u = UART4
+	case 5:
+		u = UART5
+	case 6:
+		u = UART6
+	case 7:
+		u = UART7
+	case 8:
+		u = UART8
+	default:
+		return nil, errors.New("invalid UART index")
+	}
+
+	if u.base == 0 {
+		return nil, errors.New("UART not present")
+	}
+
+	return
+}
+
+// UARTFreq returns the UART clock root frequency, shared by all UART
+// instances, as configured in CCM_CSCDR1.
+func UARTFreq() (hz uint32) {
+	cscdr1 := (*uint32)(unsafe.Pointer(uintptr(CCM_CSCDR1)))
+
+	hz = UART_PLL3_FREQ
+
+	if reg.Get(cscdr1, CCM_CSCDR1_UART_CLK_SEL, 0b1) == 1 {
+		hz = OscFreq()
+	}
+
+	return hz / (reg.Get(cscdr1, CCM_CSCDR1_UART_CLK_PODF, 0b111111) + 1)
 }
 
 func (u *uart) txEmpty() bool {
 	return reg.Get(u.uts, UART_UTS_TXEMPTY, 0b1) == 0
 }
 
+func (u *uart) txFull() bool {
+	return reg.Get(u.uts, UART_UTS_TXFULL, 0b1) == 1
+}
+
 func (u *uart) rxReady() bool {
 	return reg.Get(u.usr2, UART_USR2_RDR, 0b1) == 1
 }
 
-func (u *uart) rxError() bool {
-	return reg.Get(u.urxd, UART_URXD_PRERR, 0b11111) != 0
+// rxError reports the error flags of a received URXD value, which must be
+// read only once as each read pops a character from the RX FIFO.
+func rxError(rx uint32) bool {
+	return (rx>>UART_URXD_PRERR)&0b11111 != 0
+}
+
+// Init initializes and enables the UART for 8-N-1 operation, without
+// hardware flow control, at the argument baud rate.
+//
+// The baud rate divisors are derived from the UART clock root frequency (see
+// UARTFreq()) at the time of initialization, Reconfigure() must be called
+// after any change to the UART clock root.
+func (u *uart) Init(baud int) (err error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if baud <= 0 {
+		return errors.New("invalid baud rate")
+	}
+
+	// stop any buffered transmission
+	if u.ring != nil {
+		u.stopAsync()
+	}
+
+	if err = EnableClock(u.ccgr, u.cg, CCGR_ON); err != nil {
+		return
+	}
+
+	// disable UART and reset transmitter/receiver logic
+	reg.Clear(u.ucr1, UART_UCR1_UARTEN)
+	reg.Clear(u.ucr2, UART_UCR2_SRST)
+	reg.Wait(u.uts, UART_UTS_SOFTRST, 0b1, 0)
+
+	// 8 data bits, 1 stop bit, no parity, ignore RTS
+	reg.Write(u.ucr2, 1<<UART_UCR2_IRTS|1<<UART_UCR2_WS|1<<UART_UCR2_SRST)
+	// RXD muxed input must be selected on i.MX6
+	reg.Write(u.ucr3, 1<<UART_UCR3_RXDMUXSEL)
+	reg.Write(u.ucr4, 0)
+
+	// DCE mode, TX/RX FIFO thresholds at 2 and 1 characters
+	reg.Write(u.ufcr, 2<<UART_UFCR_TXTL|UART_RFDIV_VAL<<UART_UFCR_RFDIV|1<<UART_UFCR_RXTL)
+
+	if err = u.setBaud(baud); err != nil {
+		return
+	}
+
+	u.baud = baud
+
+	reg.Set(u.ucr2, UART_UCR2_TXEN)
+	reg.Set(u.ucr2, UART_UCR2_RXEN)
+	reg.Set(u.ucr1, UART_UCR1_UARTEN)
+
+	return
+}
+
+// Reconfigure re-derives the baud rate divisors, for the baud rate set with
+// Init(), from the current UART clock root frequency.
+//
+// The UART clock root (CCM_CSCDR1) is sourced from PLL3 or the oscillator and
+// is therefore not affected by ARM core frequency changes (see SetARMFreq()),
+// this function is only required when CCM_CSCDR1 or PLL3 are modified.
+func (u *uart) Reconfigure() (err error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.baud == 0 {
+		return errors.New("UART not initialized")
+	}
+
+	// wait for pending transmissions before changing the divisors
+	for u.txEmpty() {
+	}
+
+	return u.setBaud(u.baud)
+}
+
+// setBaud programs the baud rate divisors, using a fixed numerator:
+//
+//	baud = ref / (16 * (UBMR + 1) / (UBIR + 1))
+//	     = ref / (UBMR + 1)	with UBIR = 15
+func (u *uart) setBaud(baud int) error {
+	ref := UARTFreq() / UART_RFDIV
+	ubmr := (ref+uint32(baud)/2)/uint32(baud) - 1
+
+	if ubmr == 0 || ubmr > 0xffff {
+		return errors.New("unsupported baud rate")
+	}
+
+	reg.Write(u.onems, ref/1000)
+	// UBIR must be written before UBMR
+	reg.Write(u.ubir, 15)
+	reg.Write(u.ubmr, ubmr)
+
+	return nil
 }
 
-// Write a single character to the selected serial port.
-func (u *uart) Write(c byte) {
+// Tx transmits a single character to the serial port, waiting for room in
+// the TX FIFO.
+func (u *uart) Tx(c byte) {
+	for u.txFull() {
+		// wait for TX FIFO to have room
+	}
+
 	// transmit data
 	*(u.utxd) = c
 
@@ -75,15 +390,71 @@ func (u *uart) Write(c byte) {
 	}
 }
 
-// Read a single character from the selected serial port.
-func (u *uart) Read() (c byte, valid bool) {
+// Rx receives a single character from the serial port, if available.
+func (u *uart) Rx() (c byte, valid bool) {
 	if !u.rxReady() {
 		return c, false
 	}
 
-	if u.rxError() {
+	rx := *u.urxd
+
+	if rxError(rx) {
 		return c, false
 	}
 
-	return byte(reg.Get(u.urxd, UART_URXD_RX_DATA, 0xff)), true
+	return byte((rx >> UART_URXD_RX_DATA) & 0xff), true
+}
+
+// Write data from buffer to serial port, implementing io.Writer.
+func (u *uart) Write(buf []byte) (n int, err error) {
+	u.Lock()
+	defer u.Unlock()
+
+	for n = 0; n < len(buf); n++ {
+		u.Tx(buf[n])
+	}
+
+	return
+}
+
+// ReadByte reads a single character from the serial port, waiting for its
+// reception, implementing io.ByteReader.
+func (u *uart) ReadByte() (c byte, err error) {
+	for !u.rxReady() {
+		// tamago is single-threaded so we must force giving
+		// other goroutines a chance
+		runtime.Gosched()
+	}
+
+	rx := *u.urxd
+
+	if rxError(rx) {
+		return 0, errors.New("receive error")
+	}
+
+	return byte(rx), nil
+}
+
+// Read available data from serial port to buffer, implementing io.Reader.
+// The function waits for at least one character to be received.
+func (u *uart) Read(buf []byte) (n int, err error) {
+	if len(buf) == 0 {
+		return
+	}
+
+	if buf[0], err = u.ReadByte(); err != nil {
+		return
+	}
+
+	for n = 1; n < len(buf); n++ {
+		c, valid := u.Rx()
+
+		if !valid {
+			break
+		}
+
+		buf[n] = c
+	}
+
+	return
 }
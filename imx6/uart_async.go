@@ -0,0 +1,144 @@
+// NXP i.MX6 UART driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package imx6
+
+import (
+	"errors"
+	"io"
+
+	"github.com/inversepath/tamago/imx6/gic"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// InitAsync initializes the UART as Init() and enables buffered transmission
+// through WriteAsync(), using a ring buffer of the argument size.
+//
+// The ring buffer is drained in the TX FIFO from the UART GIC interrupt
+// handler, raised by the transmitter ready condition (UCR1 TRDYEN) only while
+// data is pending, therefore the GIC must be initialized and serviced by the
+// application (see package gic):
+//
+//	gic.Init()
+//	imx6.UART2.InitAsync(115200, 4096)
+//	gic.Start()
+//
+// A subsequent Init() disables buffered transmission, discarding any pending
+// data.
+func (u *uart) InitAsync(baud int, size int) (err error) {
+	if size <= 0 {
+		return errors.New("invalid buffer size")
+	}
+
+	if err = u.Init(baud); err != nil {
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	u.ring = make([]byte, size)
+	u.head = 0
+	u.count = 0
+
+	gic.SetHandler(u.irq, u.txInterrupt)
+
+	if err = gic.EnableInterrupt(u.irq, UART_PRIORITY); err != nil {
+		gic.SetHandler(u.irq, nil)
+		u.ring = nil
+	}
+
+	return
+}
+
+// stopAsync disables buffered transmission, the UART mutex must be held.
+func (u *uart) stopAsync() {
+	reg.Clear(u.ucr1, UART_UCR1_TRDYEN)
+
+	gic.DisableInterrupt(u.irq)
+	gic.SetHandler(u.irq, nil)
+
+	u.ring = nil
+	u.head = 0
+	u.count = 0
+}
+
+func (u *uart) txInterrupt() {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.ring == nil {
+		return
+	}
+
+	u.drain()
+}
+
+// drain transfers queued data to the TX FIFO, until full, and disables the
+// transmitter ready interrupt once the ring buffer is empty. The UART mutex
+// must be held.
+func (u *uart) drain() {
+	for u.count > 0 && !u.txFull() {
+		*(u.utxd) = u.ring[u.head]
+		u.head = (u.head + 1) % len(u.ring)
+		u.count -= 1
+	}
+
+	if u.count == 0 {
+		reg.Clear(u.ucr1, UART_UCR1_TRDYEN)
+	}
+}
+
+// WriteAsync queues data for buffered transmission, without waiting for room
+// in the TX FIFO, the UART must be initialized with InitAsync().
+//
+// The number of queued bytes is returned, io.ErrShortWrite is returned if
+// the ring buffer cannot hold all data. Data written with Write() or Tx()
+// is not ordered with respect to data queued with WriteAsync().
+func (u *uart) WriteAsync(buf []byte) (n int, err error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.ring == nil {
+		return 0, errors.New("buffered transmission not enabled")
+	}
+
+	size := len(u.ring)
+
+	for n < len(buf) && u.count < size {
+		u.ring[(u.head+u.count)%size] = buf[n]
+		u.count += 1
+		n += 1
+	}
+
+	// fill the TX FIFO right away, the interrupt handler takes care of
+	// the remaining data
+	u.drain()
+
+	if u.count > 0 {
+		reg.Set(u.ucr1, UART_UCR1_TRDYEN)
+	}
+
+	if n < len(buf) {
+		err = io.ErrShortWrite
+	}
+
+	return
+}
+
+// Pending returns the number of bytes queued with WriteAsync() and not yet
+// transferred to the TX FIFO.
+func (u *uart) Pending() int {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.count
+}
@@ -16,6 +16,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/inversepath/tamago/imx6"
 	"github.com/inversepath/tamago/imx6/internal/reg"
 )
 
@@ -30,9 +31,9 @@ const (
 	CCM_ANALOG_PLL_USB1_POWER              = 12
 	CCM_ANALOG_PLL_USB1_EN_USB_CLKS        = 6
 
-	USB_ANALOG_USB1_CHRG_DETECT      uint32 = 0x020c81b0
-	USB_ANALOG_USB1_CHRG_DETECT_EN_B        = 20
-	USB_ANALOG_USB1_CHRG_DETECT_CHK_CHRG_B  = 19
+	USB_ANALOG_USB1_CHRG_DETECT            uint32 = 0x020c81b0
+	USB_ANALOG_USB1_CHRG_DETECT_EN_B              = 20
+	USB_ANALOG_USB1_CHRG_DETECT_CHK_CHRG_B        = 19
 
 	USBPHY1_PWD uint32 = 0x020c9000
 
@@ -108,8 +109,6 @@ const (
 type usb struct {
 	sync.Mutex
 
-	ccgr     *uint32
-	pll      *uint32
 	ctrl     *uint32
 	pwd      *uint32
 	chrg     *uint32
@@ -131,8 +130,6 @@ type usb struct {
 }
 
 var USB1 = &usb{
-	ccgr:     (*uint32)(unsafe.Pointer(uintptr(CCM_CCGR6))),
-	pll:      (*uint32)(unsafe.Pointer(uintptr(CCM_ANALOG_PLL_USB1))),
 	ctrl:     (*uint32)(unsafe.Pointer(uintptr(USBPHY1_CTRL))),
 	pwd:      (*uint32)(unsafe.Pointer(uintptr(USBPHY1_PWD))),
 	chrg:     (*uint32)(unsafe.Pointer(uintptr(USB_ANALOG_USB1_CHRG_DETECT))),
@@ -157,21 +154,13 @@ func (hw *usb) Init() {
 	defer hw.Unlock()
 
 	// enable clock
-	reg.SetN(hw.ccgr, CCM_CCGR6_CG0, 0b11, 0b11)
+	imx6.EnableClock(6, imx6.CCM_CCGR6_USBOH3, imx6.CCGR_ON)
 
-	// power up PLL
-	reg.Set(hw.pll, CCM_ANALOG_PLL_USB1_POWER)
-	reg.Set(hw.pll, CCM_ANALOG_PLL_USB1_EN_USB_CLKS)
-
-	// wait for lock
-	log.Printf("imx6_usb: waiting for PLL lock\n")
-	reg.Wait(hw.pll, CCM_ANALOG_PLL_USB1_LOCK, 0b1, 1)
-
-	// remove bypass
-	reg.Clear(hw.pll, CCM_ANALOG_PLL_USB1_BYPASS)
-
-	// enable PLL
-	reg.Set(hw.pll, CCM_ANALOG_PLL_USB1_ENABLE)
+	// power up PLL and wait for lock
+	if err := imx6.EnablePLL(imx6.PLL3_USB1); err != nil {
+		log.Printf("imx6_usb: %v\n", err)
+		return
+	}
 
 	// soft reset USB1 PHY
 	reg.Set(hw.ctrl, USBPHY1_CTRL_SFTRST)
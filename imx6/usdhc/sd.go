@@ -0,0 +1,370 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+package usdhc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// SD commands (Physical Layer Simplified Specification Version 6.00)
+const (
+	GO_IDLE_STATE        = 0
+	ALL_SEND_CID         = 2
+	SEND_RELATIVE_ADDR   = 3
+	SELECT_CARD          = 7
+	SEND_IF_COND         = 8
+	SEND_CSD             = 9
+	SEND_STATUS          = 13
+	SET_BLOCKLEN         = 16
+	READ_SINGLE_BLOCK    = 17
+	READ_MULTIPLE_BLOCK  = 18
+	WRITE_BLOCK          = 24
+	WRITE_MULTIPLE_BLOCK = 25
+	APP_CMD              = 55
+
+	// application specific commands
+	SET_BUS_WIDTH   = 6
+	SD_SEND_OP_COND = 41
+)
+
+const (
+	// CMD8 arguments, 2.7-3.6V and check pattern
+	IF_COND_VHS     = 0x1 << 8
+	IF_COND_PATTERN = 0xaa
+
+	// ACMD41 arguments
+	OCR_BUSY = 1 << 31
+	OCR_HCS  = 1 << 30
+	// 3.2-3.4V
+	OCR_VDD = 0x300000
+
+	// ACMD6 argument
+	BUS_WIDTH_4BIT = 0b10
+
+	// card status (R1)
+	STATUS_READY_FOR_DATA = 1 << 8
+	STATUS_CURRENT_STATE  = 9
+	CURRENT_STATE_TRAN    = 4
+
+	// maximum ACMD41 initialization duration
+	INIT_TIMEOUT = 1 * time.Second
+)
+
+// Init initializes the controller and the inserted SD card, with the
+// argument data bus width (1 or 4).
+func (hw *usdhc) Init(width int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if width != 1 && width != 4 {
+		return errors.New("invalid bus width")
+	}
+
+	if err = imx6.EnableClock(6, hw.cg, imx6.CCGR_ON); err != nil {
+		return
+	}
+
+	if err = hw.reset(USDHC_SYS_CTRL_RSTA); err != nil {
+		return
+	}
+
+	// enable all status flags (polled, not signaled)
+	reg.Write(hw.int_en, 0xffffffff)
+
+	// little endian, 1-bit bus, ADMA2
+	reg.Write(hw.prot_ctrl, EMODE_LITTLE_ENDIAN<<USDHC_PROT_CTRL_EMODE|DMASEL_ADMA2<<USDHC_PROT_CTRL_DMASEL)
+
+	// maximum data timeout
+	reg.SetN(hw.sys_ctrl, USDHC_SYS_CTRL_DTOCV, 0xf, 0xe)
+
+	hw.setClock(IDENTIFICATION_FREQ)
+
+	// send 80 initialization clocks
+	reg.Set(hw.sys_ctrl, USDHC_SYS_CTRL_INITA)
+	reg.WaitFor(CommandTimeout, hw.sys_ctrl, USDHC_SYS_CTRL_INITA, 0b1, 0)
+
+	if err = hw.initCard(); err != nil {
+		return
+	}
+
+	if width == 4 {
+		if err = hw.cmd(APP_CMD, hw.rca<<16, RSP_R1, false); err != nil {
+			return
+		}
+
+		if err = hw.cmd(SET_BUS_WIDTH, BUS_WIDTH_4BIT, RSP_R1, false); err != nil {
+			return
+		}
+
+		reg.SetN(hw.prot_ctrl, USDHC_PROT_CTRL_DTW, 0b11, DTW_4BIT)
+	}
+
+	hw.setClock(DEFAULT_SPEED_FREQ)
+
+	return
+}
+
+func (hw *usdhc) initCard() (err error) {
+	if err = hw.cmd(GO_IDLE_STATE, 0, RSP_NONE, false); err != nil {
+		return
+	}
+
+	// SD Memory Card initialization (Card Initialization and
+	// Identification Flow, SD Physical Layer Specification)
+	v2 := hw.cmd(SEND_IF_COND, IF_COND_VHS|IF_COND_PATTERN, RSP_R7, false) == nil
+
+	if v2 && hw.rsp(0)&0xfff != IF_COND_VHS|IF_COND_PATTERN {
+		return errors.New("unusable card")
+	}
+
+	arg := uint32(OCR_VDD)
+
+	if v2 {
+		arg |= OCR_HCS
+	}
+
+	start := time.Now()
+
+	for {
+		if err = hw.cmd(APP_CMD, 0, RSP_R1, false); err != nil {
+			return
+		}
+
+		if err = hw.cmd(SD_SEND_OP_COND, arg, RSP_R3, false); err != nil {
+			return
+		}
+
+		ocr := hw.rsp(0)
+
+		if ocr&OCR_BUSY != 0 {
+			hw.highCapacity = ocr&OCR_HCS != 0
+			break
+		}
+
+		if time.Since(start) > INIT_TIMEOUT {
+			return errors.New("card initialization timeout")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err = hw.cmd(ALL_SEND_CID, 0, RSP_R2, false); err != nil {
+		return
+	}
+
+	for i := range hw.cid {
+		hw.cid[i] = hw.rsp(i)
+	}
+
+	if err = hw.cmd(SEND_RELATIVE_ADDR, 0, RSP_R6, false); err != nil {
+		return
+	}
+
+	hw.rca = hw.rsp(0) >> 16
+
+	if err = hw.cmd(SEND_CSD, hw.rca<<16, RSP_R2, false); err != nil {
+		return
+	}
+
+	hw.blocks = hw.capacity()
+
+	if err = hw.cmd(SELECT_CARD, hw.rca<<16, RSP_R1B, false); err != nil {
+		return
+	}
+
+	if !hw.highCapacity {
+		err = hw.cmd(SET_BLOCKLEN, BLOCK_SIZE, RSP_R1, false)
+	}
+
+	return
+}
+
+// csd returns CSD register bits, the uSDHC strips the CRC byte from 136-bit
+// responses, shifting CSD bit n at response bit n - 8.
+func (hw *usdhc) csd(pos int, width int) (val uint32) {
+	for i := 0; i < width; i++ {
+		bit := pos - 8 + i
+		val |= ((hw.rsp(bit/32) >> uint(bit%32)) & 1) << uint(i)
+	}
+
+	return
+}
+
+// capacity returns the number of 512 byte blocks from the CSD register, which
+// must be the last received response.
+func (hw *usdhc) capacity() uint32 {
+	switch hw.csd(126, 2) {
+	case 0:
+		// CSD Version 1.0
+		cSize := hw.csd(62, 12)
+		mult := hw.csd(47, 3)
+		blLen := hw.csd(80, 4)
+
+		return (cSize + 1) << (mult + 2) << blLen / BLOCK_SIZE
+	case 1:
+		// CSD Version 2.0
+		return (hw.csd(48, 22) + 1) * 1024
+	}
+
+	return 0
+}
+
+// Blocks returns the number of 512 byte blocks available on the card.
+func (hw *usdhc) Blocks() uint32 {
+	return hw.blocks
+}
+
+// CID returns the card identification register (as received from the
+// controller, without CRC).
+func (hw *usdhc) CID() [4]uint32 {
+	return hw.cid
+}
+
+// waitReady waits, after a write, for the card to complete programming.
+func (hw *usdhc) waitReady() (err error) {
+	start := time.Now()
+
+	for {
+		if err = hw.cmd(SEND_STATUS, hw.rca<<16, RSP_R1, false); err != nil {
+			return
+		}
+
+		status := hw.rsp(0)
+
+		if status&STATUS_READY_FOR_DATA != 0 && (status>>STATUS_CURRENT_STATE)&0xf == CURRENT_STATE_TRAN {
+			return
+		}
+
+		if time.Since(start) > DataTimeout {
+			return errors.New("card busy timeout")
+		}
+	}
+}
+
+func (hw *usdhc) rw(lba uint32, buf []byte, read bool) (err error) {
+	if hw.rca == 0 {
+		return errors.New("card not initialized")
+	}
+
+	if len(buf) == 0 || len(buf)%BLOCK_SIZE != 0 {
+		return errors.New("invalid buffer size")
+	}
+
+	for len(buf) > 0 {
+		blocks := uint32(len(buf) / BLOCK_SIZE)
+
+		if blocks > MAX_BLOCKS {
+			blocks = MAX_BLOCKS
+		}
+
+		if uint64(lba)+uint64(blocks) > uint64(hw.blocks) {
+			return errors.New("invalid block address")
+		}
+
+		arg := lba
+
+		if !hw.highCapacity {
+			// standard capacity cards use byte addressing
+			arg *= BLOCK_SIZE
+		}
+
+		var index uint32
+
+		switch {
+		case read && blocks == 1:
+			index = READ_SINGLE_BLOCK
+		case read:
+			index = READ_MULTIPLE_BLOCK
+		case blocks == 1:
+			index = WRITE_BLOCK
+		default:
+			index = WRITE_MULTIPLE_BLOCK
+		}
+
+		size := blocks * BLOCK_SIZE
+
+		if err = hw.transfer(index, read, arg, blocks, buf[0:size]); err != nil {
+			return
+		}
+
+		if !read {
+			if err = hw.waitReady(); err != nil {
+				return
+			}
+		}
+
+		buf = buf[size:]
+		lba += blocks
+	}
+
+	return
+}
+
+// ReadBlocks reads a multiple of 512 byte blocks, starting from the argument
+// logical block address.
+func (hw *usdhc) ReadBlocks(lba uint32, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.rw(lba, buf, true)
+}
+
+// WriteBlocks writes a multiple of 512 byte blocks, starting from the
+// argument logical block address.
+func (hw *usdhc) WriteBlocks(lba uint32, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.rw(lba, buf, false)
+}
+
+// ReadAt implements io.ReaderAt, offsets and sizes which are not block
+// aligned are supported by reading their enclosing blocks.
+func (hw *usdhc) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("invalid offset")
+	}
+
+	start := off / BLOCK_SIZE
+	end := (off + int64(len(p)) + BLOCK_SIZE - 1) / BLOCK_SIZE
+
+	if end == start {
+		return
+	}
+
+	buf := make([]byte, (end-start)*BLOCK_SIZE)
+
+	if err = hw.ReadBlocks(uint32(start), buf); err != nil {
+		return
+	}
+
+	n = copy(p, buf[off%BLOCK_SIZE:])
+
+	return
+}
+
+// WriteAt implements io.WriterAt, offsets and sizes must be block aligned.
+func (hw *usdhc) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off%BLOCK_SIZE != 0 {
+		return 0, errors.New("invalid offset")
+	}
+
+	if err = hw.WriteBlocks(uint32(off/BLOCK_SIZE), p); err != nil {
+		return
+	}
+
+	return len(p), nil
+}
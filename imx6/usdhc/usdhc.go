@@ -0,0 +1,421 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package usdhc implements a driver for the NXP i.MX6 Ultra Secured Digital
+// Host Controller (uSDHC), supporting SD cards in default speed mode with
+// ADMA2 data transfers.
+//
+// The uSDHC pads must be configured (see package iomuxc) before use, for
+// example:
+//
+//	err := usdhc.USDHC1.Init(4)
+//	buf := make([]byte, usdhc.BLOCK_SIZE)
+//	err = usdhc.USDHC1.ReadBlocks(0, buf)
+//
+// UHS-I voltage switching and tuning sequences are not supported, SD cards are
+// operated at 3.3V signaling in default speed mode (25 MHz).
+package usdhc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/cache"
+	"github.com/inversepath/tamago/imx6/internal/mem"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// uSDHC Memory Map/Register Definition, IMX6ULLRM
+const (
+	USDHC1_BASE uint32 = 0x02190000
+	USDHC2_BASE uint32 = 0x02194000
+
+	USDHC_BLK_ATT         = 0x04
+	USDHC_BLK_ATT_BLKCNT  = 16
+	USDHC_BLK_ATT_BLKSIZE = 0
+
+	USDHC_CMD_ARG = 0x08
+
+	USDHC_CMD_XFR_TYP        = 0x0c
+	USDHC_CMD_XFR_TYP_CMDINX = 24
+	USDHC_CMD_XFR_TYP_DPSEL  = 21
+	USDHC_CMD_XFR_TYP_CICEN  = 20
+	USDHC_CMD_XFR_TYP_CCCEN  = 19
+	USDHC_CMD_XFR_TYP_RSPTYP = 16
+
+	USDHC_CMD_RSP0 = 0x10
+	USDHC_CMD_RSP1 = 0x14
+	USDHC_CMD_RSP2 = 0x18
+	USDHC_CMD_RSP3 = 0x1c
+
+	USDHC_PRES_STATE       = 0x24
+	USDHC_PRES_STATE_CINST = 16
+	USDHC_PRES_STATE_SDSTB = 3
+	USDHC_PRES_STATE_DLA   = 2
+	USDHC_PRES_STATE_CDIHB = 1
+	USDHC_PRES_STATE_CIHB  = 0
+
+	USDHC_PROT_CTRL        = 0x28
+	USDHC_PROT_CTRL_DMASEL = 8
+	USDHC_PROT_CTRL_EMODE  = 4
+	USDHC_PROT_CTRL_DTW    = 1
+
+	USDHC_SYS_CTRL         = 0x2c
+	USDHC_SYS_CTRL_INITA   = 27
+	USDHC_SYS_CTRL_RSTD    = 26
+	USDHC_SYS_CTRL_RSTC    = 25
+	USDHC_SYS_CTRL_RSTA    = 24
+	USDHC_SYS_CTRL_DTOCV   = 16
+	USDHC_SYS_CTRL_SDCLKFS = 8
+	USDHC_SYS_CTRL_DVS     = 4
+
+	USDHC_INT_STATUS      = 0x30
+	USDHC_INT_STATUS_DMAE = 28
+	USDHC_INT_STATUS_DEBE = 22
+	USDHC_INT_STATUS_DCE  = 21
+	USDHC_INT_STATUS_DTOE = 20
+	USDHC_INT_STATUS_CIE  = 19
+	USDHC_INT_STATUS_CEBE = 18
+	USDHC_INT_STATUS_CCE  = 17
+	USDHC_INT_STATUS_CTOE = 16
+	USDHC_INT_STATUS_TC   = 1
+	USDHC_INT_STATUS_CC   = 0
+
+	USDHC_INT_STATUS_EN = 0x34
+
+	USDHC_MIX_CTRL        = 0x48
+	USDHC_MIX_CTRL_MSBSEL = 5
+	USDHC_MIX_CTRL_DTDSEL = 4
+	USDHC_MIX_CTRL_AC12EN = 2
+	USDHC_MIX_CTRL_BCEN   = 1
+	USDHC_MIX_CTRL_DMAEN  = 0
+
+	USDHC_ADMA_ERR_STATUS = 0x54
+	USDHC_ADMA_SYS_ADDR   = 0x58
+)
+
+const (
+	// response length (CMD_XFR_TYP RSPTYP)
+	RSPTYP_NONE    = 0b00
+	RSPTYP_136     = 0b01
+	RSPTYP_48      = 0b10
+	RSPTYP_48_BUSY = 0b11
+
+	// data transfer width (PROT_CTRL DTW)
+	DTW_1BIT = 0b00
+	DTW_4BIT = 0b01
+
+	// DMA selection (PROT_CTRL DMASEL)
+	DMASEL_ADMA2 = 0b10
+	// little endian mode (PROT_CTRL EMODE)
+	EMODE_LITTLE_ENDIAN = 0b10
+
+	// ADMA2 descriptor attributes (Table "ADMA2 descriptor", IMX6ULLRM)
+	ADMA2_VALID = 1 << 0
+	ADMA2_END   = 1 << 1
+	ADMA2_TRAN  = 0b10 << 4
+	// maximum data length per descriptor (multiple of BLOCK_SIZE)
+	ADMA2_MAX_LENGTH = 0x8000
+
+	// SD clock frequencies
+	IDENTIFICATION_FREQ = 400000
+	DEFAULT_SPEED_FREQ  = 25000000
+
+	BLOCK_SIZE = 512
+	// maximum number of blocks per command (BLK_ATT BLKCNT)
+	MAX_BLOCKS = 0xffff
+)
+
+// DMA buffers are cache line aligned to prevent cache maintenance operations
+// from affecting adjacent data.
+const cacheLineSize = 64
+
+// SD response types (SD Physical Layer Simplified Specification)
+const (
+	RSP_NONE = iota
+	RSP_R1
+	RSP_R1B
+	RSP_R2
+	RSP_R3
+	RSP_R6
+	RSP_R7
+)
+
+// CommandTimeout is the maximum duration for command completion.
+var CommandTimeout = 10 * time.Millisecond
+
+// DataTimeout is the maximum duration for data transfer completion.
+var DataTimeout = 1 * time.Second
+
+type usdhc struct {
+	sync.Mutex
+
+	// controller index
+	n int
+	// clock gate
+	cg int
+
+	blk_att    *uint32
+	cmd_arg    *uint32
+	cmd_xfr    *uint32
+	cmd_rsp    [4]*uint32
+	pres_state *uint32
+	prot_ctrl  *uint32
+	sys_ctrl   *uint32
+	int_status *uint32
+	int_en     *uint32
+	mix_ctrl   *uint32
+	adma_err   *uint32
+	adma_addr  *uint32
+
+	// card information
+	rca          uint32
+	highCapacity bool
+	blocks       uint32
+	cid          [4]uint32
+}
+
+func newUSDHC(n int, base uint32, cg int) *usdhc {
+	r := func(off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(uintptr(base + off)))
+	}
+
+	return &usdhc{
+		n:          n,
+		cg:         cg,
+		blk_att:    r(USDHC_BLK_ATT),
+		cmd_arg:    r(USDHC_CMD_ARG),
+		cmd_xfr:    r(USDHC_CMD_XFR_TYP),
+		cmd_rsp:    [4]*uint32{r(USDHC_CMD_RSP0), r(USDHC_CMD_RSP1), r(USDHC_CMD_RSP2), r(USDHC_CMD_RSP3)},
+		pres_state: r(USDHC_PRES_STATE),
+		prot_ctrl:  r(USDHC_PROT_CTRL),
+		sys_ctrl:   r(USDHC_SYS_CTRL),
+		int_status: r(USDHC_INT_STATUS),
+		int_en:     r(USDHC_INT_STATUS_EN),
+		mix_ctrl:   r(USDHC_MIX_CTRL),
+		adma_err:   r(USDHC_ADMA_ERR_STATUS),
+		adma_addr:  r(USDHC_ADMA_SYS_ADDR),
+	}
+}
+
+var USDHC1 = newUSDHC(1, USDHC1_BASE, imx6.CCM_CCGR6_USDHC1)
+var USDHC2 = newUSDHC(2, USDHC2_BASE, imx6.CCM_CCGR6_USDHC2)
+
+// setClock sets the SD clock to the highest frequency lower or equal to the
+// argument one:
+//
+//	SD clock = root / (2 * SDCLKFS) / (DVS + 1)	(SDCLKFS = 0 divides by 1)
+func (hw *usdhc) setClock(hz uint32) {
+	root := imx6.USDHCFreq(hw.n)
+
+	pre := uint32(1)
+	div := uint32(1)
+
+	for root/(16*pre) > hz && pre < 256 {
+		pre *= 2
+	}
+
+	for root/(pre*div) > hz && div < 16 {
+		div++
+	}
+
+	reg.SetN(hw.sys_ctrl, USDHC_SYS_CTRL_SDCLKFS, 0xff, pre>>1)
+	reg.SetN(hw.sys_ctrl, USDHC_SYS_CTRL_DVS, 0xf, div-1)
+
+	reg.WaitFor(CommandTimeout, hw.pres_state, USDHC_PRES_STATE_SDSTB, 0b1, 1)
+}
+
+func (hw *usdhc) reset(pos int) error {
+	reg.Set(hw.sys_ctrl, pos)
+
+	if !reg.WaitFor(CommandTimeout, hw.sys_ctrl, pos, 0b1, 0) {
+		return errors.New("controller reset timeout")
+	}
+
+	return nil
+}
+
+// cmd issues an SD command, data transfers must be configured beforehand for
+// commands with data (see transfer()).
+func (hw *usdhc) cmd(index uint32, arg uint32, rsp int, data bool) (err error) {
+	lines := 1 << USDHC_PRES_STATE_CIHB
+
+	if data || rsp == RSP_R1B {
+		lines |= 1 << USDHC_PRES_STATE_CDIHB
+	}
+
+	if !reg.WaitFor(CommandTimeout, hw.pres_state, 0, lines, 0) {
+		return errors.New("command line busy")
+	}
+
+	// clear all status flags
+	reg.Write(hw.int_status, 0xffffffff)
+
+	xfr := index << USDHC_CMD_XFR_TYP_CMDINX
+
+	switch rsp {
+	case RSP_R1, RSP_R6, RSP_R7:
+		xfr |= RSPTYP_48 << USDHC_CMD_XFR_TYP_RSPTYP
+		xfr |= 1<<USDHC_CMD_XFR_TYP_CCCEN | 1<<USDHC_CMD_XFR_TYP_CICEN
+	case RSP_R1B:
+		xfr |= RSPTYP_48_BUSY << USDHC_CMD_XFR_TYP_RSPTYP
+		xfr |= 1<<USDHC_CMD_XFR_TYP_CCCEN | 1<<USDHC_CMD_XFR_TYP_CICEN
+	case RSP_R2:
+		xfr |= RSPTYP_136 << USDHC_CMD_XFR_TYP_RSPTYP
+		xfr |= 1 << USDHC_CMD_XFR_TYP_CCCEN
+	case RSP_R3:
+		// no CRC and index
+		xfr |= RSPTYP_48 << USDHC_CMD_XFR_TYP_RSPTYP
+	}
+
+	if data {
+		xfr |= 1 << USDHC_CMD_XFR_TYP_DPSEL
+	} else {
+		reg.ClearN(hw.mix_ctrl, USDHC_MIX_CTRL_DMAEN, 0b111111)
+	}
+
+	reg.Write(hw.cmd_arg, arg)
+	reg.Write(hw.cmd_xfr, xfr)
+
+	errMask := uint32(1<<USDHC_INT_STATUS_CTOE | 1<<USDHC_INT_STATUS_CCE | 1<<USDHC_INT_STATUS_CEBE | 1<<USDHC_INT_STATUS_CIE)
+	start := time.Now()
+
+	for {
+		status := reg.Read(hw.int_status)
+
+		if status&errMask != 0 {
+			hw.reset(USDHC_SYS_CTRL_RSTC)
+			return fmt.Errorf("CMD%d error, status:%#x", index, status)
+		}
+
+		if status&(1<<USDHC_INT_STATUS_CC) != 0 {
+			break
+		}
+
+		if time.Since(start) > CommandTimeout {
+			hw.reset(USDHC_SYS_CTRL_RSTC)
+			return fmt.Errorf("CMD%d timeout", index)
+		}
+	}
+
+	reg.Write(hw.int_status, 1<<USDHC_INT_STATUS_CC)
+
+	if rsp == RSP_R1B {
+		// wait for the card to release the busy signal on DAT0
+		if !reg.WaitFor(DataTimeout, hw.pres_state, USDHC_PRES_STATE_CDIHB, 0b1, 0) {
+			return fmt.Errorf("CMD%d busy timeout", index)
+		}
+	}
+
+	return
+}
+
+func (hw *usdhc) rsp(i int) uint32 {
+	return reg.Read(hw.cmd_rsp[i])
+}
+
+// transfer issues a data transfer command, through ADMA2, for the argument
+// number of blocks.
+func (hw *usdhc) transfer(index uint32, read bool, arg uint32, blocks uint32, buf []byte) (err error) {
+	size := uint32(len(buf))
+
+	if blocks == 0 || size != blocks*BLOCK_SIZE {
+		return errors.New("invalid transfer size")
+	}
+
+	data := mem.NewAlignmentBuffer(uintptr(size), cacheLineSize)
+
+	if !read {
+		mem.Copy(data, buf)
+	}
+
+	// ADMA2 descriptor table, 64-bit descriptors
+	n := (size + ADMA2_MAX_LENGTH - 1) / ADMA2_MAX_LENGTH
+	desc := mem.NewAlignmentBuffer(uintptr(8*n), cacheLineSize)
+	d := desc.Data()
+
+	for i := uint32(0); i < n; i++ {
+		length := size - i*ADMA2_MAX_LENGTH
+
+		if length > ADMA2_MAX_LENGTH {
+			length = ADMA2_MAX_LENGTH
+		}
+
+		attr := uint16(ADMA2_VALID | ADMA2_TRAN)
+
+		if i == n-1 {
+			attr |= ADMA2_END
+		}
+
+		binary.LittleEndian.PutUint16(d[i*8:], attr)
+		binary.LittleEndian.PutUint16(d[i*8+2:], uint16(length))
+		binary.LittleEndian.PutUint32(d[i*8+4:], uint32(data.Addr)+i*ADMA2_MAX_LENGTH)
+	}
+
+	reg.Write(hw.blk_att, blocks<<USDHC_BLK_ATT_BLKCNT|BLOCK_SIZE<<USDHC_BLK_ATT_BLKSIZE)
+	reg.Write(hw.adma_addr, uint32(desc.Addr))
+
+	mix := uint32(1<<USDHC_MIX_CTRL_DMAEN | 1<<USDHC_MIX_CTRL_BCEN)
+
+	if read {
+		mix |= 1 << USDHC_MIX_CTRL_DTDSEL
+	}
+
+	if blocks > 1 {
+		// automatic CMD12 (STOP_TRANSMISSION)
+		mix |= 1<<USDHC_MIX_CTRL_MSBSEL | 1<<USDHC_MIX_CTRL_AC12EN
+	}
+
+	reg.SetN(hw.mix_ctrl, USDHC_MIX_CTRL_DMAEN, 0b111111, mix)
+
+	// ensure descriptors and data reach memory before DMA
+	cache.FlushData()
+
+	if err = hw.cmd(index, arg, RSP_R1, true); err != nil {
+		return
+	}
+
+	errMask := uint32(1<<USDHC_INT_STATUS_DMAE | 1<<USDHC_INT_STATUS_DEBE | 1<<USDHC_INT_STATUS_DCE | 1<<USDHC_INT_STATUS_DTOE)
+	start := time.Now()
+
+	for {
+		status := reg.Read(hw.int_status)
+
+		if status&errMask != 0 {
+			hw.reset(USDHC_SYS_CTRL_RSTD)
+			return fmt.Errorf("CMD%d data error, status:%#x adma:%#x", index, status, reg.Read(hw.adma_err))
+		}
+
+		if status&(1<<USDHC_INT_STATUS_TC) != 0 {
+			break
+		}
+
+		if time.Since(start) > DataTimeout {
+			hw.reset(USDHC_SYS_CTRL_RSTD)
+			return fmt.Errorf("CMD%d data timeout", index)
+		}
+	}
+
+	reg.Write(hw.int_status, 1<<USDHC_INT_STATUS_TC)
+
+	if read {
+		// discard stale cache lines before accessing DMA results
+		cache.FlushData()
+		copy(buf, data.Data())
+	}
+
+	return
+}
@@ -0,0 +1,165 @@
+// NXP Watchdog Timer (WDOG) driver
+// https://github.com/inversepath/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+//
+// +build tamago,arm
+
+// Package wdog implements a driver for the NXP i.MX6 Watchdog Timer (WDOG).
+//
+// Once enabled the watchdog cannot be disabled and must be serviced, before
+// its timeout elapses, to prevent a system reset:
+//
+//	wdog.WDOG1.Enable(10 * time.Second)
+//
+//	go func() {
+//		for {
+//			wdog.WDOG1.Service()
+//			time.Sleep(1 * time.Second)
+//		}
+//	}()
+package wdog
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/inversepath/tamago/imx6"
+	"github.com/inversepath/tamago/imx6/internal/reg"
+)
+
+// WDOG Memory Map/Register Definition, IMX6ULLRM
+const (
+	WDOG1_BASE uint32 = 0x020bc000
+
+	WDOG_WCR     = 0x00
+	WDOG_WCR_WT  = 8
+	WDOG_WCR_WDA = 5
+	WDOG_WCR_SRS = 4
+	WDOG_WCR_WDT = 3
+	WDOG_WCR_WDE = 2
+
+	WDOG_WSR = 0x02
+
+	WDOG_WRSR      = 0x04
+	WDOG_WRSR_POR  = 4
+	WDOG_WRSR_TOUT = 1
+	WDOG_WRSR_SFTW = 0
+
+	WDOG_WMCR     = 0x08
+	WDOG_WMCR_PDE = 0
+
+	// service sequence
+	WDOG_SERVICE_1 = 0x5555
+	WDOG_SERVICE_2 = 0xaaaa
+
+	// timeout resolution (WT field)
+	WDOG_TIMEOUT_STEP = 500 * time.Millisecond
+	// maximum WT field value
+	WDOG_WT_MAX = 0xff
+)
+
+type wdog struct {
+	sync.Mutex
+
+	// clock gate
+	cg int
+
+	wcr  *uint16
+	wsr  *uint16
+	wrsr *uint16
+	wmcr *uint16
+}
+
+var WDOG1 = &wdog{
+	cg:   imx6.CCM_CCGR3_WDOG1,
+	wcr:  (*uint16)(unsafe.Pointer(uintptr(WDOG1_BASE + WDOG_WCR))),
+	wsr:  (*uint16)(unsafe.Pointer(uintptr(WDOG1_BASE + WDOG_WSR))),
+	wrsr: (*uint16)(unsafe.Pointer(uintptr(WDOG1_BASE + WDOG_WRSR))),
+	wmcr: (*uint16)(unsafe.Pointer(uintptr(WDOG1_BASE + WDOG_WMCR))),
+}
+
+// Enable enables the watchdog with the argument timeout, which is rounded up
+// to the 0.5 seconds resolution and clamped to the supported range (0.5 to
+// 128 seconds). The watchdog cannot be disabled once enabled, further calls
+// only update its timeout.
+//
+// A timeout asserts both a system reset and the WDOG_B signal.
+func (hw *wdog) Enable(timeout time.Duration) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	imx6.EnableClock(3, hw.cg, imx6.CCGR_ON)
+
+	// WT = (timeout / 0.5s) - 1
+	wt := (timeout + WDOG_TIMEOUT_STEP - 1) / WDOG_TIMEOUT_STEP
+
+	if wt > 0 {
+		wt -= 1
+	}
+
+	if wt > WDOG_WT_MAX {
+		wt = WDOG_WT_MAX
+	}
+
+	// disable the power-down counter, which would otherwise reset the
+	// system 16 seconds after boot
+	reg.Write16(hw.wmcr, 0)
+
+	reg.SetN16(hw.wcr, WDOG_WCR_WT, 0xff, uint16(wt))
+
+	// service before enabling to start from a full timeout period
+	hw.service()
+
+	// keep SRS and WDA negated, assert WDOG_B on timeout
+	reg.SetN16(hw.wcr, WDOG_WCR_SRS, 0b1, 1)
+	reg.SetN16(hw.wcr, WDOG_WCR_WDA, 0b1, 1)
+	reg.SetN16(hw.wcr, WDOG_WCR_WDT, 0b1, 1)
+	reg.SetN16(hw.wcr, WDOG_WCR_WDE, 0b1, 1)
+}
+
+func (hw *wdog) service() {
+	reg.Write16(hw.wsr, WDOG_SERVICE_1)
+	reg.Write16(hw.wsr, WDOG_SERVICE_2)
+}
+
+// Service reloads the watchdog counter, with the configured timeout, to
+// prevent a system reset.
+func (hw *wdog) Service() {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.service()
+}
+
+// Reset asserts an immediate software reset of the system (and the WDOG_B
+// signal), the function never returns.
+func (hw *wdog) Reset() {
+	hw.Lock()
+
+	imx6.EnableClock(3, hw.cg, imx6.CCGR_ON)
+
+	// clear SRS (software reset) and WDA (WDOG_B assertion)
+	reg.Write16(hw.wcr, 1<<WDOG_WCR_WDE)
+
+	for {
+		// wait for reset
+	}
+}
+
+// TimeoutReset returns whether the last system reset was caused by a
+// watchdog timeout (WRSR).
+func (hw *wdog) TimeoutReset() bool {
+	return reg.Get16(hw.wrsr, WDOG_WRSR_TOUT, 0b1) == 1
+}
+
+// SoftwareReset returns whether the last system reset was caused by a
+// software reset (WRSR).
+func (hw *wdog) SoftwareReset() bool {
+	return reg.Get16(hw.wrsr, WDOG_WRSR_SFTW, 0b1) == 1
+}
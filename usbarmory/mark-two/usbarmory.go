@@ -24,7 +24,19 @@ import (
 //go:linkname ramSize runtime.ramSize
 var ramSize uint32 = 0x20000000 // 512 MB
 
+// Console is the UART used for runtime standard output (e.g. print(),
+// panics), it can be changed to any other instance (see imx6.UART()).
+//
+// Runtime output before package initialization, when Console is not yet set,
+// is always sent to UART2.
+var Console = imx6.UART2
+
 //go:linkname printk runtime.printk
 func printk(c byte) {
-	imx6.UART2.Write(c)
+	if Console == nil {
+		imx6.UART2.Tx(c)
+		return
+	}
+
+	Console.Tx(c)
 }